@@ -2,34 +2,38 @@
 - type: log
   enabled: true
   paths:
-      - {{ .HostDir }}/{{ .File }}
-  scan_frequency: 10s
+  {{range .Paths}}
+      - {{ yamlString . }}
+  {{end}}
+  scan_frequency: {{ seconds .Tail.ScanFrequency }}s
   fields_under_root: true
   {{if .Stdout}}
-  docker-json: true
+  docker-json:
+    stream: {{ .Stream }}
+    partial: {{ .Partial }}
+  max_bytes: {{ .MaxBytes }}
   {{end}}
   {{if eq .Format "json"}}
   json.keys_under_root: true
   {{end}}
   fields:
-      {{range $key, $value := .Tags}}
-      {{ $key }}: {{ $value }}
-      {{end}}
-      {{range $key, $value := $.container}}
-      {{ $key }}: {{ $value }}
-      {{end}}
-  tail_files: false
-  close_inactive: 2h
+{{ merge .Tags $.container | nest | escapeVars | toYaml 6 }}
+  tail_files: {{ not .Tail.FromHead }}
+  {{if .Tail.IgnoreOlder}}
+  ignore_older: {{ seconds .Tail.IgnoreOlder }}s
+  {{end}}
+  close_inactive: {{ seconds .Tail.CloseInactive }}s
   close_eof: false
   close_removed: true
   clean_removed: true
-  close_renamed: false
+  close_renamed: {{ eq (seconds .Tail.RotateWait) 0 }}
+  {{if .Multiline}}
   multiline:
-    pattern: {{ if eq .MultlinePattern "" }} {{ . MultlinePattern }} {{else}}^\d{4}\-\d{2}\-\d{2}T\d{2}:\d{2}:\d{2}{{end}}
+    pattern: {{ yamlString .Multiline }}
     negate: true
     match: after
-  encoding: utf-8
-  document_type: springboot
-  exclude_files: [".gz$",".zip$"]
+  {{end}}
+  encoding: {{ .Tail.Encoding }}
+  exclude_files: {{ toJson .Tail.ExcludeRegexps }}
 
 {{end}}
@@ -2,7 +2,7 @@
 <source>
   @type tail
   tag docker.{{ $.containerId }}.{{ .Name }}
-  path {{ .HostDir }}/{{ .File }}
+  path {{ join "," .Paths | fluentdString }}
 
   <parse>
   {{if .Stdout}}
@@ -13,7 +13,7 @@
   {{ $time_key := "" }}
   {{if .FormatConfig}}
   {{range $key, $value := .FormatConfig}}
-  {{ $key }} {{ $value }}
+  {{ $key }} {{ fluentdString $value }}
   {{end}}
   {{end}}
   {{ if .EstimateTime }}
@@ -22,28 +22,97 @@
   keep_time_key true
   </parse>
 
-  read_from_head true
+  read_from_head {{ .Tail.FromHead }}
+  refresh_interval {{ seconds .Tail.ScanFrequency }}
+  rotate_wait {{ seconds .Tail.RotateWait }}
+  {{if .Tail.IgnoreOlder}}
+  limit_recently_modified {{ seconds .Tail.IgnoreOlder }}
+  {{end}}
+  {{if ne .Tail.RubyEncoding "UTF-8"}}
+  from_encoding {{ .Tail.RubyEncoding }}
+  encoding UTF-8
+  {{end}}
+  {{if .Tail.Exclude}}
+  exclude_path {{ toJson (.Tail.ExcludePaths .Paths) | fluentdString }}
+  {{end}}
   pos_file /pilot/pos/{{ $.containerId }}.{{ .Name }}.pos
 </source>
 
+{{if and .Stdout .Partial}}
+<filter docker.{{ $.containerId }}.{{ .Name }}>
+  @type docker_partial
+  max_bytes {{ .MaxBytes }}
+</filter>
+{{end}}
+
+{{if and .Stdout (ne .Stream "all")}}
+<filter docker.{{ $.containerId }}.{{ .Name }}>
+  @type grep
+  <regexp>
+    key stream
+    pattern /^{{ .Stream }}$/
+  </regexp>
+</filter>
+{{end}}
+
 <filter docker.{{ $.containerId }}.{{ .Name }}>
   @type record_transformer
   enable_ruby true
   <record>
     host "#{Socket.gethostname}"
-    {{range $key, $value := .Tags}}
-    {{ $key }} {{ $value }}
-    {{end}}
-
-    {{if eq $.output "elasticsearch"}}
+    {{if eq .OutputType "elasticsearch"}}
     _target {{if .Target}}{{.Target}}-${time.strftime('%Y.%m.%d')}{{else}}{{ .Name }}-${time.strftime('%Y.%m.%d')}{{end}}
     {{else}}
     _target {{if .Target}}{{.Target}}{{else}}{{ .Name }}{{end}}
     {{end}}
-
-    {{range $key, $value := $.container}}
-    {{ $key }} {{ $value }}
-    {{end}}
   </record>
 </filter>
+
+<filter docker.{{ $.containerId }}.{{ .Name }}>
+  @type static_fields
+  fields {{ merge .Tags $.container | toJson | fluentdString }}
+</filter>
+
+{{if .Output}}
+<match docker.{{ $.containerId }}.{{ .Name }}>
+  @type relabel
+  @label @output_{{ .Output }}
+</match>
+{{end}}
+
+{{if .Destinations}}
+{{ $log := . }}
+<match docker.{{ $.containerId }}.{{ .Name }}>
+  @type copy
+  copy_mode deep
+  {{range .Destinations}}
+  <store>
+    @type relabel
+    @label @dest_{{ $.containerId }}_{{ $log.Name }}_{{ .Output }}
+  </store>
+  {{end}}
+</match>
+
+{{range .Destinations}}
+<label @dest_{{ $.containerId }}_{{ $log.Name }}_{{ .Output }}>
+  <filter **>
+    @type record_transformer
+    enable_ruby true
+    <record>
+      _target {{ .Target }}{{if eq .OutputType "elasticsearch"}}-${time.strftime('%Y.%m.%d')}{{end}}
+    </record>
+  </filter>
+  {{if .Tags}}
+  <filter **>
+    @type static_fields
+    fields {{ toJson .Tags | fluentdString }}
+  </filter>
+  {{end}}
+  <match **>
+    @type relabel
+    @label @output_{{ .Output }}
+  </match>
+</label>
+{{end}}
+{{end}}
 {{end}}
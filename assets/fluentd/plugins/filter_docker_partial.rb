@@ -0,0 +1,107 @@
+#
+# Fluentd
+#
+#    Licensed under the Apache License, Version 2.0 (the "License");
+#    you may not use this file except in compliance with the License.
+#    You may obtain a copy of the License at
+#
+#        http://www.apache.org/licenses/LICENSE-2.0
+#
+#    Unless required by applicable law or agreed to in writing, software
+#    distributed under the License is distributed on an "AS IS" BASIS,
+#    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+#    See the License for the specific language governing permissions and
+#    limitations under the License.
+#
+
+require 'fluent/plugin/filter'
+require 'fluent/clock'
+
+module Fluent
+  module Plugin
+    # Joins the lines the docker json-file driver splits every 16KB:
+    # all the pieces but the last one miss the trailing newline.
+    class DockerPartialFilter < Filter
+      Plugin.register_filter('docker_partial', self)
+
+      helpers :timer
+
+      desc 'Field name containing the log line'
+      config_param :key, :string, default: 'log'
+      desc 'Field name containing the stream, pieces of each stream are joined apart'
+      config_param :stream_key, :string, default: 'stream'
+      desc 'Lines are never joined beyond this size, the pieces before are emitted'
+      config_param :max_bytes, :size, default: 10 * 1024 * 1024
+      desc 'Lines waiting longer than this for their end are emitted as they are'
+      config_param :flush_interval, :time, default: 5
+
+      def configure(conf)
+        super
+        @pending = {}
+        @mutex = Mutex.new
+      end
+
+      def start
+        super
+        timer_execute(:docker_partial_flush, @flush_interval) { flush_stale }
+      end
+
+      def shutdown
+        flush_stale(true)
+        super
+      end
+
+      def filter_stream(tag, es)
+        new_es = MultiEventStream.new
+        @mutex.synchronize do
+          es.each do |time, record|
+            line = record[@key]
+            unless line.is_a?(String)
+              new_es.add(time, record)
+              next
+            end
+
+            id = [tag, record[@stream_key]]
+            pending = @pending.delete(id)
+            if pending && pending[:record][@key].bytesize + line.bytesize > @max_bytes
+              new_es.add(pending[:time], pending[:record])
+              pending = nil
+            end
+            if pending
+              time = pending[:time]
+              record = pending[:record].merge(@key => pending[:record][@key] + line)
+            end
+
+            if record[@key].end_with?("\n") || record[@key].bytesize >= @max_bytes
+              new_es.add(time, record)
+            else
+              @pending[id] = {time: time, record: record, since: Fluent::Clock.now}
+            end
+          end
+        end
+        new_es
+      end
+
+      private
+
+      # Emits the lines waiting longer than flush_interval, or all of them.
+      # They go through the filters of their tag again, completed with a
+      # newline so that this one lets them pass.
+      def flush_stale(all = false)
+        stale = []
+        @mutex.synchronize do
+          now = Fluent::Clock.now
+          @pending.delete_if do |id, pending|
+            expired = all || now - pending[:since] >= @flush_interval
+            stale << [id[0], pending] if expired
+            expired
+          end
+        end
+        stale.each do |tag, pending|
+          record = pending[:record].merge(@key => pending[:record][@key] + "\n")
+          router.emit(tag, pending[:time], record)
+        end
+      end
+    end
+  end
+end
@@ -0,0 +1,34 @@
+#
+# Fluentd
+#
+#    Licensed under the Apache License, Version 2.0 (the "License");
+#    you may not use this file except in compliance with the License.
+#    You may obtain a copy of the License at
+#
+#        http://www.apache.org/licenses/LICENSE-2.0
+#
+#    Unless required by applicable law or agreed to in writing, software
+#    distributed under the License is distributed on an "AS IS" BASIS,
+#    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+#    See the License for the specific language governing permissions and
+#    limitations under the License.
+#
+
+require 'fluent/plugin/filter'
+
+module Fluent
+  module Plugin
+    # Adds fields to every record, as they are: unlike record_transformer,
+    # values don't expand ${...} placeholders nor ruby.
+    class StaticFieldsFilter < Filter
+      Plugin.register_filter('static_fields', self)
+
+      desc 'Fields to add, a json object of strings'
+      config_param :fields, :hash, default: {}
+
+      def filter(tag, time, record)
+        record.merge(@fields)
+      end
+    end
+  end
+end
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
 	"github.com/diablowu/log-pilot/pilot"
 	"io/ioutil"
+	"net/http"
 	"os"
-	"github.com/alecthomas/kingpin"
+	"os/signal"
+	"syscall"
 )
 
 const DEFUALT_VERSION = "0.1"
@@ -17,39 +21,104 @@ func main() {
 	app.Version(DEFUALT_VERSION)
 
 	// 模板路径
-	template := app.Flag("template", "Template filepath for fluentd or filebeat.").Short('t').Required().ExistingFile()
+	template := app.Flag("template", "Template filepath for fluentd or filebeat, filebeat inputs are built in by default.").Short('t').ExistingFile()
 
 	// 主机文件系统挂在到容器内的路径，默认为 /host
 	baseDir := app.Flag("base", "Directory which mount host root.").Default("/host").Short('b').ExistingDir()
 
+	// pilot 配置文件
+	configFile := app.Flag("config", "Pilot configuration file in yaml.").Short('c').ExistingFile()
+
 	// 日志级别
 	level := app.Flag("log", "Log level").Default("info").Short('v').Enum("panic", "fatal", "error", "warn", "info", "debug")
 
-	dry := app.Flag("dryrun", "Dry run.").Short('d').Default("false").Bool()
+	// 只渲染配置, 不启动采集, 不修改主机
+	dry := app.Flag("dryrun", "Render all configs without side effects and exit, non-zero if anything failed.").Short('d').Default("false").Bool()
+
+	dryOutput := app.Flag("dryrun-output", "Directory to write configs rendered by dry run, default is stdout.").String()
+
+	// prometheus 指标的监听地址
+	metricsAddr := app.Flag("metrics", "Address serving prometheus metrics on /metrics, like :9102. Disabled by default.").String()
+
+	app.Command("run", "Collect logs of containers.").Default()
+
+	// 查看 pilot 如何处理一个容器
+	inspect := app.Command("inspect", "Explain how pilot sees a live container.")
+	inspectContainer := inspect.Arg("container", "Container id or name.").Required().String()
 
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	log.SetOutput(os.Stdout)
+	if *dry {
+		// stdout is reserved for rendered configs
+		log.SetOutput(os.Stderr)
+	}
 	// 不会error
 	logLevel, _ := log.ParseLevel(*level)
 	log.SetLevel(logLevel)
 
-	if err := pilot.CreateFileBeatCfg(); err != nil {
-		log.Fatal("can't make filebeat.yml. ", err)
+	opts := []pilot.Option{pilot.FromEnv(), pilot.WithBaseDir(*baseDir)}
+	if *template != "" {
+		b, err := ioutil.ReadFile(*template)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, pilot.WithTemplate(string(b)))
+	}
+	if *configFile != "" {
+		config, err := pilot.LoadConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, pilot.WithConfig(config))
 	}
 
-	if err := pilot.ConfigDockerMountPoint(); err != nil {
-		log.Fatal("can't config mount point.", err)
+	p, err := pilot.New(opts...)
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	if command == inspect.FullCommand() || *dry {
+		if *dry {
+			if err := p.DryRun(os.Stdout, *dryOutput); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+			return
+		}
 
-	if !*dry {
-		b, err := ioutil.ReadFile(*template)
-		if err != nil {
-			log.Panic(err)
+		if err := p.Inspect(os.Stdout, *inspectContainer); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
 
-		log.Fatal(pilot.Run(string(b), *baseDir))
+	if err := p.CreateAgentCfg(); err != nil {
+		log.Fatalf("can't make the config of %s. %v", p.Backend(), err)
 	}
 
+	if err := pilot.ConfigDockerMountPoint(); err != nil {
+		log.Fatal("can't config mount point.", err)
+	}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", p.MetricsHandler())
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Infof("receive %s, stop pilot", sig)
+		cancel()
+	}()
+
+	if err := p.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
 }
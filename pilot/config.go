@@ -0,0 +1,117 @@
+package pilot
+
+import (
+	"github.com/elastic/go-ucfg"
+	"github.com/elastic/go-ucfg/yaml"
+)
+
+// PilotConfig is the optional configuration file of pilot, see --config.
+// Everything in it can also be set in code with the matching Option.
+type PilotConfig struct {
+	// Enrichers add fields to the metadata of a container, applied in order.
+	// The com.aliyun.access.* labels are copied when no enricher is configured.
+	Enrichers []*ucfg.Config `config:"enrichers"`
+
+	// Schema names the metadata fields: legacy, ecs or otel.
+	Schema string `config:"schema"`
+
+	// Kubernetes adds pod labels, annotations, owner and node labels to the metadata.
+	Kubernetes *KubernetesConfig `config:"kubernetes"`
+
+	// Selector restricts the containers pilot manages.
+	Selector *SelectorConfig `config:"selector"`
+
+	// Defaults declares logs of containers that declare none.
+	Defaults *DefaultsConfig `config:"defaults"`
+
+	// HostPaths restricts the host dirs logs are read from.
+	HostPaths *HostPathsConfig `config:"host_paths"`
+
+	// Tenants restricts the targets of the logs of each tenant.
+	Tenants *TenantsConfig `config:"tenants"`
+
+	// Outputs are named outputs logs choose with aliyun.logs.$name.output,
+	// DefaultOutput the one of logs choosing none.
+	Outputs       []*OutputConfig `config:"outputs"`
+	DefaultOutput string          `config:"default_output"`
+
+	// Strict fails a container on any error of its logs, instead of
+	// collecting its valid logs.
+	Strict bool `config:"strict"`
+
+	// Notifications report the log errors of containers to their owners.
+	Notifications *NotificationsConfig `config:"notifications"`
+}
+
+// LoadConfig reads a pilot configuration file in yaml.
+func LoadConfig(path string) (*PilotConfig, error) {
+	c, err := yaml.NewConfigWithFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return unpackConfig(c)
+}
+
+func unpackConfig(c *ucfg.Config) (*PilotConfig, error) {
+	config := &PilotConfig{}
+	if err := c.Unpack(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// WithConfig applies a pilot configuration file.
+func WithConfig(config *PilotConfig) Option {
+	return func(p *Pilot) error {
+		if config.Enrichers != nil {
+			p.enrichers = make([]Enricher, 0, len(config.Enrichers))
+		}
+		for _, c := range config.Enrichers {
+			enricher, err := NewEnricher(c)
+			if err != nil {
+				return err
+			}
+			p.enrichers = append(p.enrichers, enricher)
+		}
+		if config.Kubernetes != nil {
+			p.kubeConfig = config.Kubernetes
+		}
+		if config.Selector != nil {
+			if err := WithSelector(config.Selector)(p); err != nil {
+				return err
+			}
+		}
+		if config.Defaults != nil && config.Defaults.Stdout != nil {
+			if err := WithDefaultStdout(config.Defaults.Stdout)(p); err != nil {
+				return err
+			}
+		}
+		if config.HostPaths != nil {
+			if err := WithHostPaths(config.HostPaths)(p); err != nil {
+				return err
+			}
+		}
+		if config.Tenants != nil {
+			if err := WithTenants(config.Tenants)(p); err != nil {
+				return err
+			}
+		}
+		if config.Outputs != nil || config.DefaultOutput != "" {
+			if err := WithOutputs(config.Outputs, config.DefaultOutput)(p); err != nil {
+				return err
+			}
+		}
+		if config.Strict {
+			p.strict = true
+		}
+		if config.Notifications != nil {
+			p.notifyConfig = config.Notifications
+		}
+		if config.Schema != "" {
+			if err := WithSchema(config.Schema)(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,129 @@
+package pilot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+const LABEL_K8S_CONTAINER_TYPE = "io.kubernetes.docker.type"
+const K8S_CONTAINER_TYPE_SANDBOX = "podsandbox"
+const K8S_SANDBOX_CONTAINER_NAME = "POD"
+
+const DEFAULT_STDOUT_NAME = "stdout"
+const DEFAULT_STDOUT_OPT_OUT = "log-pilot.io/opt-out"
+
+const PROC_SELF_CGROUP = "/proc/self/cgroup"
+
+var containerIDRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// DefaultsConfig declares logs of containers that declare none.
+type DefaultsConfig struct {
+	Stdout *StdoutDefaultConfig `config:"stdout"`
+}
+
+// StdoutDefaultConfig collects the stdout of every container without
+// log declaration, as if it was labeled <prefix>.logs.<name>=stdout.
+type StdoutDefaultConfig struct {
+	Enabled bool              `config:"enabled"`
+	Name    string            `config:"name"`
+	Format  string            `config:"format"`
+	Target  string            `config:"target"`
+	Tags    map[string]string `config:"tags"`
+	// OptOut is a container label or pod annotation, set to true to opt out.
+	OptOut string `config:"opt_out"`
+}
+
+// WithDefaultStdout collects the stdout of containers without log declaration.
+func WithDefaultStdout(config *StdoutDefaultConfig) Option {
+	return func(p *Pilot) error {
+		if !config.Enabled {
+			p.defaultStdout = nil
+			return nil
+		}
+		if config.Name == "" {
+			config.Name = DEFAULT_STDOUT_NAME
+		}
+		if config.OptOut == "" {
+			config.OptOut = DEFAULT_STDOUT_OPT_OUT
+		}
+		if strings.ContainsAny(config.Name, ". ") {
+			return fmt.Errorf("invalid default stdout name: %s", config.Name)
+		}
+		for k, v := range config.Tags {
+			if k == "" || v == "" || checkValue(k+v) != nil {
+				return fmt.Errorf("invalid default stdout tag: %q=%q", k, v)
+			}
+		}
+		p.defaultStdout = config
+		return nil
+	}
+}
+
+// isSandbox tells whether the container only holds the namespaces of a pod.
+func isSandbox(containerJSON *types.ContainerJSON) bool {
+	labels := containerJSON.Config.Labels
+	return labels[LABEL_K8S_CONTAINER_TYPE] == K8S_CONTAINER_TYPE_SANDBOX ||
+		labels[LABEL_K8S_CONTAINER_NAME] == K8S_SANDBOX_CONTAINER_NAME
+}
+
+// selfContainerID finds the id of the container pilot runs in from its
+// cgroups, or the hostname docker sets to the short id, "" if none.
+func selfContainerID() string {
+	if b, err := ioutil.ReadFile(PROC_SELF_CGROUP); err == nil {
+		if id := containerIDRegexp.Find(b); id != nil {
+			return string(id)
+		}
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// isSelf tells whether the container is the one pilot runs in.
+func (p *Pilot) isSelf(containerJSON *types.ContainerJSON) bool {
+	// short ids are 12 characters, shorter hostnames aren't ids
+	return len(p.selfID) >= 12 && strings.HasPrefix(containerJSON.ID, p.selfID)
+}
+
+// optedOut tells whether the container or its pod opted out of default collection.
+func (p *Pilot) optedOut(containerJSON *types.ContainerJSON) bool {
+	labels := containerJSON.Config.Labels
+	if labels[p.defaultStdout.OptOut] == "true" {
+		return true
+	}
+	namespace, name := labels[LABEL_K8S_POD_NAMESPACE], labels[LABEL_POD]
+	if p.kube == nil || namespace == "" || name == "" {
+		return false
+	}
+	pod, err := p.kube.pod(namespace, name)
+	if err != nil {
+		p.logger.Warnf("read opt out of %s error: %v", containerJSON.ID, err)
+		return false
+	}
+	return pod.Metadata.Annotations[p.defaultStdout.OptOut] == "true"
+}
+
+// defaultLabels returns the log declaration labels of the default stdout
+// collection for a container without any, nil if it doesn't apply.
+func (p *Pilot) defaultLabels(containerJSON *types.ContainerJSON) map[string]string {
+	if p.defaultStdout == nil || isSandbox(containerJSON) || p.isSelf(containerJSON) || p.optedOut(containerJSON) {
+		return nil
+	}
+	config := p.defaultStdout
+	key := fmt.Sprintf(LABEL_SERVICE_LOGS_TEMPL, p.logPrefix[0]) + config.Name
+	labels := map[string]string{key: STREAM_STDOUT}
+	putIfNotEmpty(labels, key+".format", config.Format)
+	putIfNotEmpty(labels, key+".target", config.Target)
+
+	// the json form holds any tag as is
+	if len(config.Tags) > 0 {
+		tags, _ := json.Marshal(config.Tags)
+		labels[key+".tags"] = string(tags)
+	}
+	return labels
+}
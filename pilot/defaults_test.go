@@ -0,0 +1,101 @@
+package pilot
+
+import (
+	"bytes"
+
+	"gopkg.in/check.v1"
+)
+
+type DefaultsSuite struct{}
+
+var _ = check.Suite(&DefaultsSuite{})
+
+func (s *DefaultsSuite) TestDefaultStdout(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, `
+defaults:
+  stdout:
+    enabled: true
+    format: json
+    target: all-stdout
+    tags:
+      retain: "30d"
+      note: 'a "b", c=d\e'
+`)))
+
+	containerJSON := fakeContainer("c1", map[string]string{})
+	plan, err := p.planContainer(&containerJSON)
+	c.Assert(err, check.IsNil)
+	c.Assert(plan.logConfigs, check.HasLen, 1)
+	config := plan.logConfigs[0]
+	c.Assert(config.Name, check.Equals, "stdout")
+	c.Assert(config.Stdout, check.Equals, true)
+	c.Assert(config.Target, check.Equals, "all-stdout")
+	c.Assert(config.Tags, check.DeepEquals, map[string]string{"retain": "30d", "note": `a "b", c=d\e`, "topic": "all-stdout"})
+	c.Assert(config.Format, check.Equals, "json")
+	c.Assert(plan.matches[0].Default, check.Equals, true)
+
+	// declarations of the container win
+	declared := fakeContainer("c2", map[string]string{"aliyun.logs.app": "stdout"})
+	plan, err = p.planContainer(&declared)
+	c.Assert(err, check.IsNil)
+	c.Assert(plan.logConfigs, check.HasLen, 1)
+	c.Assert(plan.logConfigs[0].Name, check.Equals, "app")
+
+	for _, labels := range []map[string]string{
+		{DEFAULT_STDOUT_OPT_OUT: "true"},
+		{LABEL_K8S_CONTAINER_TYPE: K8S_CONTAINER_TYPE_SANDBOX},
+		{LABEL_K8S_CONTAINER_NAME: K8S_SANDBOX_CONTAINER_NAME},
+	} {
+		containerJSON := fakeContainer("c3", labels)
+		plan, err := p.planContainer(&containerJSON)
+		c.Assert(err, check.IsNil)
+		c.Assert(plan.logConfigs, check.HasLen, 0, check.Commentf("%v", labels))
+	}
+}
+
+func (s *DefaultsSuite) TestSelf(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithDefaultStdout(&StdoutDefaultConfig{Enabled: true}))
+	self := fakeContainer("0123456789abcdef", map[string]string{})
+	other := fakeContainer("fedcba9876543210", map[string]string{})
+
+	for _, id := range []string{"0123456789abcdef", "0123456789ab"} {
+		p.selfID = id
+		plan, err := p.planContainer(&self)
+		c.Assert(err, check.IsNil)
+		c.Assert(plan.logConfigs, check.HasLen, 0)
+		plan, err = p.planContainer(&other)
+		c.Assert(err, check.IsNil)
+		c.Assert(plan.logConfigs, check.HasLen, 1)
+	}
+
+	// too short to be an id
+	p.selfID = "0123"
+	plan, err := p.planContainer(&self)
+	c.Assert(err, check.IsNil)
+	c.Assert(plan.logConfigs, check.HasLen, 1)
+}
+
+func (s *DefaultsSuite) TestPodOptOut(c *check.C) {
+	pod := newTestPod()
+	pod.Metadata.Annotations["no-stdout"] = "true"
+	kube := newFakeKube([]kubePod{pod})
+	defer kube.server.Close()
+
+	docker := newFakeDocker(fakeContainer("c1", map[string]string{
+		LABEL_POD:               pod.Metadata.Name,
+		LABEL_K8S_POD_NAMESPACE: pod.Metadata.Namespace,
+	}))
+	p, _ := newTestPilot(c, docker,
+		WithKubernetes(&KubernetesConfig{URL: kube.server.URL, TokenFile: writeToken(c)}),
+		WithDefaultStdout(&StdoutDefaultConfig{Enabled: true, OptOut: "no-stdout"}))
+
+	var out bytes.Buffer
+	c.Assert(p.DryRun(&out, ""), check.IsNil)
+	c.Assert(out.String(), check.Matches, `(?s).*\[skip\] c1 \(c1\): no log config\n.*`)
+}
+
+func (s *DefaultsSuite) TestInvalid(c *check.C) {
+	_, err := New(WithTemplate(""), WithDockerClient(newFakeDocker()),
+		WithDefaultStdout(&StdoutDefaultConfig{Enabled: true, Tags: map[string]string{"a": ""}}))
+	c.Assert(err, check.ErrorMatches, `invalid default stdout tag: "a"=""`)
+}
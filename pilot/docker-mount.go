@@ -1,21 +1,25 @@
 package pilot
 
 import (
-	"io/ioutil"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"strings"
+	"io/ioutil"
 	"os/exec"
+	"strings"
 )
 
 const proc_mount_file = "/proc/self/mountinfo"
 
-
 // mount point 配置
 // 主要是 umount 一下
 func ConfigDockerMountPoint() error {
-	for _, mp := range mountPoints() {
+	mps, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mp := range mps {
 		if err := mp.umount(); err != nil {
-			log.Fatalf("can't umount point %s", mp.path, err)
+			log.Fatalf("can't umount point %s: %v", mp.path, err)
 		}
 	}
 
@@ -32,12 +36,12 @@ func (mp *mountPoint) umount() error {
 }
 
 // 获取mount point 信息
-func mountPoints() ([]mountPoint) {
+func mountPoints() ([]mountPoint, error) {
 	mps := make([]mountPoint, 0)
 	bs, err := ioutil.ReadFile(proc_mount_file)
 
 	if err != nil {
-		log.Fatalf("can't read %s , ", proc_mount_file, err)
+		return nil, fmt.Errorf("can't read %s: %v", proc_mount_file, err)
 	}
 
 	txt := string(bs)
@@ -54,5 +58,5 @@ func mountPoints() ([]mountPoint) {
 		}
 	}
 
-	return mps
+	return mps, nil
 }
@@ -0,0 +1,242 @@
+package pilot
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"golang.org/x/net/context"
+	"gopkg.in/check.v1"
+)
+
+// fakeDocker serves containers from memory.
+type fakeDocker struct {
+	containers map[string]types.ContainerJSON
+	volumes    map[string]types.Volume
+	events     chan events.Message
+}
+
+func newFakeDocker(containers ...types.ContainerJSON) *fakeDocker {
+	d := &fakeDocker{
+		containers: make(map[string]types.ContainerJSON),
+		events:     make(chan events.Message),
+	}
+	for _, c := range containers {
+		d.containers[c.ID] = c
+	}
+	return d
+}
+
+func (d *fakeDocker) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	var ret []types.Container
+	for id := range d.containers {
+		ret = append(ret, types.Container{ID: id, State: "running"})
+	}
+	return ret, nil
+}
+
+func (d *fakeDocker) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	if c, ok := d.containers[containerID]; ok {
+		return c, nil
+	}
+	return types.ContainerJSON{}, fmt.Errorf("no such container: %s", containerID)
+}
+
+func (d *fakeDocker) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	return d.events, make(chan error)
+}
+
+func (d *fakeDocker) VolumeInspect(ctx context.Context, volumeID string) (types.Volume, error) {
+	if v, ok := d.volumes[volumeID]; ok {
+		return v, nil
+	}
+	return types.Volume{}, fmt.Errorf("no such volume: %s", volumeID)
+}
+
+// fakeContainer builds an inspected container with labels and mounts.
+func fakeContainer(id string, labels map[string]string, mounts ...types.MountPoint) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:      id,
+			Name:    "/" + id,
+			LogPath: fmt.Sprintf("/var/lib/docker/containers/%s/%s-json.log", id, id),
+		},
+		Mounts: mounts,
+		Config: &container.Config{
+			Image:  "busybox",
+			Labels: labels,
+		},
+	}
+}
+
+// fakePiloter writes configs to a directory and records calls.
+type fakePiloter struct {
+	mutex   sync.Mutex
+	home    string
+	started bool
+	stopped bool
+}
+
+func (f *fakePiloter) Name() string { return PILOT_FLUENTD }
+
+func (f *fakePiloter) Start() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.started = true
+	return nil
+}
+
+func (f *fakePiloter) Reload() error { return nil }
+
+func (f *fakePiloter) Stop() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.stopped = true
+	return nil
+}
+
+func (f *fakePiloter) ConfHome() string { return f.home }
+
+func (f *fakePiloter) ConfPathOf(container string) string {
+	return filepath.Join(f.home, container+".conf")
+}
+
+func (f *fakePiloter) OnDestroyEvent(container string) error { return nil }
+
+const testTemplate = `{{range .configList}}{{ .Name }} {{ .HostDir }}/{{ .File }}
+{{end}}`
+
+func newTestPilot(c *check.C, docker DockerClient, opts ...Option) (*Pilot, *fakePiloter) {
+	piloter := &fakePiloter{home: c.MkDir()}
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	opts = append([]Option{
+		WithTemplate(testTemplate),
+		WithDockerClient(docker),
+		WithPiloter(piloter),
+		WithLogger(logger),
+	}, opts...)
+	p, err := New(opts...)
+	c.Assert(err, check.IsNil)
+	return p, piloter
+}
+
+type EmbedSuite struct{}
+
+var _ = check.Suite(&EmbedSuite{})
+
+func (s *EmbedSuite) TestRunUntilCancel(c *check.C) {
+	docker := newFakeDocker(fakeContainer("c1", map[string]string{
+		"aliyun.logs.app": "/var/log/app.log",
+	}, types.MountPoint{Source: "/data/c1", Destination: "/var/log"}))
+
+	// two pilots sharing nothing but the docker client
+	p1, piloter1 := newTestPilot(c, docker, WithBaseDir("/host1"))
+	p2, piloter2 := newTestPilot(c, docker, WithBaseDir("/host2"), WithLogPrefix("custom"))
+
+	for _, p := range []*Pilot{p1, p2} {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func(p *Pilot) {
+			done <- p.Run(ctx)
+		}(p)
+		cancel()
+		c.Assert(<-done, check.IsNil)
+	}
+
+	c.Assert(piloter1.started, check.Equals, true)
+	c.Assert(piloter1.stopped, check.Equals, true)
+	b, err := ioutil.ReadFile(piloter1.ConfPathOf("c1"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(b), check.Equals, "app /host1/data/c1/app.log\n")
+
+	_, err = os.Stat(piloter2.ConfPathOf("c1"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *EmbedSuite) TestOptions(c *check.C) {
+	_, err := New(WithDockerClient(newFakeDocker()), WithBackend(PILOT_FLUENTD))
+	c.Assert(err, check.ErrorMatches, "template is required by fluentd")
+
+	// filebeat renders its inputs without template
+	_, err = New(WithDockerClient(newFakeDocker()), WithBaseDir(c.MkDir()))
+	c.Assert(err, check.IsNil)
+
+	_, err = New(WithTemplate(""), WithDockerClient(newFakeDocker()), WithBackend("logstash"))
+	c.Assert(err, check.ErrorMatches, "unsupported pilot type: logstash")
+
+	p, err := New(WithTemplate(""), WithDockerClient(newFakeDocker()), WithBackend(PILOT_FLUENTD))
+	c.Assert(err, check.IsNil)
+	c.Assert(p.piloter.Name(), check.Equals, PILOT_FLUENTD)
+	c.Assert(p.base, check.Equals, "/host")
+}
+
+func (s *EmbedSuite) TestConfDir(c *check.C) {
+	for _, backend := range []string{PILOT_FILEBEAT, PILOT_FLUENTD} {
+		dir := c.MkDir()
+		p, err := New(WithTemplate(""), WithDockerClient(newFakeDocker()), WithBackend(backend), WithConfDir(dir))
+		c.Assert(err, check.IsNil)
+		c.Assert(p.piloter.ConfHome(), check.Equals, dir)
+		c.Assert(filepath.Dir(p.piloter.ConfPathOf("c1")), check.Equals, dir)
+	}
+}
+
+func (s *EmbedSuite) TestAgentConfig(c *check.C) {
+	// pilots of the same host keep their agents apart
+	home := c.MkDir()
+	p, err := New(WithTemplate(""), WithDockerClient(newFakeDocker()), WithBackend(PILOT_FLUENTD),
+		WithConfDir(filepath.Join(home, "conf.d")), WithAgentConfig(filepath.Join(home, "fluentd.conf")), WithOutput("null"))
+	c.Assert(err, check.IsNil)
+	c.Assert(p.CreateAgentCfg(), check.IsNil)
+	cfg, err := ioutil.ReadFile(filepath.Join(home, "fluentd.conf"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(cfg), check.Matches, "(?s).*\n@include "+home+"/conf.d/\\*.conf\n.*<match docker.\\*\\*>\n  @type null\n.*")
+	_, err = os.Stat(filepath.Join(home, "conf.d"))
+	c.Assert(err, check.IsNil)
+
+	home = c.MkDir()
+	p, err = New(WithDockerClient(newFakeDocker()), WithBaseDir(c.MkDir()), WithConfDir(filepath.Join(home, "inputs.d")),
+		WithAgentConfig(filepath.Join(home, "filebeat.yml")), WithRegistryFile(filepath.Join(home, "registry")))
+	c.Assert(err, check.IsNil)
+	c.Assert(p.CreateAgentCfg(), check.IsNil)
+	cfg, err = ioutil.ReadFile(filepath.Join(home, "filebeat.yml"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(cfg), check.Matches, `(?s).*\npath.data: "`+home+`/data"\nfilebeat.registry_file: "`+home+`/registry"\n.*`)
+	c.Assert(string(cfg), check.Matches, `(?s).*\n        path: "`+home+`/inputs.d/\*.yml"\n.*output.console:.*`)
+	c.Assert(p.piloter.(*FilebeatPiloter).registryFile, check.Equals, filepath.Join(home, "registry"))
+	c.Assert(p.piloter.(*FilebeatPiloter).confFile, check.Equals, filepath.Join(home, "filebeat.yml"))
+}
+
+func (s *EmbedSuite) TestDryRunAgentConfig(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithOutput("null"))
+	var out bytes.Buffer
+	c.Assert(p.DryRun(&out, ""), check.IsNil)
+	c.Assert(out.String(), check.Matches, `(?s)--- /etc/fluentd/fluentd.conf\n# Generated by log-pilot .*\n  @type null\n.*`)
+
+	// an output missing its variables fails the dry run
+	p, _ = newTestPilot(c, newFakeDocker(), WithOutput("elasticsearch"))
+	out.Reset()
+	c.Assert(p.DryRun(&out, ""), check.ErrorMatches, `dry run failed for 1 item\(s\)`)
+	c.Assert(out.String(), check.Matches, `\[error\] /etc/fluentd/fluentd.conf: fluentd output elasticsearch requires ELASTICSEARCH_HOST, ELASTICSEARCH_PORT\n.*`)
+}
+
+func (s *EmbedSuite) TestRemovalsStopped(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker())
+	c.Assert(p.delContainer("c1"), check.IsNil)
+	c.Assert(p.removals, check.HasLen, 1)
+	timer := p.removals["c1"]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Assert(p.Run(ctx), check.IsNil)
+	c.Assert(p.removals, check.HasLen, 0)
+	// stopped already
+	c.Assert(timer.Stop(), check.Equals, false)
+}
@@ -0,0 +1,123 @@
+package pilot
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/net/context"
+)
+
+// DryRun renders the config of every running container without any side
+// effect on the host: nothing is written under the piloter's config home,
+// no symlink is created and nothing is unmounted. Rendered configs go to w,
+// or to outDir when it is not empty. An error is returned when any container
+// failed, so DryRun can gate a rollout.
+func (p *Pilot) DryRun(w io.Writer, outDir string) error {
+	failed := 0
+
+	if cfg, err := p.renderAgentCfg(); err != nil {
+		fmt.Fprintf(w, "[error] %s: %v\n", p.agentConfig, err)
+		failed++
+	} else if err := p.dryRunWrite(w, outDir, p.agentConfig, cfg); err != nil {
+		return err
+	}
+
+	if cfg := p.renderOutputs(); cfg != "" {
+		if err := p.dryRunWrite(w, outDir, p.piloter.ConfPathOf(OUTPUTS_CONF), cfg); err != nil {
+			return err
+		}
+	}
+
+	mps, err := mountPoints()
+	if err != nil {
+		fmt.Fprintf(w, "[error] %v\n", err)
+		failed++
+	}
+	for _, mp := range mps {
+		fmt.Fprintf(w, "[umount] %s\n", mp.path)
+	}
+
+	if names, err := ioutil.ReadDir(p.piloter.ConfHome()); err == nil {
+		for _, name := range names {
+			if name.Mode().IsRegular() {
+				fmt.Fprintf(w, "[clean] %s\n", filepath.Join(p.piloter.ConfHome(), name.Name()))
+			}
+		}
+	}
+
+	containers, err := p.client().ContainerList(context.Background(), types.ContainerListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range containers {
+		if c.State == "removing" {
+			continue
+		}
+		containerJSON, err := p.client().ContainerInspect(context.Background(), c.ID)
+		if err != nil {
+			fmt.Fprintf(w, "[error] %s: %v\n", c.ID, err)
+			failed++
+			continue
+		}
+
+		plan, err := p.planContainer(&containerJSON)
+		for _, decision := range plan.decisions {
+			if decision.action != "allowed" {
+				fmt.Fprintf(w, "[policy] %s (%s): %s\n", plan.name, plan.id, decision)
+			}
+		}
+		for _, logErr := range plan.logErrors {
+			fmt.Fprintf(w, "[invalid] %s (%s): log %s: %v\n", plan.name, plan.id, logErr.log, logErr)
+			failed++
+		}
+		if _, ok := err.(logErrors); ok {
+			fmt.Fprintf(w, "[error] %s (%s): invalid logs fail the container in strict mode\n", plan.name, plan.id)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(w, "[error] %s (%s): %v\n", plan.name, plan.id, err)
+			failed++
+			continue
+		}
+		if plan.skipped != "" {
+			fmt.Fprintf(w, "[skip] %s (%s): %s\n", plan.name, plan.id, plan.skipped)
+			continue
+		}
+		if len(plan.logConfigs) == 0 {
+			fmt.Fprintf(w, "[skip] %s (%s): no log config\n", plan.name, plan.id)
+			continue
+		}
+
+		fmt.Fprintf(w, "[ok] %s (%s): %d log config(s)\n", plan.name, plan.id, len(plan.logConfigs))
+		for mountPoint, symlink := range plan.symlinks {
+			fmt.Fprintf(w, "[symlink] %s -> %s\n", symlink, mountPoint)
+		}
+		if err := p.dryRunWrite(w, outDir, p.piloter.ConfPathOf(plan.id), plan.config); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("dry run failed for %d item(s)", failed)
+	}
+	return nil
+}
+
+func (p *Pilot) dryRunWrite(w io.Writer, outDir string, confPath string, config string) error {
+	if outDir == "" {
+		fmt.Fprintf(w, "--- %s\n%s\n", confPath, config)
+		return nil
+	}
+
+	dest := filepath.Join(outDir, filepath.Base(confPath))
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "[render] %s -> %s\n", confPath, dest)
+	return ioutil.WriteFile(dest, []byte(config), 0644)
+}
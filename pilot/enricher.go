@@ -0,0 +1,215 @@
+package pilot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/elastic/go-ucfg"
+)
+
+// Enricher adds, renames or removes fields of the metadata built for a
+// container before its config is rendered.
+type Enricher interface {
+	Enrich(metadata map[string]string, containerJSON *types.ContainerJSON) error
+}
+
+// EnricherFactory creates an enricher from its configuration.
+type EnricherFactory func(config *ucfg.Config) (Enricher, error)
+
+var enrichers = make(map[string]EnricherFactory)
+
+// RegisterEnricher makes an enricher type available to the configuration file.
+func RegisterEnricher(name string, factory EnricherFactory) {
+	enrichers[name] = factory
+}
+
+// NewEnricher creates an enricher from a configuration with a type field.
+func NewEnricher(config *ucfg.Config) (Enricher, error) {
+	var typ struct {
+		Type string `config:"type"`
+	}
+	if err := config.Unpack(&typ); err != nil {
+		return nil, err
+	}
+	factory := enrichers[typ.Type]
+	if factory == nil {
+		return nil, fmt.Errorf("unsupported enricher: %s", typ.Type)
+	}
+	return factory(config)
+}
+
+// WithEnricher appends an enricher to the ones of the pilot. The default
+// com.aliyun.access.* label enricher is only used when none is given.
+func WithEnricher(enricher Enricher) Option {
+	return func(p *Pilot) error {
+		p.enrichers = append(p.enrichers, enricher)
+		return nil
+	}
+}
+
+func (p *Pilot) enrich(metadata map[string]string, containerJSON *types.ContainerJSON) {
+	for _, enricher := range p.enrichers {
+		if err := enricher.Enrich(metadata, containerJSON); err != nil {
+			p.logger.Warnf("enrich %s error: %v", containerJSON.ID, err)
+		}
+	}
+}
+
+type renameRule struct {
+	From string `config:"from" validate:"required"`
+	To   string `config:"to"`
+	from *regexp.Regexp
+}
+
+// matchConfig selects keys by prefix or regex and renames them.
+// A key matching a rename rule is renamed by the first one,
+// then dots are replaced by the separator.
+type matchConfig struct {
+	Prefixes  []string      `config:"prefixes"`
+	Patterns  []string      `config:"patterns"`
+	Rename    []*renameRule `config:"rename"`
+	Separator string        `config:"separator"`
+	patterns  []*regexp.Regexp
+}
+
+func newMatchConfig(config *ucfg.Config) (*matchConfig, error) {
+	m := &matchConfig{Separator: "_"}
+	if err := config.Unpack(m); err != nil {
+		return nil, err
+	}
+	if len(m.Prefixes) == 0 && len(m.Patterns) == 0 {
+		return nil, fmt.Errorf("prefixes or patterns is required")
+	}
+	for _, pattern := range m.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m.patterns = append(m.patterns, re)
+	}
+	for _, rule := range m.Rename {
+		re, err := regexp.Compile(rule.From)
+		if err != nil {
+			return nil, err
+		}
+		rule.from = re
+	}
+	return m, nil
+}
+
+func (m *matchConfig) match(key string) bool {
+	for _, prefix := range m.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	for _, re := range m.patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *matchConfig) rename(key string) string {
+	for _, rule := range m.Rename {
+		if rule.from.MatchString(key) {
+			key = rule.from.ReplaceAllString(key, rule.To)
+			break
+		}
+	}
+	if m.Separator != "." {
+		key = strings.Replace(key, ".", m.Separator, -1)
+	}
+	return key
+}
+
+// labelEnricher copies matching container labels.
+type labelEnricher struct {
+	*matchConfig
+}
+
+func (e *labelEnricher) Enrich(metadata map[string]string, containerJSON *types.ContainerJSON) error {
+	for name, value := range containerJSON.Config.Labels {
+		if e.match(name) {
+			putIfNotEmpty(metadata, e.rename(name), value)
+		}
+	}
+	return nil
+}
+
+// envEnricher copies matching container env variables.
+type envEnricher struct {
+	*matchConfig
+}
+
+func (e *envEnricher) Enrich(metadata map[string]string, containerJSON *types.ContainerJSON) error {
+	for _, env := range containerJSON.Config.Env {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) == 2 && e.match(kv[0]) {
+			putIfNotEmpty(metadata, e.rename(kv[0]), kv[1])
+		}
+	}
+	return nil
+}
+
+// dropEnricher removes fields by name or regex.
+type dropEnricher struct {
+	Fields   []string `config:"fields"`
+	Patterns []string `config:"patterns"`
+	patterns []*regexp.Regexp
+}
+
+func (e *dropEnricher) Enrich(metadata map[string]string, containerJSON *types.ContainerJSON) error {
+	for _, field := range e.Fields {
+		delete(metadata, field)
+	}
+	for key := range metadata {
+		for _, re := range e.patterns {
+			if re.MatchString(key) {
+				delete(metadata, key)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func defaultEnrichers() []Enricher {
+	return []Enricher{
+		&labelEnricher{&matchConfig{Prefixes: []string{"com.aliyun.access."}, Separator: "_"}},
+	}
+}
+
+func init() {
+	RegisterEnricher("label", func(config *ucfg.Config) (Enricher, error) {
+		m, err := newMatchConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("label enricher: %v", err)
+		}
+		return &labelEnricher{m}, nil
+	})
+	RegisterEnricher("env", func(config *ucfg.Config) (Enricher, error) {
+		m, err := newMatchConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("env enricher: %v", err)
+		}
+		return &envEnricher{m}, nil
+	})
+	RegisterEnricher("drop", func(config *ucfg.Config) (Enricher, error) {
+		e := &dropEnricher{}
+		if err := config.Unpack(e); err != nil {
+			return nil, fmt.Errorf("drop enricher: %v", err)
+		}
+		for _, pattern := range e.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("drop enricher: %v", err)
+			}
+			e.patterns = append(e.patterns, re)
+		}
+		return e, nil
+	})
+}
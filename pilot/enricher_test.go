@@ -0,0 +1,102 @@
+package pilot
+
+import (
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/elastic/go-ucfg/yaml"
+	"gopkg.in/check.v1"
+)
+
+type EnricherSuite struct{}
+
+var _ = check.Suite(&EnricherSuite{})
+
+func newTestConfig(c *check.C, s string) *PilotConfig {
+	cfg, err := yaml.NewConfig([]byte(s))
+	c.Assert(err, check.IsNil)
+	config, err := unpackConfig(cfg)
+	c.Assert(err, check.IsNil)
+	return config
+}
+
+func (s *EnricherSuite) TestDefault(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker())
+	containerJSON := fakeContainer("c1", map[string]string{
+		"com.aliyun.access.key": "value",
+		"team":                  "infra",
+	})
+	metadata := p.container(&containerJSON)
+	c.Assert(metadata["com_aliyun_access_key"], check.Equals, "value")
+	c.Assert(metadata["team"], check.Equals, "")
+}
+
+func (s *EnricherSuite) TestConfigured(c *check.C) {
+	config := newTestConfig(c, `
+enrichers:
+  - type: label
+    prefixes: ["team"]
+    patterns: ['^org\.opencontainers\.image\.']
+    rename:
+      - from: '^org\.opencontainers\.image\.(.*)$'
+        to: 'oci_$1'
+  - type: env
+    prefixes: ["COST_"]
+    separator: "."
+  - type: drop
+    fields: ["docker_container_created"]
+    patterns: ["^rancher_"]
+`)
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(config))
+
+	containerJSON := fakeContainer("c1", map[string]string{
+		"com.aliyun.access.key":               "value",
+		"team.name":                           "infra",
+		"org.opencontainers.image.revision":   "abc",
+		"io.rancher.stack.name":               "stack",
+		"org.opencontainers.image.source.url": "http://example.com",
+	})
+	containerJSON.Created = "2018-01-01"
+	containerJSON.Config.Env = []string{"COST_CENTER=42", "HOME=/root"}
+
+	metadata := p.container(&containerJSON)
+	c.Assert(metadata["team_name"], check.Equals, "infra")
+	c.Assert(metadata["oci_revision"], check.Equals, "abc")
+	c.Assert(metadata["oci_source_url"], check.Equals, "http://example.com")
+	c.Assert(metadata["COST_CENTER"], check.Equals, "42")
+	c.Assert(metadata["docker_container_id"], check.Equals, "c1")
+	for _, key := range []string{"com_aliyun_access_key", "HOME", "docker_container_created", "rancher_stack"} {
+		_, ok := metadata[key]
+		c.Assert(ok, check.Equals, false, check.Commentf("%s should not be set", key))
+	}
+}
+
+func (s *EnricherSuite) TestSymlinks(c *check.C) {
+	volume := types.Volume{Name: "data", Mountpoint: "/var/lib/docker/volumes/data/_data"}
+	containerJSON := fakeContainer("c1", map[string]string{
+		LABEL_PROJECT: "shop",
+		LABEL_SERVICE: "web",
+	}, types.MountPoint{Type: mount.TypeVolume, Name: "data", Destination: "/var/log"})
+	docker := newFakeDocker(containerJSON)
+	docker.volumes = map[string]types.Volume{"data": volume}
+
+	// the layout doesn't depend on the metadata of containers
+	p, _ := newTestPilot(c, docker, WithSchema(SCHEMA_ECS))
+	p.createSymlink = true
+	c.Assert(p.volumeSymlinks(&containerJSON), check.DeepEquals, map[string]string{
+		volume.Mountpoint: filepath.Join(p.base, SYMLINK_LOGS_BASE, "shop", "web", "c1", "data"),
+	})
+}
+
+func (s *EnricherSuite) TestInvalid(c *check.C) {
+	for _, bad := range []string{
+		"enrichers: [{type: unknown}]",
+		"enrichers: [{type: label}]",
+		"enrichers: [{type: label, patterns: ['(']}]",
+		"enrichers: [{type: drop, patterns: ['(']}]",
+	} {
+		_, err := New(WithTemplate(""), WithDockerClient(newFakeDocker()), WithConfig(newTestConfig(c, bad)))
+		c.Assert(err, check.NotNil, check.Commentf(bad))
+	}
+}
@@ -1,12 +1,13 @@
 package pilot
 
 import (
+	"bytes"
+	log "github.com/Sirupsen/logrus"
 	"io/ioutil"
 	"os"
-	"text/template"
-	"bytes"
+	"path/filepath"
 	"strings"
-	log "github.com/Sirupsen/logrus"
+	"text/template"
 )
 
 const FILEBEAT_CONFIG = "/etc/filebeat/filebeat.yml"
@@ -14,15 +15,15 @@ const FILEBEAT_CONFIG = "/etc/filebeat/filebeat.yml"
 const TPL_BASE = `
 path.config: /etc/filebeat
 path.logs: /var/log/filebeat
-path.data: /var/lib/filebeat/data
-filebeat.registry_file: /var/lib/filebeat/registry
+path.data: {{ yamlString .dataDir }}
+filebeat.registry_file: {{ yamlString .registryFile }}
 {{ putIfEnvNotEmpty "filebeat.shutdown_timeout" "FILEBEAT_SHUTDOWN_TIMEOUT" "0" }}
 {{ putIfEnvNotEmpty "logging.level" "FILEBEAT_LOG_LEVEL" "info" }}
 logging.metrics.enabled: true
 filebeat.config:
     prospectors:
         enabled: true
-        path: ${path.config}/prospectors.d/*.yml
+        path: {{ yamlString .inputs }}
         reload.enabled: true
         reload.period: 10s
 
@@ -38,10 +39,10 @@ const TPL_KAFKA = `
 output.kafka:
     hosts: {{ envArray "KAFKA_BROKERS" }} 
     topic: '%{[topic]}'
-	{{ putIfEnvNotEmpty "version" "KAFKA_VERSION"}}
-	{{ putIfEnvNotEmpty "username" "KAFKA_USERNAME"}}
-	{{ putIfEnvNotEmpty "password" "KAFKA_PASSWORD"}}
-	{{ putIfEnvNotEmpty "worker" "KAFKA_WORKER"}}
+    {{ putIfEnvNotEmpty "version" "KAFKA_VERSION"}}
+    {{ putIfEnvNotEmpty "username" "KAFKA_USERNAME"}}
+    {{ putIfEnvNotEmpty "password" "KAFKA_PASSWORD"}}
+    {{ putIfEnvNotEmpty "worker" "KAFKA_WORKER"}}
     {{ putIfEnvNotEmpty "key" "KAFKA_PARTITION_KEY"}}
     {{ putIfEnvNotEmpty "partition" "KAFKA_PARTITION"}}
     {{ putIfEnvNotEmpty "client_id" "KAFKA_CLIENT_ID"}}
@@ -58,7 +59,7 @@ output.kafka:
 const TPL_REDIS = `
 output.redis:
     hosts: {{ envArray "REDIS_HOST" }}
-    key: "%{[fields.topic]:filebeat}"
+    key: "%{[topic]:filebeat}"
     ${REDIS_WORKER:+worker: ${REDIS_WORKER}}
     ${REDIS_PASSWORD:+password: ${REDIS_PASSWORD}}
     ${REDIS_DATATYPE:+datatype: ${REDIS_DATATYPE}}
@@ -69,7 +70,7 @@ output.redis:
 const TPL_ES = `
 output.elasticsearch:
     hosts: ["$ELASTICSEARCH_HOST:$ELASTICSEARCH_PORT"]
-    index: ${FILEBEAT_INDEX:-filebeat}-%{+yyyy.MM.dd}
+    index: '%{[index]:${FILEBEAT_INDEX:-filebeat}}-%{+yyyy.MM.dd}'
     ${ELASTICSEARCH_SCHEME:+protocol: ${ELASTICSEARCH_SCHEME}}
     ${ELASTICSEARCH_USER:+username: ${ELASTICSEARCH_USER}}
     ${ELASTICSEARCH_PASSWORD:+password: ${ELASTICSEARCH_PASSWORD}}
@@ -88,23 +89,56 @@ output.logstash:
     ${LOGSTASH_SLOW_START:+slow_start: ${LOGSTASH_SLOW_START}}
 `
 
-// 生成filebeat主配置文件
-func CreateFileBeatCfg() error {
-	os.Mkdir("/etc/filebeat/prospectors.d", 0666)
+// 生成filebeat主配置文件 path, 读取 confDir 中的容器配置
+func CreateFileBeatCfg(path, confDir, registryFile, output string) error {
+	cfg, err := RenderFileBeatCfg(confDir, registryFile, output)
+	if err != nil {
+		return err
+	}
 
-	allTpl := TPL_BASE + "\n" + TPL_CONSOLE
+	if err := os.MkdirAll(confDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(cfg), 0666)
+}
+
+// filebeat outputs by name, logs are routed by their topic and
+// index fields, which named outputs set
+var filebeatOutputs = map[string]string{
+	"console":       TPL_CONSOLE,
+	"kafka":         TPL_KAFKA,
+	"redis":         TPL_REDIS,
+	"elasticsearch": TPL_ES,
+	"logstash":      TPL_LS,
+}
+
+// 渲染filebeat主配置文件, 不写入磁盘
+func RenderFileBeatCfg(confDir, registryFile, output string) (string, error) {
+	if output == "" {
+		output = "console"
+	}
+	outputTpl, ok := filebeatOutputs[output]
+	if !ok {
+		log.Warnf("unsupported filebeat output %q, logs go to console", output)
+		outputTpl = TPL_CONSOLE
+	}
+	allTpl := TPL_BASE + "\n" + outputTpl
 
 	tpl, err := template.New("filebeat").Funcs(fm).Parse(allTpl)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	var buf bytes.Buffer
-
-	tpl.Funcs(fm)
-	tpl.Execute(&buf, nil)
-
-	return ioutil.WriteFile(FILEBEAT_CONFIG, []byte(allTpl), 0666)
+	data := map[string]string{
+		"dataDir":      filepath.Join(filepath.Dir(registryFile), "data"),
+		"registryFile": registryFile,
+		"inputs":       filepath.Join(confDir, "*.yml"),
+	}
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 func putIfEnvNotEmpty(args ...interface{}) string {
@@ -165,4 +199,5 @@ func envArray(args ...interface{}) string {
 var fm = template.FuncMap{
 	"putIfEnvNotEmpty": putIfEnvNotEmpty,
 	"envArray":         envArray,
+	"yamlString":       yamlString,
 }
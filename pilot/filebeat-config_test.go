@@ -1,9 +1,9 @@
 package pilot
 
 import (
+	"os"
 	"testing"
 	"text/template"
-	"os"
 )
 
 func TestRenderFunc(t *testing.T) {
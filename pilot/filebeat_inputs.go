@@ -0,0 +1,96 @@
+package pilot
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// filebeatInput is a prospector of filebeat, rendered from a log config
+// when no template is given. It matches assets/filebeat/filebeat.tpl.
+type filebeatInput struct {
+	Type              string                 `yaml:"type"`
+	Enabled           bool                   `yaml:"enabled"`
+	Paths             []string               `yaml:"paths"`
+	ScanFrequency     string                 `yaml:"scan_frequency"`
+	FieldsUnderRoot   bool                   `yaml:"fields_under_root"`
+	DockerJSON        *filebeatDockerJSON    `yaml:"docker-json,omitempty"`
+	MaxBytes          *int64                 `yaml:"max_bytes,omitempty"`
+	JSONKeysUnderRoot bool                   `yaml:"json.keys_under_root,omitempty"`
+	Fields            map[string]interface{} `yaml:"fields"`
+	TailFiles         bool                   `yaml:"tail_files"`
+	IgnoreOlder       string                 `yaml:"ignore_older,omitempty"`
+	CloseInactive     string                 `yaml:"close_inactive"`
+	CloseEOF          bool                   `yaml:"close_eof"`
+	CloseRemoved      bool                   `yaml:"close_removed"`
+	CleanRemoved      bool                   `yaml:"clean_removed"`
+	CloseRenamed      bool                   `yaml:"close_renamed"`
+	Multiline         *filebeatMultiline     `yaml:"multiline,omitempty"`
+	Encoding          string                 `yaml:"encoding"`
+	ExcludeFiles      []string               `yaml:"exclude_files,omitempty"`
+}
+
+type filebeatDockerJSON struct {
+	Stream  string `yaml:"stream"`
+	Partial bool   `yaml:"partial"`
+}
+
+type filebeatMultiline struct {
+	Pattern string `yaml:"pattern"`
+	Negate  bool   `yaml:"negate"`
+	Match   string `yaml:"match"`
+}
+
+// newFilebeatInput builds the prospector of a log config, whose tags are
+// named after the schema already.
+func newFilebeatInput(config *LogConfig, metadata map[string]string) *filebeatInput {
+	input := &filebeatInput{
+		Type:            "log",
+		Enabled:         true,
+		ScanFrequency:   fmt.Sprintf("%ds", seconds(config.Tail.ScanFrequency)),
+		FieldsUnderRoot: true,
+		Fields:          escapeVars(nest(merge(config.Tags, metadata))).(map[string]interface{}),
+		TailFiles:       !config.Tail.FromHead(),
+		CloseInactive:   fmt.Sprintf("%ds", seconds(config.Tail.CloseInactive)),
+		CloseEOF:        false,
+		CloseRemoved:    true,
+		CleanRemoved:    true,
+		CloseRenamed:    seconds(config.Tail.RotateWait) == 0,
+		Encoding:        config.Tail.Encoding,
+		ExcludeFiles:    config.Tail.ExcludeRegexps(),
+	}
+	// beats expand variables in strings, unless $ is doubled
+	for _, path := range config.Paths {
+		input.Paths = append(input.Paths, strings.Replace(path, "$", "$$", -1))
+	}
+	if config.Stdout {
+		input.DockerJSON = &filebeatDockerJSON{Stream: config.Stream, Partial: config.Partial}
+		maxBytes := config.MaxBytes
+		input.MaxBytes = &maxBytes
+	}
+	if config.Format == "json" {
+		input.JSONKeysUnderRoot = true
+	}
+	if config.Multiline != "" {
+		input.Multiline = &filebeatMultiline{
+			Pattern: strings.Replace(config.Multiline, "$", "$$", -1),
+			Negate:  true,
+			Match:   "after",
+		}
+	}
+	if config.Tail.IgnoreOlder != 0 {
+		input.IgnoreOlder = fmt.Sprintf("%ds", seconds(config.Tail.IgnoreOlder))
+	}
+	return input
+}
+
+// renderFilebeatInputs renders the prospectors of the logs of a container.
+func renderFilebeatInputs(configs []*LogConfig, metadata map[string]string) (string, error) {
+	inputs := make([]*filebeatInput, 0, len(configs))
+	for _, config := range configs {
+		inputs = append(inputs, newFilebeatInput(config, metadata))
+	}
+	b, err := yaml.Marshal(inputs)
+	return string(b), err
+}
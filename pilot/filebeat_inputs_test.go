@@ -0,0 +1,119 @@
+package pilot
+
+import (
+	"time"
+
+	"github.com/elastic/go-ucfg"
+	ucfgyaml "github.com/elastic/go-ucfg/yaml"
+	"gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
+)
+
+type FilebeatInputsSuite struct{}
+
+var _ = check.Suite(&FilebeatInputsSuite{})
+
+func testInputConfigs() []*LogConfig {
+	tail := defaultTailOptions()
+	tail.Start = TAIL_START_END
+	tail.IgnoreOlder = 48 * time.Hour
+	tail.RotateWait = 0
+	tail.Exclude = nil
+	return []*LogConfig{{
+		Name:      "app",
+		HostDir:   "/host/data",
+		File:      "app $1.log",
+		Paths:     []string{"/host/data/app $1.log"},
+		Format:    "json",
+		Tags:      map[string]string{"team": "shop", "var": "${HOME}", "index.name": "app"},
+		Tail:      defaultTailOptions(),
+		Multiline: `^\d{4}-\d{2}-\d{2} .*$`,
+	}, {
+		Name:     "errors",
+		HostDir:  "/host/var/lib/docker/containers/c1",
+		File:     "c1-json.log",
+		Paths:    []string{"/host/var/lib/docker/containers/c1/c1-json.log*"},
+		Format:   "none",
+		Stdout:   true,
+		Stream:   STREAM_STDERR,
+		Partial:  true,
+		MaxBytes: 65536,
+		Tail:     tail,
+	}}
+}
+
+// parseInputs reads rendered inputs, leaving out null values.
+func parseInputs(c *check.C, out string) []map[string]interface{} {
+	var inputs []map[string]interface{}
+	c.Assert(yaml.Unmarshal([]byte(out), &inputs), check.IsNil)
+	for _, input := range inputs {
+		for key, value := range input {
+			if value == nil {
+				delete(input, key)
+			}
+		}
+	}
+	return inputs
+}
+
+func (s *FilebeatInputsSuite) TestSameAsTemplate(c *check.C) {
+	container := map[string]string{"docker_container": "web", "k8s_pod": "web-1"}
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "filebeat/filebeat.tpl")))
+	expected, err := p.render("c1", container, testInputConfigs())
+	c.Assert(err, check.IsNil)
+
+	p, _ = newTestPilot(c, newFakeDocker())
+	p.tpl = nil
+	out, err := p.render("c1", container, testInputConfigs())
+	c.Assert(err, check.IsNil)
+
+	inputs := parseInputs(c, out)
+	c.Assert(inputs, check.HasLen, 2)
+	c.Assert(inputs, check.DeepEquals, parseInputs(c, expected))
+	// multiline is off unless a log sets it
+	c.Assert(inputs[0]["multiline"], check.DeepEquals, map[interface{}]interface{}{
+		"pattern": `^\d{4}-\d{2}-\d{2} .*$$`,
+		"negate":  true,
+		"match":   "after",
+	})
+	_, ok := inputs[1]["multiline"]
+	c.Assert(ok, check.Equals, false)
+	_, ok = inputs[0]["document_type"]
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *FilebeatInputsSuite) TestVariables(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker())
+	p.tpl = nil
+	configs := testInputConfigs()
+	out, err := p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+
+	// as filebeat does, with variables expanded
+	cfg, err := ucfgyaml.NewConfig([]byte(out), ucfg.PathSep("."), ucfg.ResolveEnv, ucfg.VarExp)
+	c.Assert(err, check.IsNil)
+	var inputs []struct {
+		Paths  []string `config:"paths"`
+		Fields struct {
+			Team  string            `config:"team"`
+			Var   string            `config:"var"`
+			Index map[string]string `config:"index"`
+		} `config:"fields"`
+		ExcludeFiles []string `config:"exclude_files"`
+	}
+	c.Assert(cfg.Unpack(&inputs), check.IsNil)
+	// escapes are kept by the go-ucfg of pilot, dropped by later ones
+	c.Assert(inputs[0].Paths, check.HasLen, 1)
+	c.Assert(inputs[0].Paths[0], check.Matches, `/host/data/app \$+1\.log`)
+	c.Assert(inputs[0].Fields.Team, check.Equals, "shop")
+	c.Assert(inputs[0].Fields.Var, check.Matches, `\$+\{HOME\}`)
+	c.Assert(inputs[0].Fields.Index, check.DeepEquals, map[string]string{"name": "app"})
+	c.Assert(inputs[0].ExcludeFiles, check.DeepEquals, configs[0].Tail.ExcludeRegexps())
+	c.Assert(inputs[1].ExcludeFiles, check.HasLen, 0)
+}
+
+func (s *FilebeatInputsSuite) TestTemplateRequired(c *check.C) {
+	_, err := New(WithDockerClient(newFakeDocker()), WithPiloter(&fakePiloter{}))
+	c.Assert(err, check.ErrorMatches, "template is required by fluentd")
+}
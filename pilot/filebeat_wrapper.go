@@ -6,13 +6,14 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/elastic/go-ucfg"
 	"github.com/elastic/go-ucfg/yaml"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"time"
 	"regexp"
-	"io/ioutil"
 	"strings"
+	"syscall"
+	"time"
 )
 
 const PILOT_FILEBEAT = "filebeat"
@@ -26,20 +27,40 @@ const FILEBEAT_LOG_DIR = "/var/log/filebeat"
 const DOCKER_HOME_PATH = "/var/lib/docker/"
 const KUBELET_HOME_PATH = "/var/lib/kubelet/"
 
-var filebeat *exec.Cmd
-
 type FilebeatPiloter struct {
 	name           string
 	base           string
+	confDir        string
+	confFile       string
+	registryFile   string
+	filebeat       *exec.Cmd
+	logger         log.FieldLogger
 	watchDone      chan bool
 	watchDuration  time.Duration
 	watchContainer map[string]string
 }
 
-func NewFilebeatPiloter(base string) (Piloter, error) {
+// NewFilebeatPiloter drives filebeat, with the configs of containers in
+// confDir, default FILEBEAT_CONF_DIR, its main config confFile, default
+// FILEBEAT_CONF_FILE, and its registry registryFile, default
+// FILEBEAT_REGISTRY_FILE.
+func NewFilebeatPiloter(base, confDir, confFile, registryFile string, logger log.FieldLogger) (Piloter, error) {
+	if confDir == "" {
+		confDir = FILEBEAT_CONF_DIR
+	}
+	if confFile == "" {
+		confFile = FILEBEAT_CONF_FILE
+	}
+	if registryFile == "" {
+		registryFile = FILEBEAT_REGISTRY_FILE
+	}
 	return &FilebeatPiloter{
 		name:           PILOT_FILEBEAT,
 		base:           base,
+		confDir:        confDir,
+		confFile:       confFile,
+		registryFile:   registryFile,
+		logger:         logger,
 		watchDone:      make(chan bool),
 		watchContainer: make(map[string]string, 0),
 		watchDuration:  60 * time.Second,
@@ -71,21 +92,20 @@ type RegistryState struct {
 }
 
 func (p *FilebeatPiloter) watch() error {
-	log.Infof("%s watcher start", p.Name())
+	p.logger.Infof("%s watcher start", p.Name())
 	for {
 		select {
 		case <-p.watchDone:
-			log.Infof("%s watcher stop", p.Name())
+			p.logger.Infof("%s watcher stop", p.Name())
 			return nil
 		case <-time.After(p.watchDuration):
 			//log.Debugf("%s watcher scan", p.Name())
 			err := p.scan()
 			if err != nil {
-				log.Errorf("%s watcher scan error: %v", p.Name(), err)
+				p.logger.Errorf("%s watcher scan error: %v", p.Name(), err)
 			}
 		}
 	}
-	return nil
 }
 
 func (p *FilebeatPiloter) scan() error {
@@ -98,12 +118,12 @@ func (p *FilebeatPiloter) scan() error {
 	for container := range p.watchContainer {
 		confPath := p.ConfPathOf(container)
 		if _, err := os.Stat(confPath); err != nil && os.IsNotExist(err) {
-			log.Infof("log config %s.yml has been removed and ignore", container)
+			p.logger.Infof("log config %s.yml has been removed and ignore", container)
 			delete(p.watchContainer, container)
 		} else if p.canRemoveConf(container, states, configPaths) {
-			log.Infof("try to remove log config %s.yml", container)
+			p.logger.Infof("try to remove log config %s.yml", container)
 			if err := os.Remove(confPath); err != nil {
-				log.Errorf("remove log config %s.yml fail: %v", container, err)
+				p.logger.Errorf("remove log config %s.yml fail: %v", container, err)
 			} else {
 				delete(p.watchContainer, container)
 			}
@@ -121,22 +141,21 @@ func (p *FilebeatPiloter) canRemoveConf(container string, registry map[string]Re
 
 	for _, path := range config.Paths {
 		autoMount := p.isAutoMountPath(filepath.Dir(path))
-		logFiles, _ := filepath.Glob(path)
-		for _, logFile := range logFiles {
+		for _, logFile := range globFiles([]string{path}) {
 			info, err := os.Stat(logFile)
 			if err != nil && os.IsNotExist(err) {
 				continue
 			}
 			if _, ok := registry[logFile]; !ok {
-				log.Warnf("%s->%s registry not exist", container, logFile)
+				p.logger.Warnf("%s->%s registry not exist", container, logFile)
 				continue
 			}
 			if registry[logFile].Offset < info.Size() {
 				if autoMount { // ephemeral logs
-					log.Infof("%s->%s does not finish to read", container, logFile)
+					p.logger.Infof("%s->%s does not finish to read", container, logFile)
 					return false
 				} else if _, ok := configPaths[path]; !ok { // host path bind
-					log.Infof("%s->%s does not finish to read and not exist in other config",
+					p.logger.Infof("%s->%s does not finish to read and not exist in other config",
 						container, logFile)
 					return false
 				}
@@ -150,13 +169,13 @@ func (p *FilebeatPiloter) loadConfig(container string) (*Config, error) {
 	confPath := p.ConfPathOf(container)
 	c, err := yaml.NewConfigWithFile(confPath, configOpts...)
 	if err != nil {
-		log.Errorf("read %s.yml log config error: %v", container, err)
+		p.logger.Errorf("read %s.yml log config error: %v", container, err)
 		return nil, err
 	}
 
 	var config Config
 	if err := c.Unpack(&config); err != nil {
-		log.Errorf("parse %s.yml log config error: %v", container, err)
+		p.logger.Errorf("parse %s.yml log config error: %v", container, err)
 		return nil, err
 	}
 	return &config, nil
@@ -197,7 +216,7 @@ func (p *FilebeatPiloter) isAutoMountPath(path string) bool {
 }
 
 func (p *FilebeatPiloter) getRegsitryState() (map[string]RegistryState, error) {
-	f, err := os.Open(FILEBEAT_REGISTRY_FILE)
+	f, err := os.Open(p.registryFile)
 	if err != nil {
 		return nil, err
 	}
@@ -219,55 +238,75 @@ func (p *FilebeatPiloter) getRegsitryState() (map[string]RegistryState, error) {
 	return statesMap, nil
 }
 
+// Offsets returns the registry offset of every file read for the log config.
+func (p *FilebeatPiloter) Offsets(container string, config *LogConfig) (map[string]int64, error) {
+	states, err := p.getRegsitryState()
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[string]int64)
+	for _, logFile := range globFiles(config.Paths) {
+		if state, ok := states[logFile]; ok {
+			offsets[logFile] = state.Offset
+		}
+	}
+	return offsets, nil
+}
+
 func (p *FilebeatPiloter) feed(containerID string) error {
 	if _, ok := p.watchContainer[containerID]; !ok {
 		p.watchContainer[containerID] = containerID
-		log.Infof("begin to watch log config: %s.yml", containerID)
+		p.logger.Infof("begin to watch log config: %s.yml", containerID)
 	}
 	return nil
 }
 
 func (p *FilebeatPiloter) Start() error {
-	if filebeat != nil {
+	if p.filebeat != nil {
 		return fmt.Errorf(ERR_ALREADY_STARTED)
 	}
 
-	log.Info("start filebeat")
-	filebeat = exec.Command(FILEBEAT_EXEC_BIN, "-c", FILEBEAT_CONF_FILE)
-	filebeat.Stderr = os.Stderr
-	filebeat.Stdout = os.Stdout
-	err := filebeat.Start()
+	p.logger.Info("start filebeat")
+	p.filebeat = exec.Command(FILEBEAT_EXEC_BIN, "-c", p.confFile)
+	p.filebeat.Stderr = os.Stderr
+	p.filebeat.Stdout = os.Stdout
+	err := p.filebeat.Start()
 	if err != nil {
-		log.Error(err)
+		p.logger.Error(err)
 	}
 
-	go func() {
+	go func(filebeat *exec.Cmd) {
 		err := filebeat.Wait()
 		if err != nil {
-			log.Error(err)
+			p.logger.Error(err)
 		}
-	}()
+	}(p.filebeat)
 
 	go p.watch()
 	return err
 }
 
 func (p *FilebeatPiloter) Stop() error {
+	if p.filebeat == nil || p.filebeat.Process == nil {
+		return nil
+	}
 	p.watchDone <- true
-	return nil
+	p.logger.Info("stop filebeat")
+	return p.filebeat.Process.Signal(syscall.SIGTERM)
 }
 
 func (p *FilebeatPiloter) Reload() error {
-	log.Debug("not need to reload filebeat")
+	p.logger.Debug("not need to reload filebeat")
 	return nil
 }
 
 func (p *FilebeatPiloter) ConfPathOf(container string) string {
-	return fmt.Sprintf("%s/%s.yml", FILEBEAT_CONF_DIR, container)
+	return fmt.Sprintf("%s/%s.yml", p.confDir, container)
 }
 
 func (p *FilebeatPiloter) ConfHome() string {
-	return FILEBEAT_CONF_DIR
+	return p.confDir
 }
 
 func (p *FilebeatPiloter) Name() string {
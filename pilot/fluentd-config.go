@@ -0,0 +1,337 @@
+package pilot
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// FLUENTD_CONFIG is the main config of fluentd, including the configs of
+// containers and named outputs.
+const FLUENTD_CONFIG = "/etc/fluentd/fluentd.conf"
+
+// FLUENTD_SECRETS_DIR holds credential files, user:password, which take
+// precedence over the environment.
+const FLUENTD_SECRETS_DIR = "/run/secrets"
+
+// fluentdConfigHeader tells a main config written by pilot, others are
+// kept as they are.
+const fluentdConfigHeader = "# Generated by log-pilot from FLUENTD_OUTPUT and the environment, do not edit."
+
+// fluentdEnv reads the variables of the main config of fluentd, and
+// records the required ones which are not set.
+type fluentdEnv struct {
+	getenv  func(string) string
+	secrets string
+	// overrides are the variables read from secret files
+	overrides map[string]string
+	missing   []string
+	err       error
+}
+
+func (e *fluentdEnv) get(name string) string {
+	value, ok := e.overrides[name]
+	if !ok {
+		value = e.getenv(name)
+	}
+	// a newline would start another param or directive
+	if err := checkValue(value); err != nil && e.err == nil {
+		e.err = fmt.Errorf("%s: %v", name, err)
+	}
+	return value
+}
+
+// value returns the variable, or def when it's empty.
+func (e *fluentdEnv) value(name, def string) string {
+	if value := e.get(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func (e *fluentdEnv) required(name string) string {
+	value := e.get(name)
+	if value == "" {
+		e.missing = append(e.missing, name)
+	}
+	return value
+}
+
+// secret reads user:password from a file of the secrets dir, if it exists,
+// into the variables user and password.
+func (e *fluentdEnv) secret(file, user, password string) error {
+	b, err := ioutil.ReadFile(filepath.Join(e.secrets, file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(b)), ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("secret %s must be %s:%s", file, strings.ToLower(user), strings.ToLower(password))
+	}
+	e.overrides[user], e.overrides[password] = parts[0], parts[1]
+	return nil
+}
+
+// fluentdConf writes a fluentd config, params are left out when unset.
+type fluentdConf struct {
+	bytes.Buffer
+	env    *fluentdEnv
+	indent string
+}
+
+func (c *fluentdConf) line(format string, args ...interface{}) {
+	fmt.Fprintf(c, c.indent+format+"\n", args...)
+}
+
+func (c *fluentdConf) open(directive string) {
+	c.line("<%s>", directive)
+	c.indent += "  "
+}
+
+func (c *fluentdConf) close(name string) {
+	c.indent = c.indent[:len(c.indent)-2]
+	c.line("</%s>", name)
+}
+
+// param writes key with the value of the variable name, if it's set.
+func (c *fluentdConf) param(key, name string) {
+	if value := c.env.get(name); value != "" {
+		c.line("%s %s", key, value)
+	}
+}
+
+// buffered writes the buffer and retry params shared by buffered outputs.
+func (c *fluentdConf) buffered() {
+	for _, param := range [][2]string{
+		{"buffer_type", "FLUENTD_BUFFER_TYPE"},
+		{"buffer_chunk_limit", "FLUENTD_BUFFER_CHUNK_LIMIT"},
+		{"buffer_queue_limit", "FLUENTD_BUFFER_QUEUE_LIMIT"},
+		{"chunk_limit_size", "FLUENTD_BUFFER_CHUNK_LIMIT_SIZE"},
+		{"total_limit_size", "FLUENTD_BUFFER_TOTAL_LIMIT_SIZE"},
+		{"chunk_full_threshold", "FLUENTD_BUFFER_CHUNK_FULL_THRESHOLD"},
+		{"compress", "FLUENTD_BUFFER_COMPRESS"},
+		{"flush_interval", "FLUENTD_FLUSH_INTERVAL"},
+		{"flush_mode", "FLUENTD_FLUSH_MODE"},
+		{"flush_thread_count", "FLUENTD_FLUSH_THREAD_COUNT"},
+		{"flush_at_shutdown", "FLUENTD_FLUSH_AT_SHUTDOWN"},
+		{"disable_retry_limit", "FLUENTD_DISABLE_RETRY_LIMIT"},
+		{"retry_limit", "FLUENTD_RETRY_LIMIT"},
+		{"retry_wait", "FLUENTD_RETRY_WAIT"},
+		{"max_retry_wait", "FLUENTD_MAX_RETRY_WAIT"},
+		{"num_threads", "FLUENTD_NUM_THREADS"},
+	} {
+		c.param(param[0], param[1])
+	}
+}
+
+// fluentdOutputs write the match of container logs for each FLUENTD_OUTPUT,
+// and return the directives it needs besides, written after it.
+var fluentdOutputs = map[string]func(c *fluentdConf) (string, error){
+	"elasticsearch": func(c *fluentdConf) (string, error) {
+		if err := c.env.secret("es_credential", "ELASTICSEARCH_USER", "ELASTICSEARCH_PASSWORD"); err != nil {
+			return "", err
+		}
+		c.line("@type elasticsearch")
+		c.line("hosts %s:%s", c.env.required("ELASTICSEARCH_HOST"), c.env.required("ELASTICSEARCH_PORT"))
+		c.line("reconnect_on_error true")
+		c.param("user", "ELASTICSEARCH_USER")
+		c.param("password", "ELASTICSEARCH_PASSWORD")
+		c.param("path", "ELASTICSEARCH_PATH")
+		c.param("scheme", "ELASTICSEARCH_SCHEME")
+		c.param("ssl_verify", "ELASTICSEARCH_SSL_VERIFY")
+		c.line("target_index_key _target")
+		c.line("type_name fluentd")
+		c.buffered()
+		return "", nil
+	},
+	"file": func(c *fluentdConf) (string, error) {
+		path := c.env.required("FILE_PATH")
+		c.line("@type file")
+		c.line("path %s/${docker_app}/${docker_service}/${docker_container}/${tag[2]}.%%Y-%%m-%%d", path)
+		c.line("append %s", c.env.value("FILE_APPEND", "true"))
+		c.param("compress", "FILE_COMPRESS")
+		c.open("format")
+		c.line("@type %s", c.env.value("FILE_FORMAT", "json"))
+		c.close("format")
+		c.open("buffer tag,time,docker_app,docker_service,docker_container")
+		c.line("@type %s", c.env.value("FILE_BUFFER_TYPE", "file"))
+		c.line("path %s/.buffer", path)
+		c.line("timekey %s", c.env.value("FILE_BUFFER_TIME_KEY", "1d"))
+		c.line("timekey_wait %s", c.env.value("FILE_BUFFER_TIME_KEY_WAIT", "5m"))
+		c.line("timekey_use_utc %s", c.env.value("FILE_BUFFER_TIME_KEY_USE_UTC", "false"))
+		c.buffered()
+		c.close("buffer")
+		return "", nil
+	},
+	"graylog": func(c *fluentdConf) (string, error) {
+		c.line("@type gelf")
+		c.line("host %s", c.env.required("GRAYLOG_HOST"))
+		c.line("port %s", c.env.required("GRAYLOG_PORT"))
+		c.line("protocol %s", c.env.value("GRAYLOG_PROTOCOL", "udp"))
+		c.line("flush_interval 3s")
+		c.buffered()
+		return "", nil
+	},
+	"aliyun_sls": func(c *fluentdConf) (string, error) {
+		if err := c.env.secret("aliyun_access_key", "ALIYUNSLS_ACCESS_KEY_ID", "ALIYUNSLS_ACCESS_KEY_SECRET"); err != nil {
+			return "", err
+		}
+		c.line("@type aliyun_sls")
+		c.line("project %s", c.env.required("ALIYUNSLS_PROJECT"))
+		c.line("region_endpoint %s", c.env.required("ALIYUNSLS_REGION_ENDPOINT"))
+		c.line("access_key_id %s", c.env.required("ALIYUNSLS_ACCESS_KEY_ID"))
+		c.line("access_key_secret %s", c.env.required("ALIYUNSLS_ACCESS_KEY_SECRET"))
+		c.line("ssl_verify %s", c.env.value("SSL_VERIFY", "false"))
+		c.line("need_create_logstore %s", c.env.value("ALIYUNSLS_NEED_CREATE_LOGSTORE", "false"))
+		c.line("create_logstore_ttl %s", c.env.value("ALIYUNSLS_CREATE_LOGSTORE_TTL", "1"))
+		c.line("create_logstore_shard_count %s", c.env.value("ALIYUNSLS_CREATE_LOGSTORE_SHARD_COUNT", "2"))
+		c.buffered()
+		return "", nil
+	},
+	"syslog": func(c *fluentdConf) (string, error) {
+		c.line("@type remote_syslog")
+		c.line("host %s", c.env.required("SYSLOG_HOST"))
+		c.line("port %s", c.env.required("SYSLOG_PORT"))
+		c.param("facility", "SYSLOG_FACILITY")
+		c.param("severity", "SYSLOG_SEVERITY")
+		c.line("tag %s", c.env.value("SYSLOG_TAG", "fluentd-pilot"))
+		return "", nil
+	},
+	"kafka": func(c *fluentdConf) (string, error) {
+		c.line("@type kafka_buffered")
+		c.line("brokers %s", c.env.required("KAFKA_BROKERS"))
+		for _, param := range []string{
+			"default_topic",
+			"default_partition_key",
+			"default_message_key",
+			"output_data_type",
+			"output_include_tag",
+			"output_include_time",
+			"exclude_topic_key",
+			"exclude_partition_key",
+			"get_kafka_client_log",
+			"max_send_retries",
+			"required_acks",
+			"ack_timeout",
+			"compression_codec",
+			"kafka_agg_max_bytes",
+			"kafka_agg_max_messages",
+			"max_send_limit_bytes",
+			"discard_kafka_delivery_failed",
+		} {
+			c.param(param, "KAFKA_"+strings.ToUpper(param))
+		}
+		c.buffered()
+		return "", nil
+	},
+	"null": func(c *fluentdConf) (string, error) {
+		c.line("@type null")
+		return "", nil
+	},
+	// flowcounter prints the count of logs every 30s
+	"flowcounter": func(c *fluentdConf) (string, error) {
+		c.line("@type flowcounter")
+		c.line("tag flowcounter")
+		c.line("count_interval 30s")
+		c.line("aggregate all")
+		// counts are emitted with the tag flowcounter
+		counts := &fluentdConf{env: c.env}
+		counts.open("match flowcounter")
+		counts.line("@type stdout")
+		counts.close("match")
+		return counts.String(), nil
+	},
+	"stdout": func(c *fluentdConf) (string, error) {
+		c.line("@type stdout")
+		return "", nil
+	},
+}
+
+// renderFluentdCfg renders the main config of fluentd, including the configs
+// of confDir and sending logs to output, from the variables of getenv and the
+// credential files of secrets.
+func renderFluentdCfg(getenv func(string) string, secrets, confDir, output string) (string, error) {
+	env := &fluentdEnv{getenv: getenv, secrets: secrets, overrides: make(map[string]string)}
+	c := &fluentdConf{env: env}
+	c.line(fluentdConfigHeader)
+	c.line("@include " + filepath.Join(confDir, "*.conf"))
+	c.line("")
+
+	if output == "" || output == "console" {
+		output = "stdout"
+	}
+	write, ok := fluentdOutputs[output]
+	if !ok {
+		var names []string
+		for name := range fluentdOutputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		log.Warnf("unsupported fluentd output %q, logs go to stdout: it must be one of %s", output, strings.Join(names, ", "))
+		output = "stdout"
+		write = fluentdOutputs[output]
+	}
+	c.open("match docker.**")
+	directives, err := write(c)
+	if err != nil {
+		return "", err
+	}
+	c.close("match")
+	c.WriteString(directives)
+	if len(env.missing) > 0 {
+		return "", fmt.Errorf("fluentd output %s requires %s", output, strings.Join(env.missing, ", "))
+	}
+
+	c.line("")
+	c.open("system")
+	c.param("@log_level", "FLUENTD_LOG_LEVEL")
+	c.close("system")
+	if env.get("FLUENTD_ENABLE_MONITOR") == "true" {
+		c.open("source")
+		c.line("@type monitor_agent")
+		c.line("bind 0.0.0.0")
+		c.line("port 24220")
+		c.close("source")
+	}
+	if env.err != nil {
+		return "", env.err
+	}
+	return c.String(), nil
+}
+
+// RenderFluentdCfg renders the main config of fluentd from the environment,
+// without writing it.
+func RenderFluentdCfg(confDir, output string) (string, error) {
+	return renderFluentdCfg(os.Getenv, FLUENTD_SECRETS_DIR, confDir, output)
+}
+
+// CreateFluentdCfg writes the main config of fluentd path on every start, so
+// it follows the environment. A config pilot didn't write is kept.
+func CreateFluentdCfg(path, confDir, output string) error {
+	if f, err := os.Open(path); err == nil {
+		first, _ := bufio.NewReader(f).ReadString('\n')
+		f.Close()
+		if strings.TrimSpace(first) != fluentdConfigHeader {
+			return nil
+		}
+	}
+
+	cfg, err := RenderFluentdCfg(confDir, output)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(confDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(cfg), 0644)
+}
@@ -0,0 +1,126 @@
+package pilot
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of testdata")
+
+type FluentdConfigSuite struct{}
+
+var _ = check.Suite(&FluentdConfigSuite{})
+
+// testEnv is an environment of variables, unset ones are empty.
+func testEnv(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+// writeSecrets writes secret files in a new dir.
+func writeSecrets(c *check.C, files map[string]string) string {
+	dir := c.MkDir()
+	for name, content := range files {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600), check.IsNil)
+	}
+	return dir
+}
+
+func (s *FluentdConfigSuite) TestGolden(c *check.C) {
+	for name, test := range map[string]struct {
+		env     map[string]string
+		secrets map[string]string
+	}{
+		"stdout": {},
+		"elasticsearch": {
+			env: map[string]string{
+				"FLUENTD_OUTPUT":         "elasticsearch",
+				"ELASTICSEARCH_HOST":     "es.local",
+				"ELASTICSEARCH_PORT":     "9200",
+				"ELASTICSEARCH_USER":     "ignored",
+				"ELASTICSEARCH_PATH":     "/es",
+				"FLUENTD_BUFFER_TYPE":    "file",
+				"FLUENTD_FLUSH_INTERVAL": "5s",
+				"FLUENTD_LOG_LEVEL":      "warn",
+				"FLUENTD_ENABLE_MONITOR": "true",
+			},
+			secrets: map[string]string{"es_credential": "elastic:pass:word\n"},
+		},
+		"file": {
+			env: map[string]string{"FLUENTD_OUTPUT": "file", "FILE_PATH": "/var/log/pilot", "FILE_COMPRESS": "gzip", "FLUENTD_RETRY_LIMIT": "3"},
+		},
+		"graylog": {
+			env: map[string]string{"FLUENTD_OUTPUT": "graylog", "GRAYLOG_HOST": "graylog.local", "GRAYLOG_PORT": "12201"},
+		},
+		"aliyun_sls": {
+			env: map[string]string{
+				"FLUENTD_OUTPUT":                "aliyun_sls",
+				"ALIYUNSLS_PROJECT":             "shop",
+				"ALIYUNSLS_REGION_ENDPOINT":     "cn-hangzhou.log.aliyuncs.com",
+				"ALIYUNSLS_CREATE_LOGSTORE_TTL": "30",
+			},
+			secrets: map[string]string{"aliyun_access_key": "id:secret"},
+		},
+		"syslog": {
+			env: map[string]string{"FLUENTD_OUTPUT": "syslog", "SYSLOG_HOST": "syslog.local", "SYSLOG_PORT": "514", "SYSLOG_FACILITY": "user", "SYSLOG_SEVERITY": "info"},
+		},
+		"kafka": {
+			env: map[string]string{
+				"FLUENTD_OUTPUT":              "kafka",
+				"KAFKA_BROKERS":               "kafka-1:9092,kafka-2:9092",
+				"KAFKA_DEFAULT_TOPIC":         "logs",
+				"KAFKA_DEFAULT_PARTITION_KEY": "host",
+				"KAFKA_REQUIRED_ACKS":         "1",
+				"FLUENTD_BUFFER_CHUNK_LIMIT":  "8m",
+			},
+		},
+		"null":        {env: map[string]string{"FLUENTD_OUTPUT": "null"}},
+		"flowcounter": {env: map[string]string{"FLUENTD_OUTPUT": "flowcounter"}},
+	} {
+		out, err := renderFluentdCfg(testEnv(test.env), writeSecrets(c, test.secrets), FLUENTD_CONF_HOME, test.env[ENV_FLUENTD_OUTPUT])
+		c.Assert(err, check.IsNil, check.Commentf(name))
+
+		golden := filepath.Join("testdata", "fluentd", name+".conf")
+		if *updateGolden {
+			c.Assert(ioutil.WriteFile(golden, []byte(out), 0644), check.IsNil)
+		}
+		expected, err := ioutil.ReadFile(golden)
+		c.Assert(err, check.IsNil)
+		c.Assert(out, check.Equals, string(expected), check.Commentf(name))
+	}
+}
+
+func (s *FluentdConfigSuite) TestUnknownOutput(c *check.C) {
+	// logs go to stdout rather than nowhere
+	out, err := renderFluentdCfg(testEnv(nil), c.MkDir(), FLUENTD_CONF_HOME, "logstash")
+	c.Assert(err, check.IsNil)
+	expected, err := ioutil.ReadFile(filepath.Join("testdata", "fluentd", "stdout.conf"))
+	c.Assert(err, check.IsNil)
+	c.Assert(out, check.Equals, string(expected))
+}
+
+func (s *FluentdConfigSuite) TestInvalid(c *check.C) {
+	for expected, test := range map[string]struct {
+		env     map[string]string
+		secrets map[string]string
+	}{
+		"fluentd output elasticsearch requires ELASTICSEARCH_HOST, ELASTICSEARCH_PORT": {
+			env: map[string]string{"FLUENTD_OUTPUT": "elasticsearch"},
+		},
+		"fluentd output aliyun_sls requires ALIYUNSLS_ACCESS_KEY_ID, ALIYUNSLS_ACCESS_KEY_SECRET": {
+			env: map[string]string{"FLUENTD_OUTPUT": "aliyun_sls", "ALIYUNSLS_PROJECT": "shop", "ALIYUNSLS_REGION_ENDPOINT": "cn"},
+		},
+		"secret es_credential must be elasticsearch_user:elasticsearch_password": {
+			env:     map[string]string{"FLUENTD_OUTPUT": "elasticsearch", "ELASTICSEARCH_HOST": "es", "ELASTICSEARCH_PORT": "9200"},
+			secrets: map[string]string{"es_credential": "elastic"},
+		},
+		"KAFKA_DEFAULT_TOPIC: control character U\\+000A is not allowed": {
+			env: map[string]string{"FLUENTD_OUTPUT": "kafka", "KAFKA_BROKERS": "kafka:9092", "KAFKA_DEFAULT_TOPIC": "logs\n</match>"},
+		},
+	} {
+		_, err := renderFluentdCfg(testEnv(test.env), writeSecrets(c, test.secrets), FLUENTD_CONF_HOME, test.env[ENV_FLUENTD_OUTPUT])
+		c.Assert(err, check.ErrorMatches, expected)
+	}
+}
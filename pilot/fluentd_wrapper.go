@@ -3,83 +3,129 @@ package pilot
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
 const PILOT_FLUENTD = "fluentd"
 const FLUENTD_CONF_HOME = "/etc/fluentd/conf.d"
-
-var fluentd *exec.Cmd
+const FLUENTD_POS_HOME = "/pilot/pos"
 
 type FluentdPiloter struct {
-	name string
+	name     string
+	confDir  string
+	confFile string
+	fluentd  *exec.Cmd
+	logger   log.FieldLogger
 }
 
-func NewFluentdPiloter() (Piloter, error) {
+// NewFluentdPiloter drives fluentd, with the configs of containers in
+// confDir, default FLUENTD_CONF_HOME, and its main config confFile, default
+// FLUENTD_CONFIG.
+func NewFluentdPiloter(confDir, confFile string, logger log.FieldLogger) (Piloter, error) {
+	if confDir == "" {
+		confDir = FLUENTD_CONF_HOME
+	}
+	if confFile == "" {
+		confFile = FLUENTD_CONFIG
+	}
 	return &FluentdPiloter{
-		name: PILOT_FLUENTD,
+		name:     PILOT_FLUENTD,
+		confDir:  confDir,
+		confFile: confFile,
+		logger:   logger,
 	}, nil
 }
 
 func (p *FluentdPiloter) Start() error {
-	if fluentd != nil {
+	if p.fluentd != nil {
 		return fmt.Errorf(ERR_ALREADY_STARTED)
 	}
 
-	log.Info("start fluentd")
-	fluentd = exec.Command("/usr/bin/fluentd", "-c", "/etc/fluentd/fluentd.conf",
+	p.logger.Info("start fluentd")
+	p.fluentd = exec.Command("/usr/bin/fluentd", "-c", p.confFile,
 		"-p", "/etc/fluentd/plugins")
-	fluentd.Stderr = os.Stderr
-	fluentd.Stdout = os.Stdout
-	err := fluentd.Start()
+	p.fluentd.Stderr = os.Stderr
+	p.fluentd.Stdout = os.Stdout
+	err := p.fluentd.Start()
 	if err != nil {
-		log.Error(err)
+		p.logger.Error(err)
 	}
-	go func() {
+	go func(fluentd *exec.Cmd) {
 		err := fluentd.Wait()
 		if err != nil {
-			log.Error(err)
+			p.logger.Error(err)
 		}
-	}()
+	}(p.fluentd)
 	return err
 }
 
 func (p *FluentdPiloter) Stop() error {
-	return nil
+	if p.fluentd == nil || p.fluentd.Process == nil {
+		return nil
+	}
+	p.logger.Info("stop fluentd")
+	return p.fluentd.Process.Signal(syscall.SIGTERM)
 }
 
 func (p *FluentdPiloter) Reload() error {
-	if fluentd == nil {
+	if p.fluentd == nil {
 		err := fmt.Errorf("fluentd have not started")
-		log.Error(err)
+		p.logger.Error(err)
 		return err
 	}
 
-	log.Info("reload fluentd")
+	p.logger.Info("reload fluentd")
 	ch := make(chan struct{})
 	go func(pid int) {
 		command := fmt.Sprintf("pgrep -P %d", pid)
 		childId := shell(command)
-		log.Infof("before reload childId : %s", childId)
-		fluentd.Process.Signal(syscall.SIGHUP)
+		p.logger.Infof("before reload childId : %s", childId)
+		p.fluentd.Process.Signal(syscall.SIGHUP)
 		time.Sleep(5 * time.Second)
 		afterChildId := shell(command)
-		log.Infof("after reload childId : %s", childId)
+		p.logger.Infof("after reload childId : %s", childId)
 		if childId == afterChildId {
-			log.Infof("kill childId : %s", childId)
+			p.logger.Infof("kill childId : %s", childId)
 			shell("kill -9 " + childId)
 		}
 		close(ch)
-	}(fluentd.Process.Pid)
+	}(p.fluentd.Process.Pid)
 	<-ch
 	return nil
 }
 
 func (p *FluentdPiloter) ConfPathOf(container string) string {
-	return fmt.Sprintf("%s/%s.conf", FLUENTD_CONF_HOME, container)
+	return fmt.Sprintf("%s/%s.conf", p.confDir, container)
+}
+
+// Offsets returns the in_tail position of every file read for the log config.
+// fluentd keeps one pos file per source, see fluentd.tpl.
+func (p *FluentdPiloter) Offsets(container string, config *LogConfig) (map[string]int64, error) {
+	posFile := fmt.Sprintf("%s/%s.%s.pos", FLUENTD_POS_HOME, container, config.Name)
+	b, err := ioutil.ReadFile(posFile)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[string]int64)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		offset, err := strconv.ParseInt(fields[1], 16, 64)
+		if err != nil {
+			continue
+		}
+		offsets[fields[0]] = offset
+	}
+	return offsets, nil
 }
 
 func shell(command string) string {
@@ -92,7 +138,7 @@ func shell(command string) string {
 }
 
 func (p *FluentdPiloter) ConfHome() string {
-	return FLUENTD_CONF_HOME
+	return p.confDir
 }
 
 func (p *FluentdPiloter) Name() string {
@@ -100,6 +146,6 @@ func (p *FluentdPiloter) Name() string {
 }
 
 func (p *FluentdPiloter) OnDestroyEvent(container string) error {
-	log.Info("refactor in the future!!!")
+	p.logger.Info("refactor in the future!!!")
 	return nil
 }
@@ -18,6 +18,14 @@ func Convert(info *LogInfoNode) (map[string]string, error) {
 	return converter(info)
 }
 
+func (p *Pilot) convert(info *LogInfoNode) (map[string]string, error) {
+	converter := p.converters[info.value]
+	if converter == nil {
+		return nil, fmt.Errorf("unsupported log format: %s", info.value)
+	}
+	return converter(info)
+}
+
 type SimpleConverter struct {
 	properties map[string]bool
 }
@@ -0,0 +1,223 @@
+package pilot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// HOST_PATHS_RECHECK_INTERVAL is how often the symlinks of log paths are
+// checked again.
+const HOST_PATHS_RECHECK_INTERVAL = 30 * time.Second
+
+// HostPathsConfig restricts the host dirs logs are read from, whatever the
+// containers mount. Dirs are host paths, without the base dir.
+type HostPathsConfig struct {
+	// Allow are the dirs logs may be read from, any dir when empty.
+	Allow []string `config:"allow"`
+	// Deny are dirs logs are never read from, even under an allowed dir.
+	Deny []string `config:"deny"`
+}
+
+type hostPathPolicy struct {
+	allow []string
+	deny  []string
+}
+
+// WithHostPaths restricts the host dirs logs are read from.
+func WithHostPaths(config *HostPathsConfig) Option {
+	return func(p *Pilot) error {
+		policy := &hostPathPolicy{}
+		var err error
+		if policy.allow, err = cleanDirs(config.Allow); err != nil {
+			return fmt.Errorf("host_paths allow: %v", err)
+		}
+		if policy.deny, err = cleanDirs(config.Deny); err != nil {
+			return fmt.Errorf("host_paths deny: %v", err)
+		}
+		p.hostPaths = policy
+		return nil
+	}
+}
+
+func cleanDirs(dirs []string) ([]string, error) {
+	var ret []string
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) || hasMeta(dir) {
+			return nil, fmt.Errorf("%s must be an absolute path without wildcards", dir)
+		}
+		ret = append(ret, filepath.Clean(dir))
+	}
+	return ret, nil
+}
+
+// check tells why files of a host path pattern can't be read, nil when
+// they can. The literal prefix of the pattern is a resolved host path.
+func (h *hostPathPolicy) check(prefix string, rest string) error {
+	if h == nil {
+		return nil
+	}
+	if len(h.allow) > 0 {
+		allowed := false
+		for _, dir := range h.allow {
+			if _, ok := under(prefix, dir); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("host path %s is not in an allowed dir", prefix)
+		}
+	}
+	for _, dir := range h.deny {
+		if _, ok := under(prefix, dir); ok {
+			return fmt.Errorf("host path %s is in the denied dir %s", prefix, dir)
+		}
+		// the wildcards may reach into a denied dir below the prefix
+		if rel, ok := under(dir, prefix); ok && rest != "" &&
+			matchPrefix(strings.Split(rest, "/"), strings.Split(rel, "/")) {
+			return fmt.Errorf("host path %s may match files in the denied dir %s", filepath.Join(prefix, rest), dir)
+		}
+	}
+	return nil
+}
+
+// checkHostPath resolves a host path pattern relative to the base dir, and
+// tells why it can't be read. Symlinks are resolved as the log agent will,
+// so a link in a volume can't lead out of the base dir or the policy: those
+// of the literal prefix, and the existing ones the wildcards match.
+func (p *Pilot) checkHostPath(pattern string) (string, error) {
+	prefix, rest, err := splitPattern(pattern)
+	if err != nil {
+		return "", err
+	}
+	base := evalExisting(filepath.Clean("/" + p.base))
+	real := evalExisting(filepath.Join(base, prefix))
+	rel, ok := under(real, base)
+	if !ok {
+		return "", fmt.Errorf("host path %s resolves to %s, out of %s", prefix, real, base)
+	}
+	resolved := filepath.Join("/", rel)
+	if err := p.hostPaths.check(resolved, rest); err != nil {
+		return "", err
+	}
+	if rest == "" {
+		return resolved, nil
+	}
+	if err := p.checkWildcardLinks(resolved, rest); err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, rest), nil
+}
+
+// checkWildcardLinks checks the symlinks under a resolved dir that the
+// wildcards of rest match, files or dirs.
+func (p *Pilot) checkWildcardLinks(dir string, rest string) error {
+	segments := strings.Split(rest, "/")
+	root := filepath.Join(p.base, dir)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == root {
+			return nil
+		}
+		rel, _ := under(path, root)
+		if !matchPrefix(segments, strings.Split(rel, "/")) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			_, err := p.checkHostPath(filepath.Join(dir, rel))
+			return err
+		}
+		return nil
+	})
+}
+
+// watchHostPaths records the host path patterns of the logs of a container,
+// for recheckHostPaths.
+func (p *Pilot) watchHostPaths(id string, configs []*LogConfig) {
+	var patterns []string
+	for _, config := range configs {
+		if config.Stdout {
+			continue
+		}
+		for _, path := range config.Paths {
+			if rel, ok := under(path, filepath.Clean(p.base)); ok {
+				patterns = append(patterns, filepath.Join("/", rel))
+			}
+		}
+	}
+	p.watchedMutex.Lock()
+	defer p.watchedMutex.Unlock()
+	if len(patterns) == 0 {
+		delete(p.watched, id)
+		return
+	}
+	p.watched[id] = patterns
+}
+
+// recheckHostPaths plans again the containers whose log paths resolve to
+// other files than when they were declared: a container may create symlinks
+// in its volumes at any time. Logs now rejected are left out of the configs.
+// It runs in the event loop of Run, so a container can't be destroyed while
+// it is planned again.
+func (p *Pilot) recheckHostPaths() {
+	var changed []string
+	p.watchedMutex.Lock()
+	for id, patterns := range p.watched {
+		for _, pattern := range patterns {
+			if real, err := p.checkHostPath(pattern); err != nil || real != pattern {
+				p.logger.Warnf("%s: paths of logs changed: %s", id, pattern)
+				changed = append(changed, id)
+				delete(p.watched, id)
+				break
+			}
+		}
+	}
+	p.watchedMutex.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+
+	for _, id := range changed {
+		if err := os.Remove(p.piloter.ConfPathOf(id)); err != nil && !os.IsNotExist(err) {
+			p.logger.Errorf("remove log config of %s error: %v", id, err)
+			continue
+		}
+		containerJSON, err := p.client().ContainerInspect(context.Background(), id)
+		if err != nil {
+			p.logger.Warnf("inspect %s error: %v", id, err)
+			continue
+		}
+		if err := p.newContainer(&containerJSON); err != nil {
+			p.logger.Errorf("fail to process container %s: %v", containerJSON.Name, err)
+		}
+	}
+	p.tryReload()
+}
+
+// evalExisting resolves the symlinks of the longest existing prefix of path.
+func evalExisting(path string) string {
+	var missing []string
+	for {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				real = filepath.Join(real, missing[i])
+			}
+			return real
+		} else if !os.IsNotExist(err) {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		missing = append(missing, filepath.Base(path))
+		path = parent
+	}
+}
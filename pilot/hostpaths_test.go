@@ -0,0 +1,139 @@
+package pilot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"gopkg.in/check.v1"
+)
+
+type HostPathsSuite struct{}
+
+var _ = check.Suite(&HostPathsSuite{})
+
+func (s *HostPathsSuite) TestPolicy(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithHostPaths(&HostPathsConfig{
+		Allow: []string{"/var/lib/kubelet/pods", "/data/logs/"},
+		Deny:  []string{"/data/logs/secret"},
+	}))
+	for pattern, expected := range map[string]string{
+		"/var/lib/kubelet/pods/p1/volumes/logs/app.log": "",
+		"/data/logs/app/*.log":                          "",
+		"/data/logs/*/app.log":                          "host path /data/logs/\\*/app.log may match files in the denied dir /data/logs/secret",
+		"/data/logs/**/app.log":                         "host path /data/logs/\\*\\*/app.log may match files in the denied dir /data/logs/secret",
+		"/data/logs/a*/app.log":                         "",
+		"/data/logs/secret/app.log":                     "host path /data/logs/secret/app.log is in the denied dir /data/logs/secret",
+		"/data/logs/secret/*.log":                       "host path /data/logs/secret is in the denied dir /data/logs/secret",
+		"/etc/shadow":                                   "host path /etc/shadow is not in an allowed dir",
+		"/data/logsx/app.log":                           "host path /data/logsx/app.log is not in an allowed dir",
+	} {
+		_, err := p.checkHostPath(pattern)
+		if expected == "" {
+			c.Assert(err, check.IsNil, check.Commentf(pattern))
+		} else {
+			c.Assert(err, check.ErrorMatches, expected, check.Commentf(pattern))
+		}
+	}
+
+	_, err := New(WithHostPaths(&HostPathsConfig{Allow: []string{"data"}}))
+	c.Assert(err, check.ErrorMatches, "host_paths allow: data must be an absolute path without wildcards")
+	_, err = New(WithHostPaths(&HostPathsConfig{Deny: []string{"/data/*"}}))
+	c.Assert(err, check.ErrorMatches, "host_paths deny: /data/\\* must be an absolute path without wildcards")
+}
+
+func (s *HostPathsSuite) TestSymlinkEscape(c *check.C) {
+	base := c.MkDir()
+	volume := filepath.Join(base, "data", "c1")
+	c.Assert(os.MkdirAll(filepath.Join(volume, "app"), 0755), check.IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(base, "etc"), 0755), check.IsNil)
+	// links a container can create in its volume
+	c.Assert(os.Symlink("../../../../../../../etc", filepath.Join(volume, "up")), check.IsNil)
+	c.Assert(os.Symlink("/etc", filepath.Join(volume, "abs")), check.IsNil)
+	c.Assert(os.Symlink("../../etc", filepath.Join(volume, "host")), check.IsNil)
+	c.Assert(os.Symlink("app", filepath.Join(volume, "current")), check.IsNil)
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithBaseDir(base), WithHostPaths(&HostPathsConfig{
+		Allow: []string{"/data"},
+	}))
+	mounts := map[string]types.MountPoint{"/logs": {Source: "/data/c1", Destination: "/logs"}}
+	for path, expected := range map[string]string{
+		"/logs/app/a.log":     "",
+		"/logs/current/a.log": "",
+		"/logs/missing/a.log": "",
+		"/logs/up/shadow":     "host path /data/c1/up/shadow resolves to /etc/shadow, out of .*",
+		"/logs/abs/*":         "host path /data/c1/abs resolves to /etc, out of .*",
+		"/logs/host/shadow":   "host path /etc/shadow is not in an allowed dir",
+		// links matched by wildcards
+		"/logs/*/shadow": "host path /data/c1/abs resolves to /etc, out of .*",
+		"/logs/c*/a.log": "",
+	} {
+		hostPath, err := p.hostPatternOf(path, mounts, "", nil)
+		c.Assert(err, check.IsNil)
+		_, err = p.checkHostPath(hostPath)
+		if expected == "" {
+			c.Assert(err, check.IsNil, check.Commentf(path))
+		} else {
+			c.Assert(err, check.ErrorMatches, expected, check.Commentf(path))
+		}
+	}
+
+	_, err := p.getLogConfigs("/path/to/json.log", []types.MountPoint{mounts["/logs"]}, "", map[string]string{
+		"aliyun.logs.app": "/logs/host/shadow",
+	})
+	c.Assert(err, check.ErrorMatches, "in log app: /logs/host/shadow rejected: host path /etc/shadow is not in an allowed dir")
+}
+
+func (s *HostPathsSuite) TestRecheck(c *check.C) {
+	base := c.MkDir()
+	volume := filepath.Join(base, "data", "c1")
+	c.Assert(os.MkdirAll(filepath.Join(volume, "app"), 0755), check.IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(base, "data", "real"), 0755), check.IsNil)
+	c.Assert(os.Symlink("../real", filepath.Join(volume, "current")), check.IsNil)
+
+	containerJSON := fakeContainer("c1", map[string]string{
+		"aliyun.logs.app":     "/logs/*/a.log",
+		"aliyun.logs.current": "/logs/current/a.log",
+	}, types.MountPoint{Source: "/data/c1", Destination: "/logs"})
+	p, piloter := newTestPilot(c, newFakeDocker(containerJSON), WithBaseDir(base), WithHostPaths(&HostPathsConfig{
+		Allow: []string{"/data"},
+	}))
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	// the real path is collected, not the link
+	c.Assert(p.watched["c1"], check.DeepEquals, []string{"/data/c1/*/a.log", "/data/real/a.log"})
+
+	p.recheckHostPaths()
+	c.Assert(p.watched["c1"], check.HasLen, 2)
+
+	// links created once the logs are collected
+	c.Assert(os.Symlink("/etc", filepath.Join(volume, "evil")), check.IsNil)
+	c.Assert(os.Remove(filepath.Join(volume, "current")), check.IsNil)
+	c.Assert(os.Symlink("app", filepath.Join(volume, "current")), check.IsNil)
+	p.recheckHostPaths()
+	b, err := ioutil.ReadFile(piloter.ConfPathOf("c1"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(b), check.Equals, "current "+filepath.Join(volume, "app", "a.log")+"\n")
+	c.Assert(p.watched["c1"], check.DeepEquals, []string{"/data/c1/app/a.log"})
+
+	// a destroyed container isn't planned again
+	p.delContainer("c1")
+	c.Assert(p.watched, check.HasLen, 0)
+	c.Assert(os.Remove(piloter.ConfPathOf("c1")), check.IsNil)
+	c.Assert(os.Remove(filepath.Join(volume, "current")), check.IsNil)
+	c.Assert(os.Symlink("../real", filepath.Join(volume, "current")), check.IsNil)
+	p.recheckHostPaths()
+	_, err = os.Stat(piloter.ConfPathOf("c1"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *HostPathsSuite) TestConfig(c *check.C) {
+	config := newTestConfig(c, `
+host_paths:
+  allow: [/var/lib/kubelet/pods, /var/lib/docker/containers]
+  deny: [/var/lib/kubelet/pods/secret]
+`)
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(config))
+	c.Assert(p.hostPaths.allow, check.DeepEquals, []string{"/var/lib/kubelet/pods", "/var/lib/docker/containers"})
+	c.Assert(p.hostPaths.deny, check.DeepEquals, []string{"/var/lib/kubelet/pods/secret"})
+}
@@ -0,0 +1,185 @@
+package pilot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/net/context"
+)
+
+// offsetReader is implemented by piloters which can tell how far the agent
+// has read the files of a log config.
+type offsetReader interface {
+	Offsets(container string, config *LogConfig) (map[string]int64, error)
+}
+
+// Inspect explains how pilot sees a live container: matched labels, mount
+// resolution, metadata, the rendered config and read progress of every file.
+func (p *Pilot) Inspect(w io.Writer, containerID string) error {
+	containerJSON, err := p.client().ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return err
+	}
+
+	plan, planErr := p.planContainer(&containerJSON)
+
+	fmt.Fprintf(w, "Container: %s (%s)\n", plan.name, plan.id)
+	fmt.Fprintf(w, "Pilot: %s, base %s, prefixes %s\n", p.piloter.Name(), p.base, strings.Join(p.logPrefix, ","))
+	fmt.Fprintf(w, "Json log: %s\n", containerJSON.LogPath)
+
+	fmt.Fprintln(w, "\nLabels:")
+	if len(plan.matches) == 0 {
+		fmt.Fprintln(w, "  no label or env matches any prefix")
+	}
+	for _, m := range plan.matches {
+		from := "label"
+		if m.Env != "" {
+			from = "env " + m.Env
+		}
+		if m.Default {
+			from = "default stdout collection"
+		}
+		fmt.Fprintf(w, "  %s = %s (prefix %q, from %s)\n", m.Key, m.Value, m.Prefix, from)
+	}
+
+	fmt.Fprintln(w, "\nMounts:")
+	var destinations []string
+	for dest := range plan.mounts {
+		destinations = append(destinations, dest)
+	}
+	sort.Strings(destinations)
+	for _, dest := range destinations {
+		point := plan.mounts[dest]
+		fmt.Fprintf(w, "  %s -> %s (%s)\n", dest, point.Source, point.Type)
+	}
+	if plan.rootfs != "" {
+		fmt.Fprintf(w, "  writable layer -> %s\n", plan.rootfs)
+	}
+
+	fmt.Fprintln(w, "\nPaths:")
+	table := p.hostMounts()
+	for _, m := range plan.matches {
+		name := strings.TrimPrefix(m.Key, fmt.Sprintf(LABEL_SERVICE_LOGS_TEMPL, m.Prefix))
+		if name == "" || strings.Contains(name, ".") {
+			continue
+		}
+		paths, err := parsePaths(m.Value)
+		if err != nil {
+			fmt.Fprintf(w, "  %s: %v\n", name, err)
+		}
+		for _, path := range paths {
+			fmt.Fprintf(w, "  %s: %s\n", name, p.explainPath(path, containerJSON.LogPath, plan.mounts, plan.rootfs, table))
+		}
+	}
+
+	fmt.Fprintln(w, "\nMetadata:")
+	printMap(w, plan.metadata)
+
+	if len(plan.decisions) > 0 {
+		fmt.Fprintln(w, "\nTenant policy:")
+		for _, decision := range plan.decisions {
+			fmt.Fprintf(w, "  %s\n", decision)
+		}
+	}
+
+	if len(plan.logErrors) > 0 {
+		fmt.Fprintln(w, "\nInvalid logs:")
+		for _, logErr := range plan.logErrors {
+			fmt.Fprintf(w, "  %s: %v\n", logErr.log, logErr)
+		}
+	}
+
+	if planErr != nil {
+		fmt.Fprintf(w, "\nError: %v\n", planErr)
+		return nil
+	}
+	if plan.skipped != "" {
+		fmt.Fprintf(w, "\nContainer is skipped by the selector: %s\n", plan.skipped)
+		return nil
+	}
+	if len(plan.logConfigs) == 0 {
+		fmt.Fprintln(w, "\nNo log config, container is skipped.")
+		return nil
+	}
+
+	if len(plan.symlinks) > 0 {
+		fmt.Fprintln(w, "\nSymlinks:")
+		printMap(w, plan.symlinks)
+	}
+
+	fmt.Fprintf(w, "\nRendered config (%s):\n%s\n", p.piloter.ConfPathOf(plan.id), plan.config)
+
+	fmt.Fprintln(w, "\nFiles:")
+	reader, _ := p.piloter.(offsetReader)
+	for _, config := range plan.logConfigs {
+		var offsets map[string]int64
+		if reader != nil {
+			if offsets, err = reader.Offsets(plan.id, config); err != nil {
+				fmt.Fprintf(w, "  %s: can't read offsets: %v\n", config.Name, err)
+			}
+		}
+
+		files := globFiles(config.Paths)
+		if len(files) == 0 {
+			fmt.Fprintf(w, "  %s: no file matches %s\n", config.Name, strings.Join(config.Paths, ", "))
+		}
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				fmt.Fprintf(w, "  %s: %s: %v\n", config.Name, file, err)
+				continue
+			}
+			if offset, ok := offsets[file]; ok {
+				fmt.Fprintf(w, "  %s: %s size %d offset %d (%d behind)\n",
+					config.Name, file, info.Size(), offset, info.Size()-offset)
+			} else {
+				fmt.Fprintf(w, "  %s: %s size %d offset unknown\n", config.Name, file, info.Size())
+			}
+		}
+	}
+	return nil
+}
+
+// explainPath describes how a declared container path resolves to a host path.
+func (p *Pilot) explainPath(path string, jsonLogPath string, mounts map[string]types.MountPoint, rootfs string, table mountTable) string {
+	if path == STREAM_STDOUT || path == STREAM_STDERR {
+		return fmt.Sprintf("%s -> %s", path, filepath.Join(p.base, jsonLogPath))
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Sprintf("%s is not an absolute path", path)
+	}
+	prefix, _, err := splitPattern(path)
+	if err != nil {
+		return err.Error()
+	}
+	hostPath, err := p.hostPatternOf(path, mounts, rootfs, table)
+	if err != nil {
+		return fmt.Sprintf("%s is not under any mount", path)
+	}
+	explained := fmt.Sprintf("%s -> writable layer %s -> %s", path, rootfs, filepath.Join(p.base, hostPath))
+	if point, ok := p.mountOf(prefix, mounts); ok {
+		explained = fmt.Sprintf("%s -> mount %s (source %s) -> %s", path, point.Destination, point.Source, filepath.Join(p.base, hostPath))
+	}
+	if real, err := p.checkHostPath(hostPath); err != nil {
+		explained += fmt.Sprintf(" (rejected: %v)", err)
+	} else if real != hostPath {
+		explained += fmt.Sprintf(" -> %s", filepath.Join(p.base, real))
+	}
+	return explained
+}
+
+func printMap(w io.Writer, m map[string]string) {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s = %s\n", k, m[k])
+	}
+}
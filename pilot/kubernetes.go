@@ -0,0 +1,352 @@
+package pilot
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const KUBE_SOURCE_APISERVER = "apiserver"
+const KUBE_SOURCE_KUBELET = "kubelet"
+
+const KUBE_TOKEN_FILE = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+const KUBE_CA_FILE = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+// KubernetesConfig enables metadata from the kubelet or the api server.
+type KubernetesConfig struct {
+	// Source is apiserver (default) or kubelet.
+	Source string `config:"source"`
+	// URL defaults to the in-cluster api server, or the kubelet of the node.
+	URL       string        `config:"url"`
+	TokenFile string        `config:"token_file"`
+	CAFile    string        `config:"ca_file"`
+	Insecure  bool          `config:"insecure"`
+	Timeout   time.Duration `config:"timeout"`
+	CacheTTL  time.Duration `config:"cache_ttl"`
+
+	// Labels, Annotations and NodeLabels name what is added to the metadata,
+	// * adds everything.
+	Labels      []string `config:"labels"`
+	Annotations []string `config:"annotations"`
+	NodeLabels  []string `config:"node_labels"`
+}
+
+type kubeOwnerReference struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Controller *bool  `json:"controller"`
+}
+
+type kubeObjectMeta struct {
+	Name            string               `json:"name"`
+	Namespace       string               `json:"namespace"`
+	UID             string               `json:"uid,omitempty"`
+	Labels          map[string]string    `json:"labels"`
+	Annotations     map[string]string    `json:"annotations"`
+	OwnerReferences []kubeOwnerReference `json:"ownerReferences"`
+}
+
+type kubePod struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+}
+
+type kubeNode struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+}
+
+type kubePodList struct {
+	Items []kubePod `json:"items"`
+}
+
+type kubeCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// kubeClient reads pods and nodes over the kubernetes REST api and caches them.
+type kubeClient struct {
+	config *KubernetesConfig
+	url    string
+	token  string
+	client *http.Client
+	mutex  sync.Mutex
+	cache  map[string]kubeCacheEntry
+}
+
+func newKubeClient(config *KubernetesConfig, nodeName string) (*kubeClient, error) {
+	// defaults are set on a copy, the caller's config may be shared
+	copied := *config
+	config = &copied
+	if config.Source == "" {
+		config.Source = KUBE_SOURCE_APISERVER
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = time.Minute
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	url := config.URL
+	switch config.Source {
+	case KUBE_SOURCE_APISERVER:
+		if url == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			url = fmt.Sprintf("https://%s:%s", os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+		}
+	case KUBE_SOURCE_KUBELET:
+		if url == "" && nodeName != "" {
+			url = fmt.Sprintf("https://%s:10250", nodeName)
+		}
+		if len(config.NodeLabels) > 0 {
+			return nil, fmt.Errorf("kubernetes node_labels requires source %s", KUBE_SOURCE_APISERVER)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported kubernetes source: %s", config.Source)
+	}
+	if url == "" {
+		return nil, fmt.Errorf("kubernetes url is required outside of a cluster")
+	}
+
+	tokenFile := config.TokenFile
+	if tokenFile == "" {
+		tokenFile = KUBE_TOKEN_FILE
+	}
+	var token string
+	if b, err := ioutil.ReadFile(tokenFile); err == nil {
+		token = strings.TrimSpace(string(b))
+	} else if config.TokenFile != "" {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure}
+	caFile := config.CAFile
+	if caFile == "" {
+		caFile = KUBE_CA_FILE
+	}
+	if b, err := ioutil.ReadFile(caFile); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	} else if config.CAFile != "" {
+		return nil, err
+	}
+
+	return &kubeClient{
+		config: config,
+		url:    strings.TrimRight(url, "/"),
+		token:  token,
+		client: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		cache: make(map[string]kubeCacheEntry),
+	}, nil
+}
+
+func (k *kubeClient) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", k.url+path, nil)
+	if err != nil {
+		return err
+	}
+	return k.do(req, v)
+}
+
+// send writes body in json with method, like POST or PATCH.
+func (k *kubeClient) send(method, path, contentType string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, k.url+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return k.do(req, nil)
+}
+
+func (k *kubeClient) do(req *http.Request, v interface{}) error {
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// cached returns the value of key, loading it when missing or expired.
+func (k *kubeClient) cached(key string, load func() (interface{}, error)) (interface{}, error) {
+	k.mutex.Lock()
+	entry, ok := k.cache[key]
+	k.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	k.mutex.Lock()
+	// pods come and go, expired ones are evicted rather than kept forever
+	for cachedKey, cachedEntry := range k.cache {
+		if !now.Before(cachedEntry.expires) {
+			delete(k.cache, cachedKey)
+		}
+	}
+	k.cache[key] = kubeCacheEntry{value: value, expires: now.Add(k.config.CacheTTL)}
+	k.mutex.Unlock()
+	return value, nil
+}
+
+func (k *kubeClient) pod(namespace, name string) (*kubePod, error) {
+	if k.config.Source == KUBE_SOURCE_KUBELET {
+		value, err := k.cached("kubelet/pods", func() (interface{}, error) {
+			var pods kubePodList
+			err := k.get("/pods", &pods)
+			return &pods, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i, pod := range value.(*kubePodList).Items {
+			if pod.Metadata.Namespace == namespace && pod.Metadata.Name == name {
+				return &value.(*kubePodList).Items[i], nil
+			}
+		}
+		return nil, fmt.Errorf("pod %s/%s not found on kubelet", namespace, name)
+	}
+
+	value, err := k.cached("pods/"+namespace+"/"+name, func() (interface{}, error) {
+		var pod kubePod
+		err := k.get(fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, name), &pod)
+		return &pod, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*kubePod), nil
+}
+
+func (k *kubeClient) node(name string) (*kubeNode, error) {
+	value, err := k.cached("nodes/"+name, func() (interface{}, error) {
+		var node kubeNode
+		err := k.get("/api/v1/nodes/"+name, &node)
+		return &node, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*kubeNode), nil
+}
+
+// workload returns the kind and name of the controller owning the pod,
+// pods of a deployment are reported as the deployment.
+func (pod *kubePod) workload() (string, string) {
+	for _, owner := range pod.Metadata.OwnerReferences {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+		if hash := pod.Metadata.Labels["pod-template-hash"]; owner.Kind == "ReplicaSet" && hash != "" &&
+			strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+		return owner.Kind, owner.Name
+	}
+	return "", ""
+}
+
+var kubeKeyReplacer = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// kubeKey turns a label or annotation name into a field name suffix.
+func kubeKey(name string) string {
+	return kubeKeyReplacer.ReplaceAllString(name, "_")
+}
+
+func copySelected(metadata map[string]string, prefix string, values map[string]string, selected []string) {
+	for _, name := range selected {
+		if name == "*" {
+			for k, v := range values {
+				putIfNotEmpty(metadata, prefix+kubeKey(k), v)
+			}
+			continue
+		}
+		putIfNotEmpty(metadata, prefix+kubeKey(name), values[name])
+	}
+}
+
+// kubernetesEnricher adds pod labels, annotations, owner workload and node labels.
+type kubernetesEnricher struct {
+	kube     *kubeClient
+	nodeName string
+}
+
+func (e *kubernetesEnricher) Enrich(metadata map[string]string, containerJSON *types.ContainerJSON) error {
+	labels := containerJSON.Config.Labels
+	namespace, name := labels[LABEL_K8S_POD_NAMESPACE], labels[LABEL_POD]
+	if namespace == "" || name == "" {
+		return nil
+	}
+
+	config := e.kube.config
+	pod, err := e.kube.pod(namespace, name)
+	if err != nil {
+		return err
+	}
+	copySelected(metadata, "k8s_pod_label_", pod.Metadata.Labels, config.Labels)
+	copySelected(metadata, "k8s_pod_annotation_", pod.Metadata.Annotations, config.Annotations)
+	kind, workload := pod.workload()
+	putIfNotEmpty(metadata, "k8s_workload_kind", kind)
+	putIfNotEmpty(metadata, "k8s_workload_name", workload)
+
+	if len(config.NodeLabels) == 0 {
+		return nil
+	}
+	nodeName := pod.Spec.NodeName
+	if nodeName == "" {
+		nodeName = e.nodeName
+	}
+	node, err := e.kube.node(nodeName)
+	if err != nil {
+		return err
+	}
+	copySelected(metadata, "k8s_node_label_", node.Metadata.Labels, config.NodeLabels)
+	return nil
+}
+
+// WithKubernetes adds kubernetes metadata to containers of pods, before other enrichers.
+func WithKubernetes(config *KubernetesConfig) Option {
+	return func(p *Pilot) error {
+		p.kubeConfig = config
+		return nil
+	}
+}
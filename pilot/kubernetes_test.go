@@ -0,0 +1,215 @@
+package pilot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+// fakeKube is a kubernetes api server and kubelet stand-in serving fixed objects.
+type fakeKube struct {
+	mutex    sync.Mutex
+	requests map[string]int
+	pods     []kubePod
+	nodes    map[string]kubeNode
+	server   *httptest.Server
+	// requests received with a body, by method and path
+	bodies map[string][]string
+}
+
+func newFakeKube(pods []kubePod, nodes ...kubeNode) *fakeKube {
+	k := &fakeKube{
+		requests: make(map[string]int),
+		pods:     pods,
+		nodes:    make(map[string]kubeNode),
+		bodies:   make(map[string][]string),
+	}
+	for _, node := range nodes {
+		k.nodes[node.Metadata.Name] = node
+	}
+	k.server = httptest.NewServer(http.HandlerFunc(k.serve))
+	return k
+}
+
+func (k *fakeKube) count(path string) int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.requests[path]
+}
+
+func (k *fakeKube) serve(w http.ResponseWriter, r *http.Request) {
+	k.mutex.Lock()
+	k.requests[r.URL.Path]++
+	if r.Body != nil {
+		if b, _ := ioutil.ReadAll(r.Body); len(b) > 0 {
+			key := r.Method + " " + r.URL.Path
+			k.bodies[key] = append(k.bodies[key], string(b))
+		}
+	}
+	k.mutex.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+		return
+	}
+
+	if r.URL.Path == "/pods" {
+		json.NewEncoder(w).Encode(kubePodList{Items: k.pods})
+		return
+	}
+	for _, pod := range k.pods {
+		if r.URL.Path == "/api/v1/namespaces/"+pod.Metadata.Namespace+"/pods/"+pod.Metadata.Name {
+			json.NewEncoder(w).Encode(pod)
+			return
+		}
+	}
+	for name, node := range k.nodes {
+		if r.URL.Path == "/api/v1/nodes/"+name {
+			json.NewEncoder(w).Encode(node)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func newTestPod() kubePod {
+	controller := true
+	pod := kubePod{Metadata: kubeObjectMeta{
+		Name:        "web-5d8f7c9b4-x2x7k",
+		Namespace:   "shop",
+		Labels:      map[string]string{"app": "web", "version": "v2", "pod-template-hash": "5d8f7c9b4"},
+		Annotations: map[string]string{"team.example.com/owner": "checkout", "ignored": "x"},
+		OwnerReferences: []kubeOwnerReference{
+			{Kind: "ReplicaSet", Name: "web-5d8f7c9b4", Controller: &controller},
+		},
+	}}
+	pod.Spec.NodeName = "node-1"
+	return pod
+}
+
+func writeToken(c *check.C) string {
+	tokenFile := filepath.Join(c.MkDir(), "token")
+	c.Assert(ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600), check.IsNil)
+	return tokenFile
+}
+
+type KubernetesSuite struct{}
+
+var _ = check.Suite(&KubernetesSuite{})
+
+func (s *KubernetesSuite) TestApiServer(c *check.C) {
+	kube := newFakeKube([]kubePod{newTestPod()},
+		kubeNode{Metadata: kubeObjectMeta{Name: "node-1", Labels: map[string]string{"topology.kubernetes.io/zone": "z1"}}})
+	defer kube.server.Close()
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithKubernetes(&KubernetesConfig{
+		URL:         kube.server.URL,
+		TokenFile:   writeToken(c),
+		Labels:      []string{"app", "version", "missing"},
+		Annotations: []string{"team.example.com/owner"},
+		NodeLabels:  []string{"*"},
+	}))
+
+	containerJSON := fakeContainer("c1", map[string]string{
+		LABEL_POD:               "web-5d8f7c9b4-x2x7k",
+		LABEL_K8S_POD_NAMESPACE: "shop",
+	})
+	metadata := p.container(&containerJSON)
+	c.Assert(metadata["k8s_pod_label_app"], check.Equals, "web")
+	c.Assert(metadata["k8s_pod_label_version"], check.Equals, "v2")
+	c.Assert(metadata["k8s_pod_annotation_team_example_com_owner"], check.Equals, "checkout")
+	c.Assert(metadata["k8s_workload_kind"], check.Equals, "Deployment")
+	c.Assert(metadata["k8s_workload_name"], check.Equals, "web")
+	c.Assert(metadata["k8s_node_label_topology_kubernetes_io_zone"], check.Equals, "z1")
+	_, ok := metadata["k8s_pod_annotation_ignored"]
+	c.Assert(ok, check.Equals, false)
+
+	// served from the cache
+	p.container(&containerJSON)
+	c.Assert(kube.count("/api/v1/namespaces/shop/pods/web-5d8f7c9b4-x2x7k"), check.Equals, 1)
+	c.Assert(kube.count("/api/v1/nodes/node-1"), check.Equals, 1)
+
+	c.Assert(fieldSchemas[SCHEMA_ECS].metadata(metadata)["kubernetes.labels.app"], check.Equals, "web")
+
+	// not a pod
+	other := fakeContainer("c2", map[string]string{})
+	c.Assert(p.container(&other)["k8s_workload_name"], check.Equals, "")
+}
+
+func (s *KubernetesSuite) TestKubelet(c *check.C) {
+	kube := newFakeKube([]kubePod{newTestPod()})
+	defer kube.server.Close()
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithKubernetes(&KubernetesConfig{
+		Source:    KUBE_SOURCE_KUBELET,
+		URL:       kube.server.URL,
+		TokenFile: writeToken(c),
+		Labels:    []string{"*"},
+	}))
+	containerJSON := fakeContainer("c1", map[string]string{
+		LABEL_POD:               "web-5d8f7c9b4-x2x7k",
+		LABEL_K8S_POD_NAMESPACE: "shop",
+	})
+	metadata := p.container(&containerJSON)
+	c.Assert(metadata["k8s_pod_label_pod_template_hash"], check.Equals, "5d8f7c9b4")
+	c.Assert(kube.count("/pods"), check.Equals, 1)
+
+	_, err := New(WithTemplate(""), WithDockerClient(newFakeDocker()), WithKubernetes(&KubernetesConfig{
+		Source:     KUBE_SOURCE_KUBELET,
+		URL:        kube.server.URL,
+		NodeLabels: []string{"zone"},
+	}))
+	c.Assert(err, check.ErrorMatches, "kubernetes node_labels requires source apiserver")
+}
+
+func (s *KubernetesSuite) TestCache(c *check.C) {
+	kube := newFakeKube([]kubePod{newTestPod()})
+	defer kube.server.Close()
+
+	config := &KubernetesConfig{URL: kube.server.URL, TokenFile: writeToken(c), CacheTTL: time.Millisecond}
+	client, err := newKubeClient(config, "")
+	c.Assert(err, check.IsNil)
+	// defaults are not written back
+	c.Assert(config.Source, check.Equals, "")
+	c.Assert(config.Timeout, check.Equals, time.Duration(0))
+	c.Assert(client.config.Source, check.Equals, KUBE_SOURCE_APISERVER)
+
+	_, err = client.pod("shop", "web-5d8f7c9b4-x2x7k")
+	c.Assert(err, check.IsNil)
+	c.Assert(client.cache, check.HasLen, 1)
+	time.Sleep(5 * time.Millisecond)
+	// expired entries are evicted on the next load
+	client.cached("other", func() (interface{}, error) { return nil, nil })
+	c.Assert(client.cache, check.HasLen, 1)
+	_, ok := client.cache["other"]
+	c.Assert(ok, check.Equals, true)
+}
+
+func (s *KubernetesSuite) TestUnauthorized(c *check.C) {
+	kube := newFakeKube([]kubePod{newTestPod()})
+	defer kube.server.Close()
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithKubernetes(&KubernetesConfig{
+		URL:    kube.server.URL,
+		Labels: []string{"app"},
+	}))
+	containerJSON := fakeContainer("c1", map[string]string{
+		LABEL_POD:               "web-5d8f7c9b4-x2x7k",
+		LABEL_K8S_POD_NAMESPACE: "shop",
+	})
+	metadata := p.container(&containerJSON)
+	c.Assert(metadata["k8s_pod_label_app"], check.Equals, "")
+	c.Assert(metadata["k8s_pod"], check.Equals, "web-5d8f7c9b4-x2x7k")
+}
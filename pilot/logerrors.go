@@ -0,0 +1,74 @@
+package pilot
+
+import (
+	"strings"
+)
+
+// logError is why a log of a container is invalid.
+type logError struct {
+	log string
+	err error
+}
+
+func (e *logError) Error() string {
+	return e.err.Error()
+}
+
+// logErrors are the errors of the invalid logs of a container, whose valid
+// logs are still collected unless pilot is strict.
+type logErrors []*logError
+
+func (e logErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add records the error of a log, the first one of each log only.
+func (e logErrors) add(log string, err error) logErrors {
+	for _, logErr := range e {
+		if logErr.log == log {
+			return e
+		}
+	}
+	return append(e, &logError{log: log, err: err})
+}
+
+// asError returns nil without errors, as a nil logErrors is a non nil error.
+func (e logErrors) asError() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// dropInvalid records the errors of the invalid logs of a plan and returns
+// the valid logs. Other errors, and any error when pilot is strict, fail
+// the whole container.
+func (p *Pilot) dropInvalid(plan *containerPlan, configs []*LogConfig, err error) ([]*LogConfig, error) {
+	if err == nil {
+		return configs, nil
+	}
+	errs, ok := err.(logErrors)
+	if !ok {
+		return nil, err
+	}
+	plan.logErrors = append(plan.logErrors, errs...)
+	if p.strict {
+		return nil, err
+	}
+
+	invalid := make(map[string]bool)
+	for _, logErr := range errs {
+		invalid[logErr.log] = true
+	}
+	var valid []*LogConfig
+	for _, config := range configs {
+		if !invalid[config.Name] {
+			valid = append(valid, config)
+		}
+	}
+	return valid, nil
+}
@@ -0,0 +1,63 @@
+package pilot
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"gopkg.in/check.v1"
+)
+
+type LogErrorsSuite struct{}
+
+var _ = check.Suite(&LogErrorsSuite{})
+
+// partialLabels declare a valid log and two invalid ones.
+var partialLabels = map[string]string{
+	"aliyun.logs.app":        "stdout",
+	"aliyun.logs.bad":        "stdout",
+	"aliyun.logs.bad.stream": "both",
+	"aliyun.logs.ghost.tags": "a=b",
+}
+
+func (s *LogErrorsSuite) TestPartial(c *check.C) {
+	p, piloter := newTestPilot(c, newFakeDocker())
+	containerJSON := fakeContainer("c1", partialLabels)
+
+	configs, err := p.getLogConfigs(containerJSON.LogPath, nil, "", partialLabels)
+	c.Assert(configs, check.HasLen, 1)
+	c.Assert(configs[0].Name, check.Equals, "app")
+	c.Assert(err, check.ErrorMatches, "in log bad: stream must be all, stdout or stderr, not both; "+
+		"in log ghost: aliyun.logs.ghost.tags is set but aliyun.logs.ghost is not")
+
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	b, err := ioutil.ReadFile(piloter.ConfPathOf("c1"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(b), check.Equals, "app /host/var/lib/docker/containers/c1/c1-json.log\n")
+	c.Assert(p.invalidLogs.get(), check.Equals, uint64(2))
+}
+
+func (s *LogErrorsSuite) TestStrict(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, "strict: true")))
+	containerJSON := fakeContainer("c1", partialLabels)
+
+	plan, err := p.planContainer(&containerJSON)
+	c.Assert(err, check.ErrorMatches, "in log bad: .*; in log ghost: .*")
+	c.Assert(plan.logErrors, check.HasLen, 2)
+
+	c.Assert(p.newContainer(&containerJSON), check.NotNil)
+	c.Assert(p.exists("c1"), check.Equals, false)
+	c.Assert(p.invalidLogs.get(), check.Equals, uint64(2))
+}
+
+func (s *LogErrorsSuite) TestReport(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(fakeContainer("c1", partialLabels)))
+
+	var out bytes.Buffer
+	c.Assert(p.DryRun(&out, ""), check.ErrorMatches, `dry run failed for 2 item\(s\)`)
+	c.Assert(out.String(), check.Matches, `(?s).*\[invalid\] c1 \(c1\): log bad: in log bad: stream must be .*\n`+
+		`\[invalid\] c1 \(c1\): log ghost: .*\n\[ok\] c1 \(c1\): 1 log config\(s\)\n.*`)
+
+	out.Reset()
+	c.Assert(p.Inspect(&out, "c1"), check.IsNil)
+	c.Assert(out.String(), check.Matches, `(?s).*\nInvalid logs:\n  bad: in log bad: .*\n  ghost: in log ghost: .*\n\nRendered config.*`)
+}
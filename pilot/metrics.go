@@ -0,0 +1,94 @@
+package pilot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// counterVec is a counter with labels, exposed in the prometheus text format.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	mutex  sync.Mutex
+	values map[string]uint64
+}
+
+// metrics are the counters of a pilot.
+type metrics struct {
+	counters []*counterVec
+}
+
+func (m *metrics) counter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]uint64)}
+	m.counters = append(m.counters, c)
+	return c
+}
+
+// inc adds one to the counter of the label values, given in order.
+func (c *counterVec) inc(values ...string) {
+	c.add(1, values...)
+}
+
+func (c *counterVec) add(n uint64, values ...string) {
+	key := c.key(values)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[key] += n
+}
+
+// get returns the counter of the label values, given in order.
+func (c *counterVec) get(values ...string) uint64 {
+	key := c.key(values)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.values[key]
+}
+
+// key renders label values as the labels of a sample.
+func (c *counterVec) key(values []string) string {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("%s has %d labels, not %d", c.name, len(c.labels), len(values)))
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, c.labels[i], labelValueEscaper.Replace(value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// labelValueEscaper escapes label values as the text format of prometheus
+// does, other characters are left as is.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (c *counterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	var keys []string
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" {
+			fmt.Fprintf(w, "%s %d\n", c.name, c.values[key])
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s} %d\n", c.name, key, c.values[key])
+	}
+}
+
+// MetricsHandler serves the metrics of the pilot in the prometheus text format.
+func (p *Pilot) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, c := range p.metrics.counters {
+			c.write(w)
+		}
+	})
+}
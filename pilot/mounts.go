@@ -0,0 +1,271 @@
+package pilot
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// MOUNTINFO is the mount table of the host, relative to the base dir.
+const MOUNTINFO = "/proc/1/mountinfo"
+
+// maxSymlinks bounds symlink resolution, as the kernel does.
+const maxSymlinks = 40
+
+/**
+A container path is mapped to the host in three steps:
+1. the most specific mount containing the path gives the host source, or else
+   the path is in the writable layer of the container;
+2. symlinks of the host source are resolved inside the base dir, since their
+   targets are host paths, not paths of pilot;
+3. bind mounts made on the host after pilot started, such as the kubernetes
+   volume-subpaths, don't propagate to the base dir: the host mount table
+   turns them into the path they bind.
+*/
+
+// mountsOf indexes mounts by their destination.
+func mountsOf(mounts []types.MountPoint) map[string]types.MountPoint {
+	ret := make(map[string]types.MountPoint, len(mounts))
+	for _, mount := range mounts {
+		mount.Destination = filepath.Clean(mount.Destination)
+		ret[mount.Destination] = mount
+	}
+	return ret
+}
+
+// mountOf finds the nearest mount containing path, walking up from path itself.
+func (p *Pilot) mountOf(path string, mounts map[string]types.MountPoint) (types.MountPoint, bool) {
+	path = filepath.Clean(path)
+	for {
+		if point, ok := mounts[path]; ok {
+			return point, true
+		}
+		path = filepath.Dir(path)
+		if path == "/" || path == "." {
+			break
+		}
+	}
+	return types.MountPoint{}, false
+}
+
+// hostPathOf maps a container path to the host, through the mounts or else
+// the writable layer of the container in rootfs, then the host mount table
+// if any. The host path is relative to the base dir.
+func (p *Pilot) hostPathOf(path string, mounts map[string]types.MountPoint, rootfs string, table mountTable) (string, bool) {
+	path = filepath.Clean(path)
+	var source, rel string
+	if point, ok := p.mountOf(path, mounts); ok {
+		source = point.Source
+		rel = strings.TrimPrefix(strings.TrimPrefix(path, point.Destination), "/")
+	} else if rootfs != "" {
+		source = rootfs
+		rel = strings.TrimPrefix(path, "/")
+	} else {
+		return "", false
+	}
+
+	hostPath := filepath.Join(p.resolveSymlinks(source), rel)
+	if table != nil {
+		hostPath = table.resolve(hostPath)
+	}
+	return hostPath, true
+}
+
+// hostPatternOf maps a container path pattern to the host, through its
+// literal dir prefix.
+func (p *Pilot) hostPatternOf(pattern string, mounts map[string]types.MountPoint, rootfs string, table mountTable) (string, error) {
+	prefix, rest, err := splitPattern(pattern)
+	if err != nil {
+		return "", err
+	}
+	if rest == "" {
+		prefix = pattern
+	}
+	hostPath, ok := p.hostPathOf(prefix, mounts, rootfs, table)
+	if !ok {
+		return "", fmt.Errorf("%s is not mount on host, nor in a writable layer pilot can read", pattern)
+	}
+	return filepath.Join(hostPath, rest), nil
+}
+
+// resolveSymlinks resolves the symlinks of a host path, with absolute targets
+// taken from the base dir. The path is returned as is from the first missing
+// component on.
+func (p *Pilot) resolveSymlinks(path string) string {
+	resolved := "/"
+	rest := strings.Split(strings.Trim(filepath.Clean(path), "/"), "/")
+	for links := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+		if name == "" || name == "." {
+			continue
+		}
+		next := filepath.Join(resolved, name)
+		target, err := os.Readlink(filepath.Join(p.base, next))
+		if err != nil {
+			// not a symlink, or missing
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return path
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(resolved, target)
+		}
+		rest = append(strings.Split(strings.Trim(filepath.Clean(target), "/"), "/"), rest...)
+		resolved = "/"
+	}
+	return resolved
+}
+
+// mountInfo is an entry of a mount table, see proc(5).
+type mountInfo struct {
+	device     string
+	root       string
+	mountPoint string
+}
+
+type mountTable []mountInfo
+
+// hostMounts reads the host mount table, nil if it can't be read. It is
+// read once for all the paths of a container.
+func (p *Pilot) hostMounts() mountTable {
+	path := p.mountInfo
+	if path == "" {
+		path = filepath.Join(p.base, MOUNTINFO)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		p.logger.Debugf("read host mount table error: %v", err)
+		return nil
+	}
+	defer f.Close()
+	table, err := parseMountInfo(f)
+	if err != nil {
+		p.logger.Debugf("read host mount table error: %v", err)
+		return nil
+	}
+	return table
+}
+
+func parseMountInfo(r io.Reader) (mountTable, error) {
+	var table mountTable
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("invalid mountinfo line: %s", scanner.Text())
+		}
+		table = append(table, mountInfo{
+			device:     fields[2],
+			root:       unescapeMountInfo(fields[3]),
+			mountPoint: unescapeMountInfo(fields[4]),
+		})
+	}
+	return table, scanner.Err()
+}
+
+// unescapeMountInfo decodes the octal escapes of spaces, tabs, newlines and backslashes.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// under tells whether path is dir or in it, and the path relative to dir.
+func under(path, dir string) (string, bool) {
+	if dir == "/" {
+		return strings.TrimPrefix(path, "/"), true
+	}
+	if path == dir {
+		return "", true
+	}
+	if strings.HasPrefix(path, dir+"/") {
+		return path[len(dir)+1:], true
+	}
+	return "", false
+}
+
+// mountAt returns the mount path is in, the last one mounted on the most
+// specific mount point.
+func (t mountTable) mountAt(path string) (mountInfo, bool) {
+	var ret mountInfo
+	found := false
+	for _, m := range t {
+		if _, ok := under(path, m.mountPoint); ok && (!found || len(m.mountPoint) >= len(ret.mountPoint)) {
+			ret, found = m, true
+		}
+	}
+	return ret, found
+}
+
+// resolve turns a path under a bind mount into the path it binds, when the
+// bound dir is reachable through another mount of the same device.
+func (t mountTable) resolve(path string) string {
+	for i := 0; i < len(t); i++ {
+		m, ok := t.mountAt(path)
+		if !ok || m.root == "/" {
+			return path
+		}
+		rel, _ := under(path, m.mountPoint)
+		devicePath := filepath.Join(m.root, rel)
+
+		// the mount of the device with the longest root containing the path
+		var best mountInfo
+		found := false
+		for _, other := range t {
+			if other.device != m.device || other.mountPoint == m.mountPoint {
+				continue
+			}
+			if _, ok := under(devicePath, other.root); ok && (!found || len(other.root) > len(best.root)) {
+				best, found = other, true
+			}
+		}
+		if !found {
+			return path
+		}
+		rel, _ = under(devicePath, best.root)
+		resolved := filepath.Join(best.mountPoint, rel)
+		if resolved == path {
+			return path
+		}
+		path = resolved
+	}
+	return path
+}
+
+// rootfsOf returns the host dir of the writable layer of a container,
+// "" when the storage driver doesn't expose one.
+func rootfsOf(graphDriver types.GraphDriverData) string {
+	switch graphDriver.Name {
+	case "overlay", "overlay2":
+		// the upper dir is a plain directory, the merged dir is a mount
+		// the host root given to pilot may not propagate
+		if dir := graphDriver.Data["UpperDir"]; dir != "" {
+			return dir
+		}
+		return graphDriver.Data["MergedDir"]
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package pilot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"gopkg.in/check.v1"
+)
+
+type MountsSuite struct{}
+
+var _ = check.Suite(&MountsSuite{})
+
+// readInspect reads the output of docker inspect in testdata.
+func readInspect(c *check.C, name string) types.ContainerJSON {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	c.Assert(err, check.IsNil)
+	var containers []types.ContainerJSON
+	c.Assert(json.Unmarshal(b, &containers), check.IsNil)
+	c.Assert(containers, check.HasLen, 1)
+	return containers[0]
+}
+
+func (s *MountsSuite) TestHostPath(c *check.C) {
+	const emptyDirPod = "/host/var/lib/kubelet/pods/0d4a3d4e-5753-11e8-9c2d-fa163e4a7d1a"
+	const subPathPod = "/host/var/lib/kubelet/pods/6a1e2f3c-6653-11e8-8d3e-fa163e4a7d1a"
+
+	for _, t := range []struct {
+		fixture string
+		path    string
+		hostDir string
+		file    string
+	}{
+		// volumes
+		{"k8s-emptydir.json", "/var/log/nginx/access.log",
+			emptyDirPod + "/volumes/kubernetes.io~empty-dir/logs", "access.log"},
+		{"k8s-emptydir.json", "/var/log/nginx/old/*.log",
+			emptyDirPod + "/volumes/kubernetes.io~empty-dir/logs/old", "*.log"},
+		// writable layer
+		{"k8s-emptydir.json", "/usr/share/nginx/debug.log",
+			"/host/var/lib/docker/overlay2/7c1f0e4b2a4e0f9d6f6a1d1f6b5d8c1f4a2e9b7c3d0e5f1a2b3c4d5e6f7a8b9c/diff/usr/share/nginx", "debug.log"},
+		// subPath of a directory, bound on the host after pilot started
+		{"k8s-subpath.json", "/app/logs/orders.log",
+			subPathPod + "/volumes/kubernetes.io~empty-dir/logs/orders-0", "orders.log"},
+		// subPath of a file
+		{"k8s-subpath.json", "/app/conf/app.yml",
+			subPathPod + "/volumes/kubernetes.io~configmap/config/..2018_06_02_11_05_12.804612342", "app.yml"},
+		// hostPath on another disk
+		{"k8s-subpath.json", "/data/orders/*.log", "/host/mnt/disks/ssd0/orders/orders", "*.log"},
+		// most specific of nested mounts
+		{"k8s-subpath.json", "/data/audit/audit.log",
+			subPathPod + "/volumes/kubernetes.io~empty-dir/audit", "audit.log"},
+		{"k8s-subpath.json", "/tmp/gc.log",
+			"/host/var/lib/docker/overlay2/5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f/diff/tmp", "gc.log"},
+	} {
+		comment := check.Commentf("%s %s", t.fixture, t.path)
+		p, _ := newTestPilot(c, newFakeDocker(), WithBaseDir("/host"))
+		p.mountInfo = "testdata/mountinfo-k8s-node"
+
+		containerJSON := readInspect(c, t.fixture)
+		configs, err := p.getLogConfigs(containerJSON.LogPath, containerJSON.Mounts, rootfsOf(containerJSON.GraphDriver),
+			map[string]string{"aliyun.logs.app": t.path})
+		c.Assert(err, check.IsNil, comment)
+		c.Assert(configs[0].HostDir, check.Equals, t.hostDir, comment)
+		c.Assert(configs[0].File, check.Equals, t.file, comment)
+		c.Assert(configs[0].ContainerDir, check.Equals, filepath.Dir(t.path), comment)
+	}
+}
+
+func (s *MountsSuite) TestMountTable(c *check.C) {
+	b, err := ioutil.ReadFile("testdata/mountinfo-k8s-node")
+	c.Assert(err, check.IsNil)
+	table, err := parseMountInfo(strings.NewReader(string(b)))
+	c.Assert(err, check.IsNil)
+
+	for path, expected := range map[string]string{
+		"/var/log/messages":                   "/var/log/messages",
+		"/var/lib/kubelet/pods":               "/var/lib/kubelet/pods",
+		"/var/log/shared/app.log":             "/mnt/disks/ssd0/shared logs/app.log",
+		"/var/log/shared":                     "/mnt/disks/ssd0/shared logs",
+		"/var/log/sharedother":                "/var/log/sharedother",
+		"/var/lib/docker/overlay2/x/merged/a": "/var/lib/docker/overlay2/x/merged/a",
+	} {
+		c.Assert(table.resolve(path), check.Equals, expected, check.Commentf(path))
+	}
+
+	// captured on a docker host with aufs, as in the mount package of docker
+	b, err = ioutil.ReadFile("testdata/mountinfo-ubuntu-docker")
+	c.Assert(err, check.IsNil)
+	table, err = parseMountInfo(strings.NewReader(string(b)))
+	c.Assert(err, check.IsNil)
+	c.Assert(table, check.HasLen, 130)
+	m, ok := table.mountAt("/var/lib/docker/containers/c1/c1-json.log")
+	c.Assert(ok, check.Equals, true)
+	c.Assert(m.device, check.Equals, "253:0")
+	for _, path := range []string{
+		"/var/lib/docker/volumes/data/_data/app.log",
+		"/var/lib/docker/aufs/mnt/e6ecde9e2c18cd3c75f424c67b6d89685cfee0fc67abf2cb6bdc0867eb998026/var/log/app.log",
+	} {
+		c.Assert(table.resolve(path), check.Equals, path)
+	}
+
+	_, err = parseMountInfo(strings.NewReader("41 0 253:1\n"))
+	c.Assert(err, check.ErrorMatches, "invalid mountinfo line: .*")
+}
+
+func (s *MountsSuite) TestSymlinkSource(c *check.C) {
+	base := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(base, "data/app-logs"), 0755), check.IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(base, "var/log"), 0755), check.IsNil)
+	// absolute targets are host paths
+	c.Assert(os.Symlink("/data/app-logs", filepath.Join(base, "var/log/app")), check.IsNil)
+	c.Assert(os.Symlink("../log/app", filepath.Join(base, "var/log/relative")), check.IsNil)
+	c.Assert(os.Symlink("/var/log/loop", filepath.Join(base, "var/log/loop")), check.IsNil)
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithBaseDir(base))
+	for source, expected := range map[string]string{
+		"/var/log/app":          "/data/app-logs",
+		"/var/log/relative":     "/data/app-logs",
+		"/var/log/app/missing":  "/data/app-logs/missing",
+		"/var/log/missing/link": "/var/log/missing/link",
+		"/var/log/loop":         "/var/log/loop",
+	} {
+		c.Assert(p.resolveSymlinks(source), check.Equals, expected, check.Commentf(source))
+	}
+
+	configs, err := p.getLogConfigs("/path/to/json.log", []types.MountPoint{
+		{Source: "/var/log/app", Destination: "/logs"},
+	}, "", map[string]string{"aliyun.logs.app": "/logs/app.log"})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].HostDir, check.Equals, filepath.Join(base, "data/app-logs"))
+}
@@ -0,0 +1,387 @@
+package pilot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"golang.org/x/net/context"
+)
+
+// EVENT_REASON is the reason of the kubernetes events of invalid logs.
+const EVENT_REASON = "InvalidLogConfig"
+
+// eventMessageMax bounds the message of events, which the api server limits.
+const eventMessageMax = 1024
+
+// notifyQueueSize bounds the notifications waiting to be sent, others are
+// dropped.
+const notifyQueueSize = 100
+
+// NotificationsConfig publishes the log errors of a container where its
+// owner sees them.
+type NotificationsConfig struct {
+	// Events posts a warning event on the pod of the container.
+	Events bool `config:"events"`
+	// Annotation is the pod annotation holding the errors of its containers,
+	// none when empty. It's removed once they are fixed.
+	Annotation string `config:"annotation"`
+	// Webhook receives the errors and metadata of containers in json.
+	Webhook *WebhookConfig `config:"webhook"`
+	// Interval is the minimum time between two notifications of a container,
+	// default 10m.
+	Interval time.Duration `config:"interval"`
+}
+
+// WebhookConfig is an http endpoint notifications are posted to.
+type WebhookConfig struct {
+	URL     string            `config:"url"`
+	Headers map[string]string `config:"headers"`
+	// Timeout defaults to 10s.
+	Timeout time.Duration `config:"timeout"`
+}
+
+// notification is the json payload of webhooks.
+type notification struct {
+	Time      time.Time             `json:"time"`
+	Node      string                `json:"node,omitempty"`
+	Container notificationContainer `json:"container"`
+	Metadata  map[string]string     `json:"metadata"`
+	Errors    []notificationError   `json:"errors"`
+	// Fatal tells that no log of the container is collected.
+	Fatal bool `json:"fatal"`
+}
+
+type notificationContainer struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Pod          string `json:"pod,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+	PodContainer string `json:"pod_container,omitempty"`
+}
+
+type notificationError struct {
+	// Log is empty for the errors of the whole container.
+	Log   string `json:"log,omitempty"`
+	Error string `json:"error"`
+}
+
+// message tells the errors in a line.
+func (n *notification) message() string {
+	messages := make([]string, len(n.Errors))
+	for i, e := range n.Errors {
+		messages[i] = e.Error
+	}
+	return fmt.Sprintf("log-pilot: invalid logs of container %s: %s", n.Container.Name, strings.Join(messages, "; "))
+}
+
+// notifier publishes log errors as kubernetes events, pod annotations and
+// webhooks, once per interval and container at most.
+type notifier struct {
+	config   *NotificationsConfig
+	kube     *kubeClient
+	client   *http.Client
+	nodeName string
+	logger   log.FieldLogger
+	sent     *counterVec
+	now      func() time.Time
+	mutex    sync.Mutex
+	last     map[string]time.Time
+	// queue holds notifications until run sends them, out of the planning
+	// of containers
+	queue chan *notification
+}
+
+// WithNotifications reports the log errors of containers to their owners.
+func WithNotifications(config *NotificationsConfig) Option {
+	return func(p *Pilot) error {
+		p.notifyConfig = config
+		return nil
+	}
+}
+
+func newNotifier(config *NotificationsConfig, kube *kubeClient, nodeName string, logger log.FieldLogger, m *metrics) (*notifier, error) {
+	if (config.Events || config.Annotation != "") && (kube == nil || kube.config.Source != KUBE_SOURCE_APISERVER) {
+		return nil, fmt.Errorf("notifications: events and annotation require kubernetes with source %s", KUBE_SOURCE_APISERVER)
+	}
+	if config.Interval == 0 {
+		config.Interval = 10 * time.Minute
+	}
+	n := &notifier{
+		config:   config,
+		kube:     kube,
+		nodeName: nodeName,
+		logger:   logger,
+		now:      time.Now,
+		last:     make(map[string]time.Time),
+		queue:    make(chan *notification, notifyQueueSize),
+		sent: m.counter("log_pilot_notifications_total",
+			"Notifications of log errors sent, failed, rate limited or dropped, by channel.", "channel", "result"),
+	}
+	if config.Webhook != nil {
+		if config.Webhook.URL == "" {
+			return nil, fmt.Errorf("notifications: webhook url is required")
+		}
+		if config.Webhook.Timeout == 0 {
+			config.Webhook.Timeout = 10 * time.Second
+		}
+		n.client = &http.Client{Timeout: config.Webhook.Timeout}
+	}
+	return n, nil
+}
+
+// channels are the configured ways of notifying.
+func (n *notifier) channels() []string {
+	var channels []string
+	if n.config.Events {
+		channels = append(channels, "event")
+	}
+	if n.config.Annotation != "" {
+		channels = append(channels, "annotation")
+	}
+	if n.config.Webhook != nil {
+		channels = append(channels, "webhook")
+	}
+	return channels
+}
+
+// allow tells whether a container may be notified now, and records it.
+func (n *notifier) allow(id string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	now := n.now()
+	if last, ok := n.last[id]; ok && now.Sub(last) < n.config.Interval {
+		return false
+	}
+	n.last[id] = now
+	return true
+}
+
+// forget drops the rate limit of a removed container.
+func (n *notifier) forget(id string) {
+	if n == nil {
+		return
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	delete(n.last, id)
+}
+
+// run sends the queued notifications until ctx is done. It's nil-safe.
+func (n *notifier) run(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-n.queue:
+			n.send(notification)
+		}
+	}
+}
+
+// notify queues the errors of a plan, err failing the whole container.
+// Without errors, the annotation of earlier ones is removed. It doesn't
+// block: notifications are dropped once the queue is full. It's nil-safe.
+func (n *notifier) notify(containerJSON *types.ContainerJSON, plan *containerPlan, err error) {
+	if n == nil {
+		return
+	}
+	labels := containerJSON.Config.Labels
+	notification := &notification{
+		Time: n.now().UTC(),
+		Node: n.nodeName,
+		Container: notificationContainer{
+			ID:           plan.id,
+			Name:         plan.name,
+			Pod:          labels[LABEL_POD],
+			Namespace:    labels[LABEL_K8S_POD_NAMESPACE],
+			PodContainer: labels[LABEL_K8S_CONTAINER_NAME],
+		},
+		Metadata: plan.metadata,
+		Fatal:    err != nil,
+	}
+	for _, logErr := range plan.logErrors {
+		notification.Errors = append(notification.Errors, notificationError{Log: logErr.log, Error: logErr.Error()})
+	}
+	if _, ok := err.(logErrors); err != nil && !ok {
+		notification.Errors = append(notification.Errors, notificationError{Error: err.Error()})
+	}
+	if notification.Container.PodContainer == "" {
+		notification.Container.PodContainer = plan.name
+	}
+	inPod := notification.Container.Pod != "" && notification.Container.Namespace != ""
+
+	if len(notification.Errors) == 0 && !(inPod && n.config.Annotation != "") {
+		return
+	}
+	if len(notification.Errors) > 0 && !n.allow(plan.id) {
+		for _, channel := range n.channels() {
+			n.sent.inc(channel, "limited")
+		}
+		return
+	}
+
+	select {
+	case n.queue <- notification:
+	default:
+		for _, channel := range n.channels() {
+			n.sent.inc(channel, "dropped")
+		}
+		n.logger.Warnf("notify log errors of %s: queue is full, dropped", plan.id)
+	}
+}
+
+// send publishes a notification, or clears the annotation of a container
+// without errors.
+func (n *notifier) send(notification *notification) {
+	inPod := notification.Container.Pod != "" && notification.Container.Namespace != ""
+	if len(notification.Errors) == 0 {
+		if cleared, err := n.clearAnnotation(notification); cleared || err != nil {
+			n.report("annotation", err)
+		}
+		return
+	}
+
+	if inPod && n.config.Events {
+		n.report("event", n.postEvent(notification))
+	}
+	if inPod && n.config.Annotation != "" {
+		n.report("annotation", n.annotate(notification, notification.message()))
+	}
+	if n.config.Webhook != nil {
+		n.report("webhook", n.postWebhook(notification))
+	}
+}
+
+func (n *notifier) report(channel string, err error) {
+	if err != nil {
+		n.sent.inc(channel, "failed")
+		n.logger.Warnf("notify log errors by %s: %v", channel, err)
+		return
+	}
+	n.sent.inc(channel, "sent")
+}
+
+func (n *notifier) postEvent(notification *notification) error {
+	container := notification.Container
+	message := notification.message()
+	if len(message) > eventMessageMax {
+		message = message[:eventMessageMax-3] + "..."
+	}
+	var uid string
+	if pod, err := n.kube.pod(container.Namespace, container.Pod); err == nil {
+		uid = pod.Metadata.UID
+	}
+	timestamp := notification.Time.Format(time.RFC3339)
+	event := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"generateName": container.Pod + ".",
+			"namespace":    container.Namespace,
+		},
+		"involvedObject": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"namespace":  container.Namespace,
+			"name":       container.Pod,
+			"uid":        uid,
+			"fieldPath":  fmt.Sprintf("spec.containers{%s}", container.PodContainer),
+		},
+		"reason":         EVENT_REASON,
+		"message":        message,
+		"type":           "Warning",
+		"source":         map[string]interface{}{"component": "log-pilot", "host": n.nodeName},
+		"firstTimestamp": timestamp,
+		"lastTimestamp":  timestamp,
+		"count":          1,
+	}
+	return n.kube.send("POST", fmt.Sprintf("/api/v1/namespaces/%s/events", container.Namespace),
+		"application/json", event)
+}
+
+// annotate sets the message of a container in the annotation of its pod,
+// a json object of messages by container. An empty message removes it.
+func (n *notifier) annotate(notification *notification, message string) error {
+	container := notification.Container
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", container.Namespace, container.Pod)
+	var pod kubePod
+	if err := n.kube.get(path, &pod); err != nil {
+		return err
+	}
+	status := make(map[string]string)
+	if value := pod.Metadata.Annotations[n.config.Annotation]; value != "" {
+		// a value pilot didn't write is replaced
+		json.Unmarshal([]byte(value), &status)
+	}
+	if message == "" {
+		delete(status, container.PodContainer)
+	} else {
+		status[container.PodContainer] = message
+	}
+
+	// null removes the annotation
+	var value interface{}
+	if len(status) > 0 {
+		b, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{n.config.Annotation: value},
+		},
+	}
+	return n.kube.send("PATCH", path, "application/merge-patch+json", patch)
+}
+
+// clearAnnotation removes the errors of a fixed container from the
+// annotation of its pod, if the cached pod has some.
+func (n *notifier) clearAnnotation(notification *notification) (bool, error) {
+	container := notification.Container
+	pod, err := n.kube.pod(container.Namespace, container.Pod)
+	if err != nil {
+		return false, err
+	}
+	status := make(map[string]string)
+	json.Unmarshal([]byte(pod.Metadata.Annotations[n.config.Annotation]), &status)
+	if _, ok := status[container.PodContainer]; !ok {
+		return false, nil
+	}
+	return true, n.annotate(notification, "")
+}
+
+func (n *notifier) postWebhook(notification *notification) error {
+	b, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.config.Webhook.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range n.config.Webhook.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook %s: %s %s", n.config.Webhook.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
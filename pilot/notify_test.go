@@ -0,0 +1,285 @@
+package pilot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/check.v1"
+)
+
+type NotifySuite struct{}
+
+var _ = check.Suite(&NotifySuite{})
+
+const statusAnnotation = "log-pilot.io/errors"
+
+// podContainer returns labels of a container of the test pod.
+func podContainer(labels map[string]string) map[string]string {
+	pod := newTestPod()
+	ret := map[string]string{
+		LABEL_POD:                pod.Metadata.Name,
+		LABEL_K8S_POD_NAMESPACE:  pod.Metadata.Namespace,
+		LABEL_K8S_CONTAINER_NAME: "web",
+	}
+	for k, v := range labels {
+		ret[k] = v
+	}
+	return ret
+}
+
+// drain sends the queued notifications, as run does.
+func drain(n *notifier) {
+	for len(n.queue) > 0 {
+		n.send(<-n.queue)
+	}
+}
+
+var invalidLabels = map[string]string{
+	"aliyun.logs.app":        "stdout",
+	"aliyun.logs.bad":        "stdout",
+	"aliyun.logs.bad.stream": "both",
+}
+
+func (s *NotifySuite) TestKubernetes(c *check.C) {
+	pod := newTestPod()
+	pod.Metadata.UID = "6f1c"
+	pod.Metadata.Annotations[statusAnnotation] = `{"sidecar":"log-pilot: invalid logs of container sidecar: x"}`
+	kube := newFakeKube([]kubePod{pod})
+	defer kube.server.Close()
+
+	p, _ := newTestPilot(c, newFakeDocker(),
+		WithKubernetes(&KubernetesConfig{URL: kube.server.URL, TokenFile: writeToken(c)}),
+		WithNotifications(&NotificationsConfig{Events: true, Annotation: statusAnnotation}))
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	p.notifier.now = func() time.Time { return now }
+
+	containerJSON := fakeContainer("c1", podContainer(invalidLabels))
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	drain(p.notifier)
+
+	events := kube.bodies["POST /api/v1/namespaces/shop/events"]
+	c.Assert(events, check.HasLen, 1)
+	var event struct {
+		Metadata       map[string]string
+		InvolvedObject map[string]string
+		Reason         string
+		Message        string
+		Type           string
+		FirstTimestamp string
+	}
+	c.Assert(json.Unmarshal([]byte(events[0]), &event), check.IsNil)
+	c.Assert(event.Metadata, check.DeepEquals, map[string]string{"generateName": pod.Metadata.Name + ".", "namespace": "shop"})
+	c.Assert(event.InvolvedObject, check.DeepEquals, map[string]string{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"namespace":  "shop",
+		"name":       pod.Metadata.Name,
+		"uid":        "6f1c",
+		"fieldPath":  "spec.containers{web}",
+	})
+	c.Assert(event.Reason, check.Equals, EVENT_REASON)
+	c.Assert(event.Type, check.Equals, "Warning")
+	c.Assert(event.Message, check.Equals, "log-pilot: invalid logs of container c1: in log bad: stream must be all, stdout or stderr, not both")
+	c.Assert(event.FirstTimestamp, check.Equals, "2018-06-01T12:00:00Z")
+
+	// the errors of other containers are kept
+	patches := kube.bodies["PATCH /api/v1/namespaces/shop/pods/"+pod.Metadata.Name]
+	c.Assert(patches, check.HasLen, 1)
+	var patch struct {
+		Metadata struct {
+			Annotations map[string]string
+		}
+	}
+	c.Assert(json.Unmarshal([]byte(patches[0]), &patch), check.IsNil)
+	var status map[string]string
+	c.Assert(json.Unmarshal([]byte(patch.Metadata.Annotations[statusAnnotation]), &status), check.IsNil)
+	c.Assert(status, check.DeepEquals, map[string]string{
+		"sidecar": "log-pilot: invalid logs of container sidecar: x",
+		"web":     event.Message,
+	})
+
+	// rate limited per container
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	drain(p.notifier)
+	c.Assert(kube.bodies["POST /api/v1/namespaces/shop/events"], check.HasLen, 1)
+	c.Assert(p.notifier.sent.get("event", "limited"), check.Equals, uint64(1))
+	now = now.Add(11 * time.Minute)
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	drain(p.notifier)
+	c.Assert(kube.bodies["POST /api/v1/namespaces/shop/events"], check.HasLen, 2)
+	c.Assert(p.notifier.sent.get("event", "sent"), check.Equals, uint64(2))
+	c.Assert(p.notifier.sent.get("annotation", "sent"), check.Equals, uint64(2))
+}
+
+func (s *NotifySuite) TestClearAnnotation(c *check.C) {
+	pod := newTestPod()
+	pod.Metadata.Annotations[statusAnnotation] = `{"web":"log-pilot: invalid logs of container c1: x"}`
+	kube := newFakeKube([]kubePod{pod})
+	defer kube.server.Close()
+
+	p, _ := newTestPilot(c, newFakeDocker(),
+		WithKubernetes(&KubernetesConfig{URL: kube.server.URL, TokenFile: writeToken(c)}),
+		WithNotifications(&NotificationsConfig{Annotation: statusAnnotation}))
+
+	containerJSON := fakeContainer("c2", podContainer(map[string]string{"aliyun.logs.app": "stdout"}))
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	drain(p.notifier)
+	c.Assert(kube.bodies["PATCH /api/v1/namespaces/shop/pods/"+pod.Metadata.Name], check.DeepEquals,
+		[]string{`{"metadata":{"annotations":{"log-pilot.io/errors":null}}}`})
+
+	// nothing to clear out of pods
+	delete(kube.bodies, "PATCH /api/v1/namespaces/shop/pods/"+pod.Metadata.Name)
+	containerJSON = fakeContainer("c3", map[string]string{"aliyun.logs.app": "stdout"})
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	drain(p.notifier)
+	c.Assert(kube.bodies, check.HasLen, 0)
+}
+
+// webhook is a webhook stand-in recording notifications.
+type webhook struct {
+	mutex         sync.Mutex
+	status        int
+	notifications []notification
+	headers       []http.Header
+	server        *httptest.Server
+}
+
+func newWebhook(status int) *webhook {
+	w := &webhook{status: status}
+	w.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		var n notification
+		json.Unmarshal(b, &n)
+		w.mutex.Lock()
+		w.notifications = append(w.notifications, n)
+		w.headers = append(w.headers, r.Header)
+		w.mutex.Unlock()
+		rw.WriteHeader(w.status)
+	}))
+	return w
+}
+
+func (s *NotifySuite) TestWebhook(c *check.C) {
+	hook := newWebhook(http.StatusOK)
+	defer hook.server.Close()
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithNodeName("node-1"), WithNotifications(&NotificationsConfig{
+		Webhook: &WebhookConfig{URL: hook.server.URL, Headers: map[string]string{"X-Token": "secret"}},
+	}))
+	containerJSON := fakeContainer("c1", invalidLabels)
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	drain(p.notifier)
+
+	c.Assert(hook.notifications, check.HasLen, 1)
+	n := hook.notifications[0]
+	c.Assert(n.Node, check.Equals, "node-1")
+	c.Assert(n.Container, check.DeepEquals, notificationContainer{ID: "c1", Name: "c1", PodContainer: "c1"})
+	c.Assert(n.Metadata["docker_container_image"], check.Equals, "busybox")
+	c.Assert(n.Errors, check.DeepEquals, []notificationError{
+		{Log: "bad", Error: "in log bad: stream must be all, stdout or stderr, not both"},
+	})
+	c.Assert(n.Fatal, check.Equals, false)
+	c.Assert(hook.headers[0].Get("X-Token"), check.Equals, "secret")
+	c.Assert(hook.headers[0].Get("Content-Type"), check.Equals, "application/json")
+
+	// containers failing as a whole
+	containerJSON = fakeContainer("c2", map[string]string{"aliyun.logs.app": "stdout"})
+	containerJSON.Config.Labels["aliyun.logs.app.target"] = "{{ .k8s_namespace }}"
+	p.strict = true
+	c.Assert(p.newContainer(&containerJSON), check.NotNil)
+	drain(p.notifier)
+	c.Assert(hook.notifications, check.HasLen, 2)
+	c.Assert(hook.notifications[1].Fatal, check.Equals, true)
+	c.Assert(hook.notifications[1].Errors, check.HasLen, 1)
+	c.Assert(hook.notifications[1].Errors[0].Log, check.Equals, "app")
+
+	// no notification without errors
+	containerJSON = fakeContainer("c3", map[string]string{"aliyun.logs.app": "stdout"})
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	drain(p.notifier)
+	c.Assert(hook.notifications, check.HasLen, 2)
+	c.Assert(p.notifier.sent.get("webhook", "sent"), check.Equals, uint64(2))
+}
+
+func (s *NotifySuite) TestWebhookFailure(c *check.C) {
+	hook := newWebhook(http.StatusInternalServerError)
+	defer hook.server.Close()
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithNotifications(&NotificationsConfig{
+		Webhook: &WebhookConfig{URL: hook.server.URL},
+	}))
+	containerJSON := fakeContainer("c1", invalidLabels)
+	c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	drain(p.notifier)
+	c.Assert(hook.notifications, check.HasLen, 1)
+	c.Assert(p.notifier.sent.get("webhook", "failed"), check.Equals, uint64(1))
+}
+
+func (s *NotifySuite) TestQueue(c *check.C) {
+	release := make(chan struct{})
+	received := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var n notification
+		json.NewDecoder(r.Body).Decode(&n)
+		<-release
+		received <- n.Container.ID
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithNotifications(&NotificationsConfig{
+		Webhook: &WebhookConfig{URL: server.URL},
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.notifier.run(ctx)
+
+	// containers are planned while the webhook hangs
+	done := make(chan struct{})
+	go func() {
+		for _, id := range []string{"c1", "c2"} {
+			containerJSON := fakeContainer(id, invalidLabels)
+			c.Check(p.newContainer(&containerJSON), check.IsNil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("newContainer is blocked by the webhook")
+	}
+
+	release <- struct{}{}
+	release <- struct{}{}
+	c.Assert(<-received, check.Equals, "c1")
+	c.Assert(<-received, check.Equals, "c2")
+}
+
+func (s *NotifySuite) TestQueueFull(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithNotifications(&NotificationsConfig{
+		Webhook: &WebhookConfig{URL: "http://127.0.0.1:1"},
+	}))
+	p.notifier.queue = make(chan *notification, 1)
+	for _, id := range []string{"c1", "c2"} {
+		containerJSON := fakeContainer(id, invalidLabels)
+		c.Assert(p.newContainer(&containerJSON), check.IsNil)
+	}
+	c.Assert(p.notifier.sent.get("webhook", "dropped"), check.Equals, uint64(1))
+}
+
+func (s *NotifySuite) TestInvalid(c *check.C) {
+	for config, expected := range map[string]string{
+		"notifications: {events: true}":           "notifications: events and annotation require kubernetes with source apiserver",
+		"notifications: {annotation: a}":          "notifications: events and annotation require kubernetes with source apiserver",
+		"notifications: {webhook: {timeout: 1s}}": "notifications: webhook url is required",
+	} {
+		_, err := New(WithTemplate(testTemplate), WithDockerClient(newFakeDocker()),
+			WithPiloter(&fakePiloter{}), WithConfig(newTestConfig(c, config)))
+		c.Assert(err, check.ErrorMatches, expected, check.Commentf(config))
+	}
+}
@@ -0,0 +1,244 @@
+package pilot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+// DockerClient is the part of the docker api used by pilot.
+type DockerClient interface {
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
+	VolumeInspect(ctx context.Context, volumeID string) (types.Volume, error)
+}
+
+// dockerClient adapts *client.Client to DockerClient, the docker client is
+// built against its own vendored context package.
+type dockerClient struct {
+	client *client.Client
+}
+
+// NewDockerClient wraps a docker api client to be used by WithDockerClient.
+func NewDockerClient(c *client.Client) DockerClient {
+	return &dockerClient{client: c}
+}
+
+func (c *dockerClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	return c.client.ContainerList(ctx, options)
+}
+
+func (c *dockerClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	return c.client.ContainerInspect(ctx, containerID)
+}
+
+func (c *dockerClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	return c.client.Events(ctx, options)
+}
+
+func (c *dockerClient) VolumeInspect(ctx context.Context, volumeID string) (types.Volume, error) {
+	return c.client.VolumeInspect(ctx, volumeID)
+}
+
+// Option configures a Pilot created by New.
+type Option func(p *Pilot) error
+
+// WithTemplate sets the template rendering the config of a container.
+func WithTemplate(tpl string) Option {
+	return func(p *Pilot) error {
+		t, err := template.New("pilot").Funcs(templateFuncs).Parse(tpl)
+		if err != nil {
+			return err
+		}
+		p.tpl = t
+		return nil
+	}
+}
+
+// WithDockerClient sets the docker client, default is a client configured from
+// the DOCKER_* env variables.
+func WithDockerClient(c DockerClient) Option {
+	return func(p *Pilot) error {
+		p.dockerClient = c
+		return nil
+	}
+}
+
+// WithConfDir sets the directory of the container configs of the log agent,
+// default /etc/filebeat/prospectors.d or /etc/fluentd/conf.d. Pilots of the
+// same host need one each.
+func WithConfDir(dir string) Option {
+	return func(p *Pilot) error {
+		p.confDir = dir
+		return nil
+	}
+}
+
+// WithOutput sets the output of the log agent, as FLUENTD_OUTPUT or
+// FILEBEAT_OUTPUT do, default stdout or console.
+func WithOutput(output string) Option {
+	return func(p *Pilot) error {
+		p.output = output
+		return nil
+	}
+}
+
+// WithAgentConfig sets the main config of the log agent, default
+// /etc/filebeat/filebeat.yml or /etc/fluentd/fluentd.conf. Pilots of the
+// same host need one each.
+func WithAgentConfig(path string) Option {
+	return func(p *Pilot) error {
+		p.agentConfig = path
+		return nil
+	}
+}
+
+// WithRegistryFile sets the registry of filebeat, where it keeps the offsets
+// of files, default /var/lib/filebeat/registry.
+func WithRegistryFile(path string) Option {
+	return func(p *Pilot) error {
+		p.registryFile = path
+		return nil
+	}
+}
+
+// WithBaseDir sets the directory where the host root is mounted, default is /host.
+func WithBaseDir(base string) Option {
+	return func(p *Pilot) error {
+		p.base = base
+		return nil
+	}
+}
+
+// WithBackend selects the log agent by name, filebeat or fluentd.
+func WithBackend(name string) Option {
+	return func(p *Pilot) error {
+		if name != PILOT_FILEBEAT && name != PILOT_FLUENTD {
+			return fmt.Errorf("unsupported pilot type: %s", name)
+		}
+		p.backend = name
+		return nil
+	}
+}
+
+// WithPiloter sets the piloter driving the log agent, it takes precedence over WithBackend.
+func WithPiloter(piloter Piloter) Option {
+	return func(p *Pilot) error {
+		p.piloter = piloter
+		return nil
+	}
+}
+
+// WithFilebeatVersion sets the version of filebeat, logs using features
+// it lacks are rejected. By default the version is unknown and nothing is.
+func WithFilebeatVersion(version string) Option {
+	return func(p *Pilot) error {
+		p.filebeatVersion = version
+		return nil
+	}
+}
+
+// WithLogPrefix sets the prefixes of log labels and env, default is aliyun.
+func WithLogPrefix(prefix ...string) Option {
+	return func(p *Pilot) error {
+		if len(prefix) == 0 {
+			return fmt.Errorf("log prefix can not be empty")
+		}
+		p.logPrefix = prefix
+		return nil
+	}
+}
+
+// WithSymlink enables symlinks to container volumes under SYMLINK_LOGS_BASE.
+func WithSymlink(enabled bool) Option {
+	return func(p *Pilot) error {
+		p.createSymlink = enabled
+		return nil
+	}
+}
+
+// WithStrict fails a container on any error of its logs, instead of
+// collecting its valid logs.
+func WithStrict(enabled bool) Option {
+	return func(p *Pilot) error {
+		p.strict = enabled
+		return nil
+	}
+}
+
+// WithNodeName sets the node name added to the metadata of every container.
+func WithNodeName(name string) Option {
+	return func(p *Pilot) error {
+		p.nodeName = name
+		return nil
+	}
+}
+
+// WithLogger sets the logger, default is the standard logrus logger.
+func WithLogger(logger log.FieldLogger) Option {
+	return func(p *Pilot) error {
+		p.logger = logger
+		return nil
+	}
+}
+
+// WithFormat registers a format converter for this pilot only,
+// on top of the ones registered by Register.
+func WithFormat(format string, converter FormatConverter) Option {
+	return func(p *Pilot) error {
+		p.converters[format] = converter
+		return nil
+	}
+}
+
+// FromEnv configures the pilot the way the log-pilot binary does, from
+// PILOT_TYPE, PILOT_LOG_PREFIX, PILOT_CREATE_SYMLINK, PILOT_FIELD_SCHEMA, NODE_NAME,
+// FLUENTD_OUTPUT or FILEBEAT_OUTPUT, PILOT_AGENT_CONFIG, FILEBEAT_REGISTRY_FILE
+// and FILEBEAT_VERSION.
+// Options after FromEnv override it.
+func FromEnv() Option {
+	return func(p *Pilot) error {
+		if pilotType := os.Getenv(ENV_PILOT_TYPE); pilotType != "" {
+			p.backend = pilotType
+		}
+		if envLogPrefix := os.Getenv(ENV_PILOT_LOG_PREFIX); envLogPrefix != "" {
+			p.logPrefix = strings.Split(envLogPrefix, ",")
+		}
+		p.createSymlink = os.Getenv(ENV_PILOT_CREATE_SYMLINK) == "true"
+		p.nodeName = os.Getenv(ENV_NODE_NAME)
+		p.filebeatVersion = os.Getenv(ENV_FILEBEAT_VERSION)
+		p.output = os.Getenv(ENV_FILEBEAT_OUTPUT)
+		if p.backend == PILOT_FLUENTD {
+			p.output = os.Getenv(ENV_FLUENTD_OUTPUT)
+		}
+		if agentConfig := os.Getenv(ENV_PILOT_AGENT_CONFIG); agentConfig != "" {
+			p.agentConfig = agentConfig
+		}
+		if registryFile := os.Getenv(ENV_FILEBEAT_REGISTRY_FILE); registryFile != "" {
+			p.registryFile = registryFile
+		}
+		if schema := os.Getenv(ENV_PILOT_FIELD_SCHEMA); schema != "" {
+			return WithSchema(schema)(p)
+		}
+		return nil
+	}
+}
+
+func newEnvDockerClient() (DockerClient, error) {
+	c, err := client.NewEnvClient()
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("DOCKER_API_VERSION") == "" {
+		c.UpdateClientVersion("1.23")
+	}
+	return NewDockerClient(c), nil
+}
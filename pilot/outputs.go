@@ -0,0 +1,244 @@
+package pilot
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// OUTPUTS_CONF is the config file of the named outputs, in the config home
+// of the piloter.
+const OUTPUTS_CONF = "outputs"
+
+// OutputConfig is a named output, logs are sent to with the
+// aliyun.logs.$name.output label.
+type OutputConfig struct {
+	Name string `config:"name"`
+	// Type is the fluentd output plugin, like kafka_buffered or elasticsearch.
+	Type string `config:"type"`
+	// Params of the fluentd output plugin.
+	Params map[string]string `config:"params"`
+	// Topic and Index route logs within the single output of filebeat,
+	// replacing their topic and index fields.
+	Topic string `config:"topic"`
+	Index string `config:"index"`
+}
+
+// validParam matches the names of output names, plugins and params, which
+// the configs use unquoted.
+var validParam = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// WithOutputs declares named outputs, and the one of logs declaring none.
+// Without default output, those go to the output of the log agent.
+func WithOutputs(outputs []*OutputConfig, defaultOutput string) Option {
+	return func(p *Pilot) error {
+		p.outputs = make(map[string]*OutputConfig, len(outputs))
+		for _, output := range outputs {
+			if !validParam.MatchString(output.Name) {
+				return fmt.Errorf("invalid output name %q: only letters, digits and _ are allowed", output.Name)
+			}
+			if _, ok := p.outputs[output.Name]; ok {
+				return fmt.Errorf("output %s is declared twice", output.Name)
+			}
+			if output.Type != "" && !validParam.MatchString(output.Type) {
+				return fmt.Errorf("output %s: invalid type %q", output.Name, output.Type)
+			}
+			for key, value := range output.Params {
+				if !validParam.MatchString(key) {
+					return fmt.Errorf("output %s: invalid param %q", output.Name, key)
+				}
+				if err := checkValue(value); err != nil {
+					return fmt.Errorf("output %s: param %s: %v", output.Name, key, err)
+				}
+			}
+			for _, route := range []string{output.Topic, output.Index} {
+				if route != "" && !validName.MatchString(route) {
+					return fmt.Errorf("output %s: invalid topic or index %q", output.Name, route)
+				}
+			}
+			p.outputs[output.Name] = output
+		}
+		if _, ok := p.outputs[defaultOutput]; defaultOutput != "" && !ok {
+			return fmt.Errorf("default output %s is not declared", defaultOutput)
+		}
+		p.defaultOutput = defaultOutput
+		return nil
+	}
+}
+
+// checkOutputs tells whether the named outputs fit the backend.
+func (p *Pilot) checkOutputs() error {
+	for _, output := range p.outputs {
+		switch p.piloter.Name() {
+		case PILOT_FLUENTD:
+			if output.Type == "" {
+				return fmt.Errorf("output %s: type is required by fluentd", output.Name)
+			}
+		case PILOT_FILEBEAT:
+			if output.Type != "" || len(output.Params) > 0 {
+				return fmt.Errorf("output %s: filebeat has a single output, named outputs only route by topic and index", output.Name)
+			}
+		}
+	}
+	// a log listing several outputs could only reach one of them
+	if p.piloter.Name() == PILOT_FILEBEAT && len(p.outputs) > 1 {
+		return fmt.Errorf("filebeat can't send a log to several outputs, a single named output may be declared")
+	}
+	return nil
+}
+
+// LogDestination is one of the outputs of a log sent to several, with the
+// target and tags it overrides there.
+type LogDestination struct {
+	Output string
+	// Target replaces the target of the log, when not empty
+	Target string
+	// Tags are added to the tags of the log, replacing them
+	Tags map[string]string
+	// OutputType is the type of the output, set when rendering
+	OutputType string
+}
+
+// parseOutput returns the named output of a log, "" for the output of the
+// log agent, or its destinations when it has several outputs. Logs are
+// routed within the single output of filebeat, which has a single named
+// output at most.
+func (p *Pilot) parseOutput(name string, info *LogInfoNode, tags map[string]string) (string, []LogDestination, error) {
+	output := info.get("output")
+	outputs, ok := info.children["outputs"]
+	if ok && output != "" {
+		return "", nil, fmt.Errorf("in log %s: output and outputs can't be both set", name)
+	}
+	if ok {
+		destinations, err := p.parseDestinations(name, outputs)
+		if err != nil {
+			return "", nil, err
+		}
+		if p.piloter.Name() != PILOT_FILEBEAT {
+			return "", destinations, nil
+		}
+		// the single destination is the output of the log
+		destination := destinations[0]
+		if destination.Target != "" {
+			tags["topic"] = destination.Target
+		}
+		for key, value := range destination.Tags {
+			tags[key] = value
+		}
+		output = destination.Output
+	}
+
+	if output == "" {
+		output = p.defaultOutput
+	}
+	if output == "" {
+		return "", nil, nil
+	}
+	config, ok := p.outputs[output]
+	if !ok {
+		return "", nil, fmt.Errorf("in log %s: output %s is not declared", name, output)
+	}
+	if config.Topic != "" {
+		tags["topic"] = config.Topic
+	}
+	if config.Index != "" {
+		tags["index"] = config.Index
+	}
+	return output, nil, nil
+}
+
+// outputOverrideKeys are the keys of outputs.<output> in a log declaration.
+var outputOverrideKeys = map[string]bool{"target": false, "tags": false}
+
+// parseDestinations reads outputs=a,b and the outputs.a.target and
+// outputs.a.tags overrides.
+func (p *Pilot) parseDestinations(name string, outputs *LogInfoNode) ([]LogDestination, error) {
+	var destinations []LogDestination
+	listed := make(map[string]bool)
+	for _, output := range strings.Split(outputs.value, ",") {
+		if output = strings.TrimSpace(output); output == "" {
+			continue
+		}
+		if _, ok := p.outputs[output]; !ok {
+			return nil, fmt.Errorf("in log %s: output %s is not declared", name, output)
+		}
+		if listed[output] {
+			return nil, fmt.Errorf("in log %s: output %s is listed twice", name, output)
+		}
+		listed[output] = true
+
+		destination := LogDestination{Output: output}
+		if overrides, ok := outputs.children[output]; ok {
+			if err := checkKeys(name, "outputs."+output+".", overrides, outputOverrideKeys); err != nil {
+				return nil, err
+			}
+			destination.Target = overrides.get("target")
+			if isTemplate(destination.Target) {
+				if err := checkTemplate(destination.Target); err != nil {
+					return nil, fmt.Errorf("in log %s: target for output %s: %v", name, output, err)
+				}
+			} else if destination.Target != "" && !validName.MatchString(destination.Target) {
+				return nil, fmt.Errorf("in log %s: invalid target %q for output %s", name, destination.Target, output)
+			}
+			tags, err := p.parseTags(overrides.get("tags"))
+			if err != nil {
+				return nil, fmt.Errorf("in log %s: tags for output %s: %v", name, output, err)
+			}
+			if len(tags) > 0 {
+				destination.Tags = tags
+			}
+		}
+		destinations = append(destinations, destination)
+	}
+	if len(destinations) == 0 {
+		return nil, fmt.Errorf("in log %s: outputs is empty", name)
+	}
+	for output := range outputs.children {
+		if !listed[output] {
+			return nil, fmt.Errorf("in log %s: overrides for output %s which is not listed", name, output)
+		}
+	}
+	return destinations, nil
+}
+
+// renderOutputs renders the config of the named outputs, "" when the
+// backend has none. Fluentd outputs are labels the logs are relabeled to.
+func (p *Pilot) renderOutputs() string {
+	if p.piloter.Name() != PILOT_FLUENTD || len(p.outputs) == 0 {
+		return ""
+	}
+	var names []string
+	for name := range p.outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		output := p.outputs[name]
+		fmt.Fprintf(&buf, "<label @output_%s>\n  <match **>\n    @type %s\n", name, output.Type)
+		var keys []string
+		for key := range output.Params {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "    %s %s\n", key, fluentdString(output.Params[key]))
+		}
+		buf.WriteString("  </match>\n</label>\n\n")
+	}
+	return buf.String()
+}
+
+// writeOutputs writes the config of the named outputs, if any.
+func (p *Pilot) writeOutputs() error {
+	config := p.renderOutputs()
+	if config == "" {
+		return nil
+	}
+	return ioutil.WriteFile(p.piloter.ConfPathOf(OUTPUTS_CONF), []byte(config), os.FileMode(0644))
+}
@@ -0,0 +1,235 @@
+package pilot
+
+import (
+	"io/ioutil"
+
+	"gopkg.in/check.v1"
+)
+
+type OutputsSuite struct{}
+
+var _ = check.Suite(&OutputsSuite{})
+
+// fakeFilebeat is a fake piloter named after filebeat.
+type fakeFilebeat struct {
+	*fakePiloter
+}
+
+func (f fakeFilebeat) Name() string { return PILOT_FILEBEAT }
+
+const outputsConfig = `
+default_output: es
+outputs:
+  - name: audit
+    type: kafka_buffered
+    params:
+      brokers: kafka-1:9092,kafka-2:9092
+      default_topic: audit
+  - name: es
+    type: elasticsearch
+    params:
+      hosts: es:9200
+      target_index_key: _target
+`
+
+func (s *OutputsSuite) TestFluentd(c *check.C) {
+	p, piloter := newTestPilot(c, newFakeDocker(),
+		WithTemplate(readAsset(c, "fluentd/fluentd.tpl")), WithConfig(newTestConfig(c, outputsConfig)))
+
+	configs, err := p.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app":          "stdout",
+		"aliyun.logs.audit":        "stdout",
+		"aliyun.logs.audit.output": "audit",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Output, check.Equals, "es")
+	c.Assert(configs[1].Output, check.Equals, "audit")
+
+	out, err := p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	c.Assert(out, check.Matches, `(?s).*<match docker.c1.app>\s+@type relabel\s+@label @output_es\s+</match>.*`)
+	c.Assert(out, check.Matches, `(?s).*<match docker.c1.audit>\s+@type relabel\s+@label @output_audit\s+</match>.*`)
+	// the index of elasticsearch outputs is dated
+	c.Assert(out, check.Matches, `(?s).*_target app-\$\{time.strftime\('%Y.%m.%d'\)\}\n.*`)
+	c.Assert(out, check.Matches, `(?s).*_target audit\n.*`)
+
+	c.Assert(p.writeOutputs(), check.IsNil)
+	b, err := ioutil.ReadFile(piloter.ConfPathOf(OUTPUTS_CONF))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(b), check.Equals, `<label @output_audit>
+  <match **>
+    @type kafka_buffered
+    brokers 'kafka-1:9092,kafka-2:9092'
+    default_topic 'audit'
+  </match>
+</label>
+
+<label @output_es>
+  <match **>
+    @type elasticsearch
+    hosts 'es:9200'
+    target_index_key '_target'
+  </match>
+</label>
+
+`)
+
+	_, err = p.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app":        "stdout",
+		"aliyun.logs.app.output": "debug",
+	})
+	c.Assert(err, check.ErrorMatches, "in log app: output debug is not declared")
+}
+
+func (s *OutputsSuite) TestAgentOutput(c *check.C) {
+	p, piloter := newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "fluentd/fluentd.tpl")))
+	configs, err := p.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app": "stdout",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Output, check.Equals, "")
+	out, err := p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	c.Assert(out, check.Not(check.Matches), `(?s).*@type relabel.*`)
+
+	c.Assert(p.writeOutputs(), check.IsNil)
+	files, err := ioutil.ReadDir(piloter.ConfHome())
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 0)
+}
+
+func (s *OutputsSuite) TestFilebeat(c *check.C) {
+	piloter := fakeFilebeat{&fakePiloter{home: c.MkDir()}}
+	_, err := New(WithTemplate(testTemplate), WithDockerClient(newFakeDocker()), WithPiloter(piloter),
+		WithConfig(newTestConfig(c, outputsConfig)))
+	c.Assert(err, check.ErrorMatches, "output (audit|es): filebeat has a single output, named outputs only route by topic and index")
+
+	// a log listing both could only reach one of them
+	_, err = New(WithTemplate(testTemplate), WithDockerClient(newFakeDocker()), WithPiloter(piloter),
+		WithConfig(newTestConfig(c, "outputs: [{name: audit, topic: audit-logs}, {name: archive, index: archive}]")))
+	c.Assert(err, check.ErrorMatches, "filebeat can't send a log to several outputs, a single named output may be declared")
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithPiloter(piloter), WithConfig(newTestConfig(c, `
+outputs:
+  - name: audit
+    topic: audit-logs
+    index: audit
+`)))
+	configs, err := p.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app":        "stdout",
+		"aliyun.logs.app.output": "audit",
+		"aliyun.logs.app.tags":   "topic=app",
+		"aliyun.logs.other":      "stdout",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Tags, check.DeepEquals, map[string]string{"topic": "audit-logs", "index": "audit"})
+	c.Assert(configs[1].Tags, check.DeepEquals, map[string]string{"topic": "other"})
+	c.Assert(p.renderOutputs(), check.Equals, "")
+}
+
+func (s *OutputsSuite) TestFilebeatConfig(c *check.C) {
+	// the index set by named outputs is used by elasticsearch
+	cfg, err := RenderFileBeatCfg(FILEBEAT_CONF_DIR, FILEBEAT_REGISTRY_FILE, "elasticsearch")
+	c.Assert(err, check.IsNil)
+	c.Assert(cfg, check.Matches, `(?s).*output.elasticsearch:\s+hosts: .*\n    index: '%\{\[index\]:\$\{FILEBEAT_INDEX:-filebeat\}\}-%\{\+yyyy.MM.dd\}'\n.*`)
+
+	cfg, err = RenderFileBeatCfg(FILEBEAT_CONF_DIR, FILEBEAT_REGISTRY_FILE, "kafka")
+	c.Assert(err, check.IsNil)
+	c.Assert(cfg, check.Matches, `(?s).*output.kafka:.*topic: '%\{\[topic\]\}'.*`)
+	c.Assert(cfg, check.Not(check.Matches), "(?s).*\t.*")
+
+	cfg, err = RenderFileBeatCfg(FILEBEAT_CONF_DIR, FILEBEAT_REGISTRY_FILE, "unknown")
+	c.Assert(err, check.IsNil)
+	c.Assert(cfg, check.Matches, `(?s).*output.console:.*`)
+}
+
+func (s *OutputsSuite) TestInvalid(c *check.C) {
+	for config, expected := range map[string]string{
+		"outputs: [{name: a.b, type: stdout}]":                        `invalid output name "a.b": only letters, digits and _ are allowed`,
+		"outputs: [{name: a, type: stdout}, {name: a, type: stdout}]": "output a is declared twice",
+		"outputs: [{name: a, type: 'stdout #'}]":                      `output a: invalid type "stdout #"`,
+		"outputs: [{name: a, type: stdout, params: {'a b': c}}]":      `output a: invalid param "a b"`,
+		"outputs: [{name: a}]":                                        "output a: type is required by fluentd",
+		"{outputs: [{name: a, type: stdout}], default_output: b}":     "default output b is not declared",
+	} {
+		_, err := New(WithTemplate(testTemplate), WithDockerClient(newFakeDocker()),
+			WithPiloter(&fakePiloter{}), WithConfig(newTestConfig(c, config)))
+		c.Assert(err, check.ErrorMatches, expected, check.Commentf(config))
+	}
+}
+
+func (s *OutputsSuite) TestFanOut(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(),
+		WithTemplate(readAsset(c, "fluentd/fluentd.tpl")), WithConfig(newTestConfig(c, outputsConfig)))
+
+	configs, err := p.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app":                      "stdout",
+		"aliyun.logs.app.outputs":              "audit, es",
+		"aliyun.logs.app.outputs.audit.target": "app-audit",
+		"aliyun.logs.app.outputs.audit.tags":   "stage=audit",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Output, check.Equals, "")
+	c.Assert(configs[0].Destinations, check.DeepEquals, []LogDestination{
+		{Output: "audit", Target: "app-audit", Tags: map[string]string{"stage": "audit"}},
+		{Output: "es"},
+	})
+
+	out, err := p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	c.Assert(out, check.Matches, `(?s).*<match docker.c1.app>\s+@type copy\s+copy_mode deep\s+`+
+		`<store>\s+@type relabel\s+@label @dest_c1_app_audit\s+</store>\s+`+
+		`<store>\s+@type relabel\s+@label @dest_c1_app_es\s+</store>\s+</match>.*`)
+	c.Assert(out, check.Matches, `(?s).*<label @dest_c1_app_audit>.*_target app-audit\n.*`+
+		`fields '\{"stage":"audit"\}'.*@label @output_audit\s+</match>\s+</label>.*`)
+	// the destination without target keeps the one of the log, dated for elasticsearch
+	c.Assert(out, check.Matches, `(?s).*<label @dest_c1_app_es>\s+<filter \*\*>.*`+
+		`_target app-\$\{time.strftime\('%Y.%m.%d'\)\}\n.*@label @output_es\s+</match>\s+</label>.*`)
+	c.Assert(out, check.Not(check.Matches), `(?s).*@label @output_es\s+</match>\s+<match docker.c1.app>.*`)
+}
+
+func (s *OutputsSuite) TestFanOutFilebeat(c *check.C) {
+	piloter := fakeFilebeat{&fakePiloter{home: c.MkDir()}}
+	p, _ := newTestPilot(c, newFakeDocker(), WithPiloter(piloter), WithConfig(newTestConfig(c, `
+outputs:
+  - name: audit
+    index: audit
+`)))
+	configs, err := p.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app":                      "stdout",
+		"aliyun.logs.app.outputs":              "audit",
+		"aliyun.logs.app.outputs.audit.target": "app-audit",
+		"aliyun.logs.app.outputs.audit.tags":   "stage=audit",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Destinations, check.IsNil)
+	c.Assert(configs[0].Tags, check.DeepEquals, map[string]string{"topic": "app-audit", "stage": "audit", "index": "audit"})
+
+}
+
+func (s *OutputsSuite) TestFanOutInvalid(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, outputsConfig)))
+	for _, t := range []struct {
+		labels   map[string]string
+		expected string
+	}{
+		{map[string]string{"aliyun.logs.app.output": "es", "aliyun.logs.app.outputs": "audit"},
+			"in log app: output and outputs can't be both set"},
+		{map[string]string{"aliyun.logs.app.outputs": "es,debug"},
+			"in log app: output debug is not declared"},
+		{map[string]string{"aliyun.logs.app.outputs": "es,es"},
+			"in log app: output es is listed twice"},
+		{map[string]string{"aliyun.logs.app.outputs": " , "},
+			"in log app: outputs is empty"},
+		{map[string]string{"aliyun.logs.app.outputs": "es", "aliyun.logs.app.outputs.audit.target": "x"},
+			"in log app: overrides for output audit which is not listed"},
+		{map[string]string{"aliyun.logs.app.outputs": "es", "aliyun.logs.app.outputs.es.target": "a b"},
+			`in log app: invalid target "a b" for output es`},
+		{map[string]string{"aliyun.logs.app.outputs": "es", "aliyun.logs.app.outputs.es.tags": "a"},
+			"in log app: tags for output es: .*"},
+	} {
+		t.labels["aliyun.logs.app"] = "stdout"
+		_, err := p.getLogConfigs("/path/to/json.log", nil, "", t.labels)
+		c.Assert(err, check.ErrorMatches, t.expected, check.Commentf("%v", t.labels))
+	}
+}
@@ -0,0 +1,135 @@
+package pilot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PATH_SEPARATOR separates the paths of a log declaring several.
+const PATH_SEPARATOR = ";"
+
+// parsePaths splits the paths of a log declaration: a json list of
+// strings, or paths separated by PATH_SEPARATOR.
+func parsePaths(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	var paths []string
+	if strings.HasPrefix(value, "[") {
+		if err := json.Unmarshal([]byte(value), &paths); err != nil {
+			return nil, fmt.Errorf("invalid path list %s: %v", value, err)
+		}
+	} else {
+		paths = strings.Split(value, PATH_SEPARATOR)
+	}
+
+	var ret []string
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path != "" {
+			ret = append(ret, path)
+		}
+	}
+	return ret, nil
+}
+
+func hasMeta(segment string) bool {
+	return strings.ContainsAny(segment, "*?[")
+}
+
+// splitPattern splits a path pattern into its literal dir prefix and the
+// rest, which starts at the first segment with a wildcard.
+func splitPattern(pattern string) (string, string, error) {
+	segments := strings.Split(strings.TrimPrefix(filepath.Clean(pattern), "/"), "/")
+	for i, segment := range segments {
+		if strings.Contains(segment, "**") && segment != "**" {
+			return "", "", fmt.Errorf("** must be a whole path segment in %s", pattern)
+		}
+		if _, err := filepath.Match(segment, ""); err != nil {
+			return "", "", fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+		if i == len(segments)-1 && segment == "**" {
+			return "", "", fmt.Errorf("%s must end with a file pattern", pattern)
+		}
+	}
+	for i, segment := range segments {
+		if hasMeta(segment) {
+			return "/" + strings.Join(segments[:i], "/"), strings.Join(segments[i:], "/"), nil
+		}
+	}
+	return pattern, "", nil
+}
+
+// globFiles returns the files matching patterns, where ** matches any
+// number of directories.
+func globFiles(patterns []string) []string {
+	var ret []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		prefix, rest, err := splitPattern(pattern)
+		if err != nil {
+			continue
+		}
+		if rest == "" {
+			if info, err := os.Stat(prefix); err == nil && !info.IsDir() && !seen[prefix] {
+				seen[prefix] = true
+				ret = append(ret, prefix)
+			}
+			continue
+		}
+		segments := strings.Split(rest, "/")
+		filepath.Walk(prefix, func(path string, info os.FileInfo, err error) error {
+			if err != nil || path == prefix {
+				return nil
+			}
+			rel, _ := under(path, prefix)
+			if info.IsDir() {
+				if !matchPrefix(segments, strings.Split(rel, "/")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if matchSegments(segments, strings.Split(rel, "/")) && !seen[path] {
+				seen[path] = true
+				ret = append(ret, path)
+			}
+			return nil
+		})
+	}
+	return ret
+}
+
+// matchSegments matches a path against a pattern, segment by segment.
+func matchSegments(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchSegments(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	ok, _ := filepath.Match(pattern[0], path[0])
+	return ok && matchSegments(pattern[1:], path[1:])
+}
+
+// matchPrefix tells whether files under the dir may match the pattern.
+func matchPrefix(pattern, dir []string) bool {
+	if len(dir) == 0 {
+		return true
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	ok, _ := filepath.Match(pattern[0], dir[0])
+	return ok && matchPrefix(pattern[1:], dir[1:])
+}
@@ -0,0 +1,120 @@
+package pilot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"gopkg.in/check.v1"
+)
+
+type PathsSuite struct{}
+
+var _ = check.Suite(&PathsSuite{})
+
+func (s *PathsSuite) TestParsePaths(c *check.C) {
+	for value, expected := range map[string][]string{
+		"/logs/app.log":                     {"/logs/app.log"},
+		"/logs/a.log; /logs/b/*.log;":       {"/logs/a.log", "/logs/b/*.log"},
+		`["/logs/a.log", "/logs/**/b.log"]`: {"/logs/a.log", "/logs/**/b.log"},
+	} {
+		paths, err := parsePaths(value)
+		c.Assert(err, check.IsNil)
+		c.Assert(paths, check.DeepEquals, expected, check.Commentf(value))
+	}
+	_, err := parsePaths(`["/logs/a.log"`)
+	c.Assert(err, check.ErrorMatches, "invalid path list .*")
+}
+
+func (s *PathsSuite) TestSplitPattern(c *check.C) {
+	for pattern, expected := range map[string][2]string{
+		"/logs/app.log":                  {"/logs/app.log", ""},
+		"/logs/*.log":                    {"/logs", "*.log"},
+		"/logs/2018-*/web-?/app.log":     {"/logs", "2018-*/web-?/app.log"},
+		"/var/log/app/**/access.log":     {"/var/log/app", "**/access.log"},
+		"/var/log/app/[a-c]/**/x/*.json": {"/var/log/app", "[a-c]/**/x/*.json"},
+	} {
+		prefix, rest, err := splitPattern(pattern)
+		c.Assert(err, check.IsNil, check.Commentf(pattern))
+		c.Assert([2]string{prefix, rest}, check.Equals, expected, check.Commentf(pattern))
+	}
+	for pattern, expected := range map[string]string{
+		"/logs/a**/app.log": `\*\* must be a whole path segment in /logs/a\*\*/app.log`,
+		"/logs/**":          `/logs/\*\* must end with a file pattern`,
+		"/logs/[a/app.log":  `invalid pattern /logs/\[a/app.log: .*`,
+	} {
+		_, _, err := splitPattern(pattern)
+		c.Assert(err, check.ErrorMatches, expected, check.Commentf(pattern))
+	}
+}
+
+func (s *PathsSuite) TestGlobFiles(c *check.C) {
+	dir := c.MkDir()
+	for _, file := range []string{
+		"2018-05-01/web-1/app.log",
+		"2018-05-01/web-1/app.log.gz",
+		"2018-05-02/web-2/app.log",
+		"2018-05-02/web-2/deep/er/app.log",
+		"other/app.log",
+	} {
+		path := filepath.Join(dir, file)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), check.IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte("x\n"), 0644), check.IsNil)
+	}
+
+	c.Assert(globFiles([]string{dir + "/2018-*/*/app.log"}), check.DeepEquals, []string{
+		dir + "/2018-05-01/web-1/app.log",
+		dir + "/2018-05-02/web-2/app.log",
+	})
+	c.Assert(globFiles([]string{dir + "/2018-05-02/**/app.log", dir + "/other/app.log"}), check.DeepEquals, []string{
+		dir + "/2018-05-02/web-2/app.log",
+		dir + "/2018-05-02/web-2/deep/er/app.log",
+		dir + "/other/app.log",
+	})
+	c.Assert(globFiles([]string{dir + "/missing/*.log"}), check.HasLen, 0)
+}
+
+func (s *PathsSuite) TestCanRemoveConf(c *check.C) {
+	dir, confDir := c.MkDir(), c.MkDir()
+	logFile := filepath.Join(dir, "2018-05-02/web-2/app.log")
+	c.Assert(os.MkdirAll(filepath.Dir(logFile), 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(logFile, []byte("x\n"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(confDir, "c1.yml"), []byte("paths: ['"+dir+"/**/app.log']\n"), 0644), check.IsNil)
+
+	piloter, err := NewFilebeatPiloter(c.MkDir(), confDir, "", "", log.StandardLogger())
+	c.Assert(err, check.IsNil)
+	filebeat := piloter.(*FilebeatPiloter)
+	// files under ** are still being read
+	c.Assert(filebeat.canRemoveConf("c1", map[string]RegistryState{logFile: {Offset: 1}}, nil), check.Equals, false)
+	c.Assert(filebeat.canRemoveConf("c1", map[string]RegistryState{logFile: {Offset: 2}}, nil), check.Equals, true)
+}
+
+func (s *PathsSuite) TestLogConfig(c *check.C) {
+	pilot, _ := newTestPilot(c, newFakeDocker())
+	mounts := []types.MountPoint{
+		{Source: "/data/logs", Destination: "/logs"},
+		{Source: "/data/audit", Destination: "/audit"},
+	}
+	configs, err := pilot.getLogConfigs("/path/to/json.log", mounts, "", map[string]string{
+		"aliyun.logs.app": "/logs/*/*/app.log;/audit/**/*.json",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Paths, check.DeepEquals, []string{
+		"/host/data/logs/*/*/app.log",
+		"/host/data/audit/**/*.json",
+	})
+	c.Assert(configs[0].HostDir, check.Equals, "/host/data/logs/*/*")
+	c.Assert(configs[0].File, check.Equals, "app.log")
+
+	_, err = pilot.getLogConfigs("/path/to/json.log", mounts, "", map[string]string{
+		"aliyun.logs.app": `["/logs/app.log", "/opt/app.log"]`,
+	})
+	c.Assert(err, check.ErrorMatches, "in log app: /opt/app.log is not mount on host.*")
+
+	_, err = pilot.getLogConfigs("/path/to/json.log", mounts, "", map[string]string{
+		"aliyun.logs.app": "/logs/app.log;logs/b.log",
+	})
+	c.Assert(err, check.ErrorMatches, "logs/b.log must be absolute path, for app")
+}
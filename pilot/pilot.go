@@ -8,20 +8,22 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
+	"unicode"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/davecgh/go-spew/spew"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/client"
 	"golang.org/x/net/context"
-	"github.com/davecgh/go-spew/spew"
 )
 
 /**
@@ -34,6 +36,9 @@ const ENV_PILOT_TYPE = "PILOT_TYPE"
 const ENV_PILOT_CREATE_SYMLINK = "PILOT_CREATE_SYMLINK"
 const ENV_FLUENTD_OUTPUT = "FLUENTD_OUTPUT"
 const ENV_FILEBEAT_OUTPUT = "FILEBEAT_OUTPUT"
+const ENV_FILEBEAT_VERSION = "FILEBEAT_VERSION"
+const ENV_FILEBEAT_REGISTRY_FILE = "FILEBEAT_REGISTRY_FILE"
+const ENV_PILOT_AGENT_CONFIG = "PILOT_AGENT_CONFIG"
 
 const LABEL_SERVICE_LOGS_TEMPL = "%s.logs."
 const ENV_SERVICE_LOGS_TEMPL = "%s_logs_"
@@ -51,7 +56,14 @@ const LABEL_K8S_CONTAINER_NAME = "io.kubernetes.container.name"
 const LABEL_RANCHER_STACK = "io.rancher.stack.name"
 const LABEL_RANCHER_STACK_SERVICE = "io.rancher.stack_service.name"
 
-var NODE_NAME = os.Getenv("NODE_NAME")
+const ENV_NODE_NAME = "NODE_NAME"
+
+const STREAM_ALL = "all"
+const STREAM_STDOUT = "stdout"
+const STREAM_STDERR = "stderr"
+
+// DEFAULT_MAX_BYTES bounds the size of a stdout line joined from docker partial messages
+const DEFAULT_MAX_BYTES = 10 * 1024 * 1024
 
 const ERR_ALREADY_STARTED = "already started"
 
@@ -59,12 +71,54 @@ type Pilot struct {
 	mutex         sync.Mutex
 	tpl           *template.Template
 	base          string
-	dockerClient  *client.Client
+	dockerClient  DockerClient
 	reloadChan    chan bool
 	lastReload    time.Time
+	backend       string
 	piloter       Piloter
+	confDir       string
 	logPrefix     []string
 	createSymlink bool
+	nodeName      string
+	converters    map[string]FormatConverter
+	enrichers     []Enricher
+	schema        *fieldSchema
+	kubeConfig    *KubernetesConfig
+	kube          *kubeClient
+	selector      *containerSelector
+	mountInfo     string
+	defaultStdout *StdoutDefaultConfig
+	selfID        string
+	hostPaths     *hostPathPolicy
+	tenants       *tenantPolicies
+	outputs       map[string]*OutputConfig
+	defaultOutput string
+	logger        log.FieldLogger
+	metrics       *metrics
+	// policyDecisions counts what tenant policies do to logs
+	policyDecisions *counterVec
+	// invalidLogs counts the logs left out of containers for their errors
+	invalidLogs *counterVec
+	// strict fails a container on any error of its logs
+	strict       bool
+	notifyConfig *NotificationsConfig
+	notifier     *notifier
+	// removals are the pending removals of fluentd configs, stopped when
+	// Run returns
+	removals      map[string]*time.Timer
+	removalsMutex sync.Mutex
+	// filebeatVersion is the version of the filebeat agent, "" if unknown
+	filebeatVersion string
+	// output is the output of the log agent, FLUENTD_OUTPUT or FILEBEAT_OUTPUT
+	output string
+	// agentConfig is the main config of the log agent and registryFile the
+	// registry of filebeat
+	agentConfig  string
+	registryFile string
+	// watched are the host path patterns of the logs of every container,
+	// checked again for the symlinks created since
+	watched      map[string][]string
+	watchedMutex sync.Mutex
 }
 
 type Piloter interface {
@@ -77,55 +131,125 @@ type Piloter interface {
 	OnDestroyEvent(container string) error
 }
 
-//
-func Run(tpl string, baseDir string) error {
-	p, err := New(tpl, baseDir)
-	if err != nil {
-		panic(err)
+// New creates a pilot, by default collecting aliyun.logs.* declarations
+// with filebeat and the host root mounted on /host.
+func New(opts ...Option) (*Pilot, error) {
+	p := &Pilot{
+		base:       "/host",
+		reloadChan: make(chan bool),
+		removals:   make(map[string]*time.Timer),
+		watched:    make(map[string][]string),
+		backend:    PILOT_FILEBEAT,
+		logPrefix:  []string{"aliyun"},
+		converters: make(map[string]FormatConverter),
+		schema:     fieldSchemas[SCHEMA_LEGACY],
+		logger:     log.StandardLogger(),
+		metrics:    &metrics{},
+		selfID:     selfContainerID(),
+	}
+	p.policyDecisions = p.metrics.counter("log_pilot_policy_decisions_total",
+		"Logs allowed, rewritten or rejected by tenant policies.", "tenant", "action")
+	p.invalidLogs = p.metrics.counter("log_pilot_invalid_logs_total",
+		"Log declarations left out of containers for their errors.")
+	for format, converter := range converters {
+		p.converters[format] = converter
+	}
+
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
 	}
-	return p.watch()
-}
 
-func New(tplStr string, baseDir string) (*Pilot, error) {
-	tpl, err := template.New("pilot").Parse(tplStr)
-	if err != nil {
-		return nil, err
+	if p.enrichers == nil {
+		p.enrichers = defaultEnrichers()
 	}
 
-	if os.Getenv("DOCKER_API_VERSION") == "" {
-		os.Setenv("DOCKER_API_VERSION", "1.23")
+	if p.kubeConfig != nil {
+		kube, err := newKubeClient(p.kubeConfig, p.nodeName)
+		if err != nil {
+			return nil, err
+		}
+		p.kube = kube
+		p.enrichers = append([]Enricher{&kubernetesEnricher{kube: kube, nodeName: p.nodeName}}, p.enrichers...)
 	}
 
-	client, err := client.NewEnvClient()
-	if err != nil {
-		return nil, err
+	if p.notifyConfig != nil {
+		notifier, err := newNotifier(p.notifyConfig, p.kube, p.nodeName, p.logger, p.metrics)
+		if err != nil {
+			return nil, err
+		}
+		p.notifier = notifier
+	}
+
+	if p.dockerClient == nil {
+		client, err := newEnvDockerClient()
+		if err != nil {
+			return nil, err
+		}
+		p.dockerClient = client
+	}
+
+	name := p.backend
+	if p.piloter != nil {
+		name = p.piloter.Name()
+	}
+	if p.agentConfig == "" {
+		p.agentConfig = FILEBEAT_CONFIG
+		if name == PILOT_FLUENTD {
+			p.agentConfig = FLUENTD_CONFIG
+		}
+	}
+	if p.registryFile == "" {
+		p.registryFile = FILEBEAT_REGISTRY_FILE
+	}
+
+	if p.piloter == nil {
+		switch p.backend {
+		case PILOT_FLUENTD:
+			p.piloter, _ = NewFluentdPiloter(p.confDir, p.agentConfig, p.logger)
+		default:
+			p.piloter, _ = NewFilebeatPiloter(p.base, p.confDir, p.agentConfig, p.registryFile, p.logger)
+		}
 	}
 
-	piloter, _ := NewFilebeatPiloter(baseDir)
+	// filebeat inputs are rendered without template by default
+	if p.tpl == nil && p.piloter.Name() != PILOT_FILEBEAT {
+		return nil, fmt.Errorf("template is required by %s", p.piloter.Name())
+	}
 
-	if os.Getenv(ENV_PILOT_TYPE) == PILOT_FLUENTD {
-		piloter, _ = NewFluentdPiloter()
+	if err := p.checkOutputs(); err != nil {
+		return nil, err
 	}
+	return p, nil
+}
+
+// Backend is the name of the log agent, filebeat or fluentd.
+func (p *Pilot) Backend() string {
+	return p.piloter.Name()
+}
 
-	logPrefix := []string{"aliyun"}
-	if os.Getenv(ENV_PILOT_LOG_PREFIX) != "" {
-		envLogPrefix := os.Getenv(ENV_PILOT_LOG_PREFIX)
-		logPrefix = strings.Split(envLogPrefix, ",")
+// CreateAgentCfg writes the main config of the log agent, which includes the
+// configs of containers and sends logs to the output of the pilot.
+func (p *Pilot) CreateAgentCfg() error {
+	if p.piloter.Name() == PILOT_FLUENTD {
+		return CreateFluentdCfg(p.agentConfig, p.piloter.ConfHome(), p.output)
 	}
+	return CreateFileBeatCfg(p.agentConfig, p.piloter.ConfHome(), p.registryFile, p.output)
+}
 
-	createSymlink := os.Getenv(ENV_PILOT_CREATE_SYMLINK) == "true"
-	return &Pilot{
-		dockerClient:  client,
-		tpl:           tpl,
-		base:          baseDir,
-		reloadChan:    make(chan bool),
-		piloter:       piloter,
-		logPrefix:     logPrefix,
-		createSymlink: createSymlink,
-	}, nil
+// renderAgentCfg renders the main config of the log agent, without writing it.
+func (p *Pilot) renderAgentCfg() (string, error) {
+	if p.piloter.Name() == PILOT_FLUENTD {
+		return RenderFluentdCfg(p.piloter.ConfHome(), p.output)
+	}
+	return RenderFileBeatCfg(p.piloter.ConfHome(), p.registryFile, p.output)
 }
 
-func (p *Pilot) watch() error {
+// Run collects logs of running containers, starts the log agent and follows
+// docker events until ctx is done. The log agent is stopped on return.
+func (p *Pilot) Run(ctx context.Context) error {
+	go p.notifier.run(ctx)
 	if err := p.processAllContainers(); err != nil {
 		return err
 	}
@@ -134,11 +258,16 @@ func (p *Pilot) watch() error {
 	if err != nil && ERR_ALREADY_STARTED != err.Error() {
 		return err
 	}
+	defer p.piloter.Stop()
+	defer p.stopRemovals()
 
 	p.lastReload = time.Now()
-	go p.doReload()
+	go p.doReload(ctx)
+
+	// containers are planned again in the event loop only
+	recheck := time.NewTicker(HOST_PATHS_RECHECK_INTERVAL)
+	defer recheck.Stop()
 
-	ctx := context.Background()
 	filter := filters.NewArgs()
 	filter.Add("type", "container")
 
@@ -148,12 +277,19 @@ func (p *Pilot) watch() error {
 	msgs, errs := p.client().Events(ctx, options)
 	for {
 		select {
+		case <-ctx.Done():
+			return nil
 		case msg := <-msgs:
 			if err := p.processEvent(msg); err != nil {
-				log.Errorf("fail to process event: %v,  %v", msg, err)
+				p.logger.Errorf("fail to process event: %v,  %v", msg, err)
 			}
+		case <-recheck.C:
+			p.recheckHostPaths()
 		case err := <-errs:
-			log.Warnf("error: %v", err)
+			if ctx.Err() != nil {
+				return nil
+			}
+			p.logger.Warnf("error: %v", err)
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
 				return nil
 			} else {
@@ -174,10 +310,28 @@ type LogConfig struct {
 	Target       string
 	EstimateTime bool
 	Stdout       bool
+	// Stream of a stdout log: all, stdout or stderr
+	Stream string
+	// Partial joins lines docker splits every 16KB, up to MaxBytes
+	Partial  bool
+	MaxBytes int64
+	// Multiline is the pattern of the first lines of events, other lines are
+	// appended to the event before them. Lines are events when it's empty.
+	Multiline string
+	// Paths are the host path patterns of the log, * and ** included.
+	// HostDir, File and ContainerDir are those of the first one.
+	Paths []string
+	Tail  TailOptions
+	// Output is the named output of the log, "" for the output of the agent.
+	// OutputType is the type of that output, set when rendering.
+	Output     string
+	OutputType string
+	// Destinations are the outputs of a log sent to several
+	Destinations []LogDestination
 }
 
 func (p *Pilot) cleanConfigs() error {
-	confDir := fmt.Sprintf(p.piloter.ConfHome())
+	confDir := p.piloter.ConfHome()
 	d, err := os.Open(confDir)
 	if err != nil {
 		return err
@@ -218,6 +372,9 @@ func (p *Pilot) processAllContainers() error {
 	if err := p.cleanConfigs(); err != nil {
 		return err
 	}
+	if err := p.writeOutputs(); err != nil {
+		return err
+	}
 
 	containerIDs := make(map[string]string, 0)
 	for _, c := range containers {
@@ -232,7 +389,7 @@ func (p *Pilot) processAllContainers() error {
 			return err
 		}
 		if err = p.newContainer(&containerJSON); err != nil {
-			log.Errorf("fail to process container %s: %v", containerJSON.Name, err)
+			p.logger.Errorf("fail to process container %s: %v", containerJSON.Name, err)
 		}
 	}
 	return p.processAllVolumeSymlink(containerIDs)
@@ -255,13 +412,13 @@ func (p *Pilot) listAllSymlinkContainer() map[string]string {
 		return containerIDs
 	}
 
-	projects := listSubDirectory(linkBaseDir)
+	projects := p.listSubDirectory(linkBaseDir)
 	for _, project := range projects {
 		projectPath := path.Join(linkBaseDir, project)
-		services := listSubDirectory(projectPath)
+		services := p.listSubDirectory(projectPath)
 		for _, service := range services {
 			servicePath := path.Join(projectPath, service)
-			containers := listSubDirectory(servicePath)
+			containers := p.listSubDirectory(servicePath)
 			for _, containerID := range containers {
 				if _, ok := containerIDs[containerID]; !ok {
 					containerIDs[containerID] = containerID
@@ -272,7 +429,7 @@ func (p *Pilot) listAllSymlinkContainer() map[string]string {
 	return containerIDs
 }
 
-func listSubDirectory(path string) []string {
+func (p *Pilot) listSubDirectory(path string) []string {
 	subdirs := make([]string, 0)
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return subdirs
@@ -280,7 +437,7 @@ func listSubDirectory(path string) []string {
 
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
-		log.Warnf("read %s error: %v", path, err)
+		p.logger.Warnf("read %s error: %v", path, err)
 		return subdirs
 	}
 
@@ -299,7 +456,7 @@ func putIfNotEmpty(store map[string]string, key, value string) {
 	store[key] = value
 }
 
-func container(containerJSON *types.ContainerJSON) map[string]string {
+func (p *Pilot) container(containerJSON *types.ContainerJSON) map[string]string {
 	labels := containerJSON.Config.Labels
 	c := make(map[string]string)
 	putIfNotEmpty(c, "docker_app", labels[LABEL_PROJECT])
@@ -309,7 +466,7 @@ func container(containerJSON *types.ContainerJSON) map[string]string {
 	putIfNotEmpty(c, "k8s_pod", labels[LABEL_POD])
 	putIfNotEmpty(c, "k8s_pod_namespace", labels[LABEL_K8S_POD_NAMESPACE])
 	putIfNotEmpty(c, "k8s_container_name", labels[LABEL_K8S_CONTAINER_NAME])
-	putIfNotEmpty(c, "k8s_node_name", NODE_NAME)
+	putIfNotEmpty(c, "k8s_node_name", p.nodeName)
 
 	putIfNotEmpty(c, "docker_container_name", strings.TrimPrefix(containerJSON.Name, "/"))
 	putIfNotEmpty(c, "docker_container_created", containerJSON.Created)
@@ -319,15 +476,45 @@ func container(containerJSON *types.ContainerJSON) map[string]string {
 	putIfNotEmpty(c, "rancher_stack", labels[LABEL_RANCHER_STACK])
 	putIfNotEmpty(c, "rancher_stack_service", labels[LABEL_RANCHER_STACK_SERVICE])
 
-	extension(c, containerJSON)
+	p.enrich(c, containerJSON)
 	return c
 }
 
-func (p *Pilot) newContainer(containerJSON *types.ContainerJSON) error {
+// labelMatch records a log declaration label and the prefix it matched.
+type labelMatch struct {
+	Key    string
+	Value  string
+	Prefix string
+	Env    string
+	// Default is set on labels of the default stdout collection
+	Default bool
+}
+
+// containerPlan is what newContainer would do for a container,
+// computed without touching the host filesystem.
+type containerPlan struct {
+	id         string
+	name       string
+	labels     map[string]string
+	matches    []labelMatch
+	mounts     map[string]types.MountPoint
+	rootfs     string
+	metadata   map[string]string
+	logConfigs []*LogConfig
+	symlinks   map[string]string
+	config     string
+	// skipped is why the selector leaves the container out, if it does
+	skipped string
+	// decisions of the tenant policy on the logs
+	decisions []policyDecision
+	// logErrors are the errors of the invalid logs, left out
+	logErrors logErrors
+}
+
+func (p *Pilot) planContainer(containerJSON *types.ContainerJSON) (*containerPlan, error) {
 	id := containerJSON.ID
 	jsonLogPath := containerJSON.LogPath
 	mounts := containerJSON.Mounts
-	labels := containerJSON.Config.Labels
 	env := containerJSON.Config.Env
 
 	//logConfig.containerDir match types.mountPoint
@@ -339,8 +526,19 @@ func (p *Pilot) newContainer(containerJSON *types.ContainerJSON) error {
 	  查找：从containerdir开始查找最近的一层挂载
 	*/
 
-	container := container(containerJSON)
+	plan := &containerPlan{
+		id:       id,
+		name:     strings.TrimPrefix(containerJSON.Name, "/"),
+		labels:   make(map[string]string),
+		mounts:   mountsOf(mounts),
+		metadata: p.container(containerJSON),
+		rootfs:   rootfsOf(containerJSON.GraphDriver),
+	}
+	for k, v := range containerJSON.Config.Labels {
+		plan.labels[k] = v
+	}
 
+	envLabels := make(map[string]string)
 	for _, e := range env {
 		for _, prefix := range p.logPrefix {
 			serviceLogs := fmt.Sprintf(ENV_SERVICE_LOGS_TEMPL, prefix)
@@ -351,35 +549,96 @@ func (p *Pilot) newContainer(containerJSON *types.ContainerJSON) error {
 			envLabel := strings.SplitN(e, "=", 2)
 			if len(envLabel) == 2 {
 				labelKey := strings.Replace(envLabel[0], "_", ".", -1)
-				labels[labelKey] = envLabel[1]
+				plan.labels[labelKey] = envLabel[1]
+				envLabels[labelKey] = envLabel[0]
 			}
 		}
 	}
 
-	logConfigs, err := p.getLogConfigs(jsonLogPath, mounts, labels)
-	if err != nil {
-		return err
+	for k, v := range plan.labels {
+		for _, prefix := range p.logPrefix {
+			if strings.HasPrefix(k, fmt.Sprintf(LABEL_SERVICE_LOGS_TEMPL, prefix)) {
+				plan.matches = append(plan.matches, labelMatch{Key: k, Value: v, Prefix: prefix, Env: envLabels[k]})
+			}
+		}
+	}
+	if len(plan.matches) == 0 {
+		for k, v := range p.defaultLabels(containerJSON) {
+			plan.labels[k] = v
+			plan.matches = append(plan.matches, labelMatch{Key: k, Value: v, Prefix: p.logPrefix[0], Default: true})
+		}
 	}
+	sort.Slice(plan.matches, func(i, j int) bool { return plan.matches[i].Key < plan.matches[j].Key })
+
+	if plan.skipped = p.selector.skip(containerJSON); plan.skipped != "" {
+		return plan, nil
+	}
+
+	logConfigs, err := p.getLogConfigs(jsonLogPath, mounts, plan.rootfs, plan.labels)
+	if logConfigs, err = p.dropInvalid(plan, logConfigs, err); err != nil {
+		return plan, err
+	}
+	err = p.expandValues(containerJSON, plan.metadata, logConfigs)
+	if logConfigs, err = p.dropInvalid(plan, logConfigs, err); err != nil {
+		return plan, err
+	}
+	plan.decisions, err = p.tenants.apply(containerJSON, logConfigs)
+	if logConfigs, err = p.dropInvalid(plan, logConfigs, err); err != nil {
+		return plan, err
+	}
+	plan.logConfigs = logConfigs
 
 	if len(logConfigs) == 0 {
-		log.Debugf("%s has not log config, skip", id)
-		return nil
+		return plan, nil
 	}
 
-	// create symlink
-	p.createVolumeSymlink(containerJSON)
+	plan.symlinks = p.volumeSymlinks(containerJSON)
 
-	//pilot.findMounts(logConfigs, jsonLogPath, mounts)
 	//生成配置
-	logConfig, err := p.render(id, container, logConfigs)
+	plan.config, err = p.render(id, plan.metadata, logConfigs)
+	if err != nil {
+		return plan, err
+	}
+	return plan, nil
+}
+
+func (p *Pilot) newContainer(containerJSON *types.ContainerJSON) error {
+	plan, err := p.planContainer(containerJSON)
+	for _, decision := range plan.decisions {
+		p.policyDecisions.inc(decision.tenant, decision.action)
+		if decision.action != "allowed" {
+			p.logger.Warnf("%s: %s", plan.id, decision)
+		}
+	}
+	for _, logErr := range plan.logErrors {
+		p.invalidLogs.inc()
+		p.logger.Warnf("%s: invalid log %s: %v", plan.id, logErr.log, logErr)
+	}
+	p.notifier.notify(containerJSON, plan, err)
 	if err != nil {
 		return err
 	}
+
+	if plan.skipped != "" {
+		if len(plan.matches) > 0 {
+			p.logger.Infof("%s is not managed by pilot, skip: %s", plan.id, plan.skipped)
+		}
+		return nil
+	}
+	if len(plan.logConfigs) == 0 {
+		p.logger.Debugf("%s has not log config, skip", plan.id)
+		return nil
+	}
+
+	// create symlink
+	p.createVolumeSymlink(plan.symlinks)
+
 	//TODO validate config before save
 	//log.Debugf("container %s log config: %s", id, logConfig)
-	if err = ioutil.WriteFile(p.piloter.ConfPathOf(id), []byte(logConfig), os.FileMode(0644)); err != nil {
+	if err = ioutil.WriteFile(p.piloter.ConfPathOf(plan.id), []byte(plan.config), os.FileMode(0644)); err != nil {
 		return err
 	}
+	p.watchHostPaths(plan.id, plan.logConfigs)
 
 	p.tryReload()
 	return nil
@@ -389,39 +648,64 @@ func (p *Pilot) tryReload() {
 	select {
 	case p.reloadChan <- true:
 	default:
-		log.Info("Another load is pending")
+		p.logger.Info("Another load is pending")
 	}
 }
 
-func (p *Pilot) doReload() {
-	log.Info("Reload gorouting is ready")
+func (p *Pilot) doReload(ctx context.Context) {
+	p.logger.Info("Reload gorouting is ready")
 	for {
-		<-p.reloadChan
-		p.reload()
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.reloadChan:
+			p.reload()
+		}
 	}
 }
 
 func (p *Pilot) delContainer(id string) error {
 	p.removeVolumeSymlink(id)
+	p.notifier.forget(id)
+	p.watchHostPaths(id, nil)
 
 	// refactor in the future
 	if p.piloter.Name() == PILOT_FLUENTD {
 		clean := func() {
-			log.Infof("Try removing log config %s", id)
+			p.removalsMutex.Lock()
+			delete(p.removals, id)
+			p.removalsMutex.Unlock()
+			p.logger.Infof("Try removing log config %s", id)
 			if err := os.Remove(p.piloter.ConfPathOf(id)); err != nil {
-				log.Warnf("removing %s log config failure", id)
+				p.logger.Warnf("removing %s log config failure", id)
 				return
 			}
 			p.tryReload()
 		}
-		time.AfterFunc(15*time.Minute, clean)
+		p.removalsMutex.Lock()
+		defer p.removalsMutex.Unlock()
+		if timer, ok := p.removals[id]; ok {
+			timer.Stop()
+		}
+		p.removals[id] = time.AfterFunc(15*time.Minute, clean)
 		return nil
 	} else {
 		return p.piloter.OnDestroyEvent(id)
 	}
 }
 
-func (p *Pilot) client() *client.Client {
+// stopRemovals cancels the pending removals of configs, the log agent they
+// would reload is stopped.
+func (p *Pilot) stopRemovals() {
+	p.removalsMutex.Lock()
+	defer p.removalsMutex.Unlock()
+	for id, timer := range p.removals {
+		timer.Stop()
+		delete(p.removals, id)
+	}
+}
+
+func (p *Pilot) client() DockerClient {
 	return p.dockerClient
 }
 
@@ -430,9 +714,9 @@ func (p *Pilot) processEvent(msg events.Message) error {
 	ctx := context.Background()
 	switch msg.Action {
 	case "start", "restart":
-		log.Debugf("Process container start event: %s", containerId)
+		p.logger.Debugf("Process container start event: %s", containerId)
 		if p.exists(containerId) {
-			log.Debugf("%s is already exists.", containerId)
+			p.logger.Debugf("%s is already exists.", containerId)
 			return nil
 		}
 		containerJSON, err := p.client().ContainerInspect(ctx, containerId)
@@ -441,64 +725,115 @@ func (p *Pilot) processEvent(msg events.Message) error {
 		}
 		return p.newContainer(&containerJSON)
 	case "destroy":
-		log.Debugf("Process container destory event: %s", containerId)
+		p.logger.Debugf("Process container destory event: %s", containerId)
 		err := p.delContainer(containerId)
 		if err != nil {
-			log.Warnf("Process container destory event error: %s, %s", containerId, err.Error())
+			p.logger.Warnf("Process container destory event error: %s, %s", containerId, err.Error())
 		}
 	}
 	return nil
 }
 
-func (p *Pilot) hostDirOf(path string, mounts map[string]types.MountPoint) string {
-	confPath := path
-	for {
-		if point, ok := mounts[path]; ok {
-			if confPath == path {
-				return point.Source
-			} else {
-				relPath, err := filepath.Rel(path, confPath)
-				if err != nil {
-					panic(err)
-				}
-				return fmt.Sprintf("%s/%s", point.Source, relPath)
-			}
-		}
-		path = filepath.Dir(path)
-		if path == "/" || path == "." {
-			break
-		}
-	}
-	return ""
-}
-
+// parseTags reads the tags of a log, see splitTags for their syntax.
 func (p *Pilot) parseTags(tags string) (map[string]string, error) {
 	tagMap := make(map[string]string)
-	if tags == "" {
+	if strings.TrimSpace(tags) == "" {
 		return tagMap, nil
 	}
 
-	kvArray := strings.Split(tags, ",")
-	for _, kv := range kvArray {
-		arr := strings.Split(kv, "=")
-		if len(arr) != 2 {
-			return nil, fmt.Errorf("%s is not a valid k=v format", kv)
+	parsed, err := splitTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range parsed {
+		key, value := tag.key, tag.value
+		if err := checkValue(key); err != nil {
+			return nil, fmt.Errorf("tag %q: %v", key, err)
+		}
+		if err := checkValue(value); err != nil {
+			return nil, fmt.Errorf("tag %s: %v", key, err)
 		}
-		key := strings.TrimSpace(arr[0])
-		value := strings.TrimSpace(arr[1])
-		if key == "" || value == "" {
-			return nil, fmt.Errorf("%s is not a valid k=v format", kv)
+		if isTemplate(value) {
+			if err := checkTemplate(value); err != nil {
+				return nil, fmt.Errorf("tag %s: %v", key, err)
+			}
 		}
 		tagMap[key] = value
 	}
 	return tagMap, nil
 }
 
-func (p *Pilot) parseLogConfig(name string, info *LogInfoNode, jsonLogPath string, mounts map[string]types.MountPoint) (*LogConfig, error) {
+// checkValue rejects the control characters of a label value, which no
+// config can hold as is.
+func checkValue(value string) error {
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("control character %U is not allowed", r)
+		}
+	}
+	return nil
+}
+
+// validName matches the names of logs and targets, which the configs use
+// unquoted in tags, file names and index names.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-]*$`)
+
+// logKeys are the keys of a log declaration, true for the ones having
+// children: format properties are checked by their converter and outputs
+// overrides by parseDestinations.
+var logKeys = map[string]bool{
+	"tags":           false,
+	"target":         false,
+	"format":         true,
+	"stream":         false,
+	"partial":        false,
+	"max_bytes":      false,
+	"multiline":      false,
+	"output":         false,
+	"outputs":        true,
+	"start":          false,
+	"encoding":       false,
+	"ignore_older":   false,
+	"close_inactive": false,
+	"scan_frequency": false,
+	"rotate_wait":    false,
+	"exclude":        false,
+}
+
+// checkKeys rejects the keys of a node out of known, which would be ignored
+// otherwise, like a misspelled formt.pattern. Keys are reported after prefix.
+func checkKeys(name string, prefix string, node *LogInfoNode, known map[string]bool) error {
+	var unknown []string
+	for key, child := range node.children {
+		if hasChildren, ok := known[key]; !ok {
+			unknown = append(unknown, child.keys(prefix+key)...)
+		} else if !hasChildren && len(child.children) > 0 {
+			for childKey, grandChild := range child.children {
+				unknown = append(unknown, grandChild.keys(prefix+key+"."+childKey)...)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("in log %s: unknown keys %s", name, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func (p *Pilot) parseLogConfig(name string, info *LogInfoNode, jsonLogPath string, mounts map[string]types.MountPoint, rootfs string, table mountTable) (*LogConfig, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid log name %q: only letters, digits, _, . and - are allowed", name)
+	}
+	if err := checkKeys(name, "", info, logKeys); err != nil {
+		return nil, err
+	}
 	path := strings.TrimSpace(info.value)
 	if path == "" {
 		return nil, fmt.Errorf("path for %s is empty", name)
 	}
+	if err := checkValue(path); err != nil {
+		return nil, fmt.Errorf("path for %s: %v", name, err)
+	}
 
 	tags := info.get("tags")
 	tagMap, err := p.parseTags(tags)
@@ -507,6 +842,13 @@ func (p *Pilot) parseLogConfig(name string, info *LogInfoNode, jsonLogPath strin
 	}
 
 	target := info.get("target")
+	if isTemplate(target) {
+		if err := checkTemplate(target); err != nil {
+			return nil, fmt.Errorf("in log %s: target: %v", name, err)
+		}
+	} else if target != "" && !validName.MatchString(target) {
+		return nil, fmt.Errorf("in log %s: invalid target %q: only letters, digits, _, . and - are allowed", name, target)
+	}
 
 	// pol平台index名称不能被强制制定
 	// 由logstash生成
@@ -527,15 +869,25 @@ func (p *Pilot) parseLogConfig(name string, info *LogInfoNode, jsonLogPath strin
 		}
 	}
 
+	output, destinations, err := p.parseOutput(name, info, tagMap)
+	if err != nil {
+		return nil, err
+	}
+
 	format := info.children["format"]
 	if format == nil || format.value == "none" {
 		format = newLogInfoNode("nonex")
 	}
 
-	formatConfig, err := Convert(format)
+	formatConfig, err := p.convert(format)
 	if err != nil {
 		return nil, fmt.Errorf("in log %s: format error: %v", name, err)
 	}
+	for key, value := range formatConfig {
+		if err := checkValue(value); err != nil {
+			return nil, fmt.Errorf("in log %s: format %s: %v", name, key, err)
+		}
+	}
 
 	//特殊处理regex
 	if format.value == "regexp" {
@@ -543,48 +895,118 @@ func (p *Pilot) parseLogConfig(name string, info *LogInfoNode, jsonLogPath strin
 		delete(formatConfig, "pattern")
 	}
 
-	if path == "stdout" {
+	stream := info.get("stream")
+	switch path {
+	case STREAM_STDOUT:
+		if stream == "" {
+			stream = STREAM_ALL
+		}
+	case STREAM_STDERR:
+		if stream != "" && stream != STREAM_STDERR {
+			return nil, fmt.Errorf("in log %s: stream %s conflicts with %s", name, stream, path)
+		}
+		stream = STREAM_STDERR
+	default:
+		if stream != "" {
+			return nil, fmt.Errorf("in log %s: stream is only supported for stdout", name)
+		}
+	}
+	if stream != "" && stream != STREAM_ALL && stream != STREAM_STDOUT && stream != STREAM_STDERR {
+		return nil, fmt.Errorf("in log %s: stream must be all, stdout or stderr, not %s", name, stream)
+	}
+	if stream == STREAM_STDOUT || stream == STREAM_STDERR {
+		if err := p.requireFilebeat6(name, "stream "+stream); err != nil {
+			return nil, err
+		}
+	}
+
+	partial, maxBytes, err := p.parsePartial(name, path, info)
+	if err != nil {
+		return nil, err
+	}
+
+	multiline, err := p.parseMultiline(name, info)
+	if err != nil {
+		return nil, err
+	}
+
+	tail, err := p.parseTail(name, path == STREAM_STDOUT || path == STREAM_STDERR, info)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == STREAM_STDOUT || path == STREAM_STDERR {
 		logFile := filepath.Base(jsonLogPath)
 		if p.piloter.Name() == PILOT_FILEBEAT {
 			logFile = logFile + "*"
 		}
 
+		hostDir := filepath.Join(p.base, filepath.Dir(jsonLogPath))
 		return &LogConfig{
 			Name:         name,
-			HostDir:      filepath.Join(p.base, filepath.Dir(jsonLogPath)),
+			HostDir:      hostDir,
 			File:         logFile,
+			Paths:        []string{filepath.Join(hostDir, logFile)},
+			Tail:         tail,
 			Format:       format.value,
 			Tags:         tagMap,
 			FormatConfig: map[string]string{"time_format": "%Y-%m-%dT%H:%M:%S.%NZ"},
 			Target:       target,
 			EstimateTime: false,
 			Stdout:       true,
+			Stream:       stream,
+			Partial:      partial,
+			MaxBytes:     maxBytes,
+			Multiline:    multiline,
+			Output:       output,
+			Destinations: destinations,
 		}, nil
 	}
 
-	if !filepath.IsAbs(path) {
-		return nil, fmt.Errorf("%s must be absolute path, for %s", path, name)
+	paths, err := parsePaths(path)
+	if err != nil {
+		return nil, fmt.Errorf("in log %s: %v", name, err)
 	}
-	containerDir := filepath.Dir(path)
-	file := filepath.Base(path)
-	if file == "" {
-		return nil, fmt.Errorf("%s must be a file path, not directory, for %s", path, name)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("path for %s is empty", name)
 	}
-
-	hostDir := p.hostDirOf(containerDir, mounts)
-	if hostDir == "" {
-		return nil, fmt.Errorf("in log %s: %s is not mount on host", name, path)
+	for _, path := range paths {
+		if strings.Contains(path, "**") {
+			if err := p.requireFilebeat6(name, "** in "+path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var hostPaths []string
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("%s must be absolute path, for %s", path, name)
+		}
+		hostPath, err := p.hostPatternOf(path, mounts, rootfs, table)
+		if err != nil {
+			return nil, fmt.Errorf("in log %s: %v", name, err)
+		}
+		real, err := p.checkHostPath(hostPath)
+		if err != nil {
+			return nil, fmt.Errorf("in log %s: %s rejected: %v", name, path, err)
+		}
+		hostPaths = append(hostPaths, filepath.Join(p.base, real))
 	}
 
 	cfg := &LogConfig{
 		Name:         name,
-		ContainerDir: containerDir,
+		ContainerDir: filepath.Dir(paths[0]),
 		Format:       format.value,
-		File:         file,
+		File:         filepath.Base(hostPaths[0]),
+		Paths:        hostPaths,
+		Tail:         tail,
 		Tags:         tagMap,
-		HostDir:      filepath.Join(p.base, hostDir),
+		HostDir:      filepath.Dir(hostPaths[0]),
 		FormatConfig: formatConfig,
 		Target:       target,
+		Multiline:    multiline,
+		Output:       output,
+		Destinations: destinations,
 	}
 	if formatConfig["time_key"] == "" {
 		cfg.EstimateTime = true
@@ -593,6 +1015,70 @@ func (p *Pilot) parseLogConfig(name string, info *LogInfoNode, jsonLogPath strin
 	return cfg, nil
 }
 
+// parseMultiline reads the pattern of the first lines of multiline events,
+// which only filebeat joins.
+func (p *Pilot) parseMultiline(name string, info *LogInfoNode) (string, error) {
+	pattern := info.get("multiline")
+	if pattern == "" {
+		return "", nil
+	}
+	if p.piloter.Name() != PILOT_FILEBEAT {
+		return "", fmt.Errorf("in log %s: multiline is only supported by filebeat", name)
+	}
+	if err := checkValue(pattern); err != nil {
+		return "", fmt.Errorf("in log %s: multiline: %v", name, err)
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return "", fmt.Errorf("in log %s: multiline: %v", name, err)
+	}
+	return pattern, nil
+}
+
+// parsePartial reads the partial message options of a stdout log.
+func (p *Pilot) parsePartial(name string, path string, info *LogInfoNode) (bool, int64, error) {
+	partial, maxBytes := info.get("partial"), info.get("max_bytes")
+	if path != STREAM_STDOUT && path != STREAM_STDERR {
+		if partial != "" || maxBytes != "" {
+			return false, 0, fmt.Errorf("in log %s: partial and max_bytes are only supported for stdout", name)
+		}
+		return false, 0, nil
+	}
+
+	// partial messages are joined by default unless filebeat can't
+	unsupported := p.requireFilebeat6(name, "partial and max_bytes")
+	enabled := unsupported == nil
+	if partial != "" {
+		var err error
+		if enabled, err = strconv.ParseBool(partial); err != nil {
+			return false, 0, fmt.Errorf("in log %s: invalid partial %s", name, partial)
+		}
+	}
+	size := int64(DEFAULT_MAX_BYTES)
+	if maxBytes != "" {
+		var err error
+		if size, err = strconv.ParseInt(maxBytes, 10, 64); err != nil || size <= 0 {
+			return false, 0, fmt.Errorf("in log %s: max_bytes must be a positive number of bytes, not %s", name, maxBytes)
+		}
+	}
+	if unsupported != nil && (enabled || maxBytes != "") {
+		return false, 0, unsupported
+	}
+	return enabled, size, nil
+}
+
+// requireFilebeat6 fails when the feature of a log needs a filebeat newer
+// than the one known, docker-json and ** are only supported since 6.0.
+func (p *Pilot) requireFilebeat6(name string, feature string) error {
+	if p.piloter.Name() != PILOT_FILEBEAT || p.filebeatVersion == "" {
+		return nil
+	}
+	major, err := strconv.Atoi(strings.SplitN(p.filebeatVersion, ".", 2)[0])
+	if err != nil || major >= 6 {
+		return nil
+	}
+	return fmt.Errorf("in log %s: filebeat %s doesn't support %s, 6.0 or later is required", name, p.filebeatVersion, feature)
+}
+
 type LogInfoNode struct {
 	value    string
 	children map[string]*LogInfoNode
@@ -612,7 +1098,7 @@ func (node *LogInfoNode) insert(keys []string, value string) error {
 	key := keys[0]
 	if len(keys) > 1 {
 		if child, ok := node.children[key]; ok {
-			child.insert(keys[1:], value)
+			child.insertPath(keys[1:], value)
 		} else {
 			return fmt.Errorf("%s has no parent node", key)
 		}
@@ -623,6 +1109,32 @@ func (node *LogInfoNode) insert(keys []string, value string) error {
 	return nil
 }
 
+// insertPath inserts a value under a log, creating the missing nodes on the
+// way, like outputs.siem of outputs.siem.target.
+func (node *LogInfoNode) insertPath(keys []string, value string) {
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := node.children[key]
+		if !ok {
+			child = newLogInfoNode("")
+			node.children[key] = child
+		}
+		node = child
+	}
+	node.children[keys[len(keys)-1]] = newLogInfoNode(value)
+}
+
+// keys returns the keys set from a node, named after prefix.
+func (node *LogInfoNode) keys(prefix string) []string {
+	var keys []string
+	if node.value != "" || len(node.children) == 0 {
+		keys = append(keys, prefix)
+	}
+	for key, child := range node.children {
+		keys = append(keys, child.keys(prefix+"."+key)...)
+	}
+	return keys
+}
+
 func (node *LogInfoNode) get(key string) string {
 	if child, ok := node.children[key]; ok {
 		return child.value
@@ -630,13 +1142,14 @@ func (node *LogInfoNode) get(key string) string {
 	return ""
 }
 
-func (p *Pilot) getLogConfigs(jsonLogPath string, mounts []types.MountPoint, labels map[string]string) ([]*LogConfig, error) {
+// getLogConfigs parses the logs declared by labels. The error of invalid
+// logs is a logErrors, returned along with the valid logs.
+func (p *Pilot) getLogConfigs(jsonLogPath string, mounts []types.MountPoint, rootfs string, labels map[string]string) ([]*LogConfig, error) {
 	var ret []*LogConfig
+	var errs logErrors
 
-	mountsMap := make(map[string]types.MountPoint)
-	for _, mount := range mounts {
-		mountsMap[mount.Destination] = mount
-	}
+	mountsMap := mountsOf(mounts)
+	table := p.hostMounts()
 
 	var labelNames []string
 	//sort keys
@@ -654,20 +1167,28 @@ func (p *Pilot) getLogConfigs(jsonLogPath string, mounts []types.MountPoint, lab
 			}
 
 			logLabel := strings.TrimPrefix(k, serviceLogs)
-			if err := root.insert(strings.Split(logLabel, "."), labels[k]); err != nil {
-				return nil, err
+			keys := strings.Split(logLabel, ".")
+			if err := root.insert(keys, labels[k]); err != nil {
+				errs = errs.add(keys[0], fmt.Errorf("in log %s: %s is set but %s%s is not", keys[0], k, serviceLogs, keys[0]))
 			}
 		}
 	}
 
-	for name, node := range root.children {
-		logConfig, err := p.parseLogConfig(name, node, jsonLogPath, mountsMap)
+	var names []string
+	for name := range root.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logConfig, err := p.parseLogConfig(name, root.children[name], jsonLogPath, mountsMap, rootfs, table)
 		if err != nil {
-			return nil, err
+			errs = errs.add(name, err)
+			continue
 		}
 		ret = append(ret, logConfig)
 	}
-	return ret, nil
+	sort.Slice(errs, func(i, j int) bool { return errs[i].log < errs[j].log })
+	return ret, errs.asError()
 }
 
 func (p *Pilot) exists(containId string) bool {
@@ -679,57 +1200,85 @@ func (p *Pilot) exists(containId string) bool {
 
 func (p *Pilot) render(containerId string, container map[string]string, configList []*LogConfig) (string, error) {
 	for _, config := range configList {
-		log.Infof("logs: %s = %v", containerId, config)
+		p.logger.Infof("logs: %s = %v", containerId, config)
+	}
+
+	// name fields after the schema, configs are copied to keep tags of the plan
+	schemaConfigs := make([]*LogConfig, 0, len(configList))
+	for _, config := range configList {
+		schemaConfig := *config
+		schemaConfig.Tags = p.schema.tags(config.Tags)
+		schemaConfig.OutputType = p.output
+		if named, ok := p.outputs[config.Output]; ok {
+			schemaConfig.OutputType = named.Type
+		}
+		schemaConfig.Destinations = nil
+		for _, destination := range config.Destinations {
+			destination.Tags = p.schema.tags(destination.Tags)
+			destination.OutputType = p.outputs[destination.Output].Type
+			if destination.Target == "" {
+				destination.Target = config.Target
+			}
+			if destination.Target == "" {
+				destination.Target = config.Name
+			}
+			schemaConfig.Destinations = append(schemaConfig.Destinations, destination)
+		}
+		schemaConfigs = append(schemaConfigs, &schemaConfig)
 	}
 
-	output := os.Getenv(ENV_FLUENTD_OUTPUT)
-	if p.piloter.Name() == PILOT_FILEBEAT {
-		output = os.Getenv(ENV_FILEBEAT_OUTPUT)
+	metadata := p.schema.metadata(container)
+	if p.tpl == nil {
+		return renderFilebeatInputs(schemaConfigs, metadata)
 	}
 
 	var buf bytes.Buffer
 	context := map[string]interface{}{
 		"containerId": containerId,
-		"configList":  configList,
-		"container":   container,
-		"output":      output,
+		"configList":  schemaConfigs,
+		"container":   metadata,
+		"output":      p.output,
 	}
 
-	log.Debugf("context = %s", spew.Sdump(context))
+	p.logger.Debugf("context = %s", spew.Sdump(context))
 	if err := p.tpl.Execute(&buf, context); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
 }
 
+// reload waits until 30s after the last reload, which only reload sets once
+// Run started, and reloads the log agent.
 func (p *Pilot) reload() error {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	log.Infof("Reload %s", p.piloter.Name())
+	p.logger.Infof("Reload %s", p.piloter.Name())
 	interval := time.Now().Sub(p.lastReload)
 	time.Sleep(30*time.Second - interval)
-	log.Info("Start reloading")
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.logger.Info("Start reloading")
 	err := p.piloter.Reload()
 	p.lastReload = time.Now()
 	return err
 }
 
-func (p *Pilot) createVolumeSymlink(containerJSON *types.ContainerJSON) error {
+// volumeSymlinks maps the host mount point of every volume of the container
+// to the symlink that should point at it. The dirs of the links come from the
+// compose or swarm labels, which neither enrichers nor schemas change, as
+// removeVolumeSymlink finds links by that layout.
+func (p *Pilot) volumeSymlinks(containerJSON *types.ContainerJSON) map[string]string {
+	symlinks := make(map[string]string, 0)
 	if !p.createSymlink {
-		return nil
+		return symlinks
 	}
 
+	labels := make(map[string]string)
+	putIfNotEmpty(labels, "app", containerJSON.Config.Labels[LABEL_PROJECT])
+	putIfNotEmpty(labels, "app", containerJSON.Config.Labels[LABEL_PROJECT_SWARM_MODE])
+	putIfNotEmpty(labels, "service", containerJSON.Config.Labels[LABEL_SERVICE])
+	putIfNotEmpty(labels, "service", containerJSON.Config.Labels[LABEL_SERVICE_SWARM_MODE])
 	linkBaseDir := path.Join(p.base, SYMLINK_LOGS_BASE)
-	if _, err := os.Stat(linkBaseDir); err != nil && os.IsNotExist(err) {
-		if err := os.MkdirAll(linkBaseDir, 0777); err != nil {
-			log.Errorf("create %s error: %v", linkBaseDir, err)
-		}
-	}
-
-	applicationInfo := container(containerJSON)
-	containerLinkBaseDir := path.Join(linkBaseDir, applicationInfo["docker_app"],
-		applicationInfo["docker_service"], containerJSON.ID)
-	symlinks := make(map[string]string, 0)
+	containerLinkBaseDir := path.Join(linkBaseDir, labels["app"], labels["service"], containerJSON.ID)
 	for _, mountPoint := range containerJSON.Mounts {
 		if mountPoint.Type != mount.TypeVolume {
 			continue
@@ -737,7 +1286,7 @@ func (p *Pilot) createVolumeSymlink(containerJSON *types.ContainerJSON) error {
 
 		volume, err := p.client().VolumeInspect(context.Background(), mountPoint.Name)
 		if err != nil {
-			log.Errorf("inspect volume %s error: %v", mountPoint.Name, err)
+			p.logger.Errorf("inspect volume %s error: %v", mountPoint.Name, err)
 			continue
 		}
 
@@ -746,22 +1295,26 @@ func (p *Pilot) createVolumeSymlink(containerJSON *types.ContainerJSON) error {
 			symlinks[volume.Mountpoint] = symlink
 		}
 	}
+	return symlinks
+}
 
-	if len(symlinks) == 0 {
+func (p *Pilot) createVolumeSymlink(symlinks map[string]string) error {
+	if !p.createSymlink || len(symlinks) == 0 {
 		return nil
 	}
 
-	if _, err := os.Stat(containerLinkBaseDir); err != nil && os.IsNotExist(err) {
-		if err := os.MkdirAll(containerLinkBaseDir, 0777); err != nil {
-			log.Errorf("create %s error: %v", containerLinkBaseDir, err)
-			return err
+	for mountPoint, symlink := range symlinks {
+		containerLinkBaseDir := filepath.Dir(symlink)
+		if _, err := os.Stat(containerLinkBaseDir); err != nil && os.IsNotExist(err) {
+			if err := os.MkdirAll(containerLinkBaseDir, 0777); err != nil {
+				p.logger.Errorf("create %s error: %v", containerLinkBaseDir, err)
+				return err
+			}
 		}
-	}
 
-	for mountPoint, symlink := range symlinks {
 		err := os.Symlink(mountPoint, symlink)
 		if err != nil && !os.IsExist(err) {
-			log.Errorf("create symlink %s error: %v", symlink, err)
+			p.logger.Errorf("create symlink %s error: %v", symlink, err)
 		}
 	}
 	return nil
@@ -779,7 +1332,7 @@ func (p *Pilot) removeVolumeSymlink(containerId string) error {
 	}
 	for _, containerLinkDir := range containerLinkDirs {
 		if err := os.RemoveAll(containerLinkDir); err != nil {
-			log.Warnf("remove error: %v", err)
+			p.logger.Warnf("remove error: %v", err)
 		}
 	}
 	return nil
@@ -1,14 +1,14 @@
 package pilot
 
 import (
-	"github.com/docker/docker/api/types"
+	"context"
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
 	"gopkg.in/check.v1"
 	"os"
 	"testing"
-	"github.com/docker/docker/client"
-	"context"
-	"github.com/docker/docker/api/types/filters"
 )
 
 func Test(t *testing.T) {
@@ -22,9 +22,9 @@ type PilotSuite struct{}
 var _ = check.Suite(&PilotSuite{})
 
 func (p *PilotSuite) TestGetLogConfigs(c *check.C) {
-	pilot := &Pilot{}
+	pilot, _ := newTestPilot(c, newFakeDocker())
 	labels := map[string]string{}
-	configs, err := pilot.getLogConfigs("/path/to/json.log", []types.MountPoint{}, labels)
+	configs, err := pilot.getLogConfigs("/path/to/json.log", []types.MountPoint{}, "", labels)
 	c.Assert(err, check.IsNil)
 	c.Assert(configs, check.HasLen, 0)
 
@@ -36,7 +36,7 @@ func (p *PilotSuite) TestGetLogConfigs(c *check.C) {
 	}
 
 	//no mount
-	configs, err = pilot.getLogConfigs("/path/to/json.log", []types.MountPoint{}, labels)
+	configs, err = pilot.getLogConfigs("/path/to/json.log", []types.MountPoint{}, "", labels)
 	c.Assert(err, check.NotNil)
 
 	mounts := []types.MountPoint{
@@ -45,14 +45,15 @@ func (p *PilotSuite) TestGetLogConfigs(c *check.C) {
 			Destination: "/var/log",
 		},
 	}
-	configs, err = pilot.getLogConfigs("/path/to/json.log", mounts, labels)
+	configs, err = pilot.getLogConfigs("/path/to/json.log", mounts, "", labels)
 	c.Assert(err, check.IsNil)
 	c.Assert(configs, check.HasLen, 1)
 	c.Assert(configs[0].Format, check.Equals, "json")
 	c.Assert(configs[0].ContainerDir, check.Equals, "/var/log")
 	c.Assert(configs[0].File, check.Equals, "hello.log")
-	c.Assert(configs[0].Tags, check.HasLen, 2)
-	c.Assert(configs[0].FormatConfig, check.HasLen, 1)
+	// with the default topic and time_key
+	c.Assert(configs[0].Tags, check.HasLen, 3)
+	c.Assert(configs[0].FormatConfig, check.HasLen, 2)
 
 	//Test regex format
 	labels = map[string]string{
@@ -61,11 +62,256 @@ func (p *PilotSuite) TestGetLogConfigs(c *check.C) {
 		"aliyun.logs.hello.tags":           "name=hello,stage=test",
 		"aliyun.logs.hello.format.pattern": "(?=name:hello).*",
 	}
-	configs, err = pilot.getLogConfigs("/path/to/json.log", mounts, labels)
+	configs, err = pilot.getLogConfigs("/path/to/json.log", mounts, "", labels)
 	c.Assert(err, check.IsNil)
 	c.Assert(configs[0].Format, check.Equals, "/(?=name:hello).*/")
 }
 
+func (p *PilotSuite) TestLogDefaults(c *check.C) {
+	pilot, _ := newTestPilot(c, newFakeDocker())
+	mounts := []types.MountPoint{{Source: "/host", Destination: "/var/log"}}
+	configs, err := pilot.getLogConfigs("/path/to/json.log", mounts, "", map[string]string{
+		"aliyun.logs.hello":        "/var/log/hello.log",
+		"aliyun.logs.hello.format": "json",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs, check.HasLen, 1)
+	c.Assert(configs[0].Tags, check.DeepEquals, map[string]string{"topic": "hello"})
+	c.Assert(configs[0].FormatConfig["time_key"], check.Equals, "_timestamp")
+	c.Assert(configs[0].EstimateTime, check.Equals, true)
+
+	configs, err = pilot.getLogConfigs("/path/to/json.log", mounts, "", map[string]string{
+		"aliyun.logs.hello":                 "/var/log/hello.log",
+		"aliyun.logs.hello.target":          "hello-app",
+		"aliyun.logs.hello.format":          "json",
+		"aliyun.logs.hello.format.time_key": "ts",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Tags["topic"], check.Equals, "hello-app")
+	c.Assert(configs[0].EstimateTime, check.Equals, false)
+}
+
+func (p *PilotSuite) TestStream(c *check.C) {
+	pilot, _ := newTestPilot(c, newFakeDocker())
+	configs, err := pilot.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.errors": "stderr",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs, check.HasLen, 1)
+	c.Assert(configs[0].Stdout, check.Equals, true)
+	c.Assert(configs[0].Stream, check.Equals, STREAM_STDERR)
+
+	for labels, stream := range map[[2]string]string{
+		{"stdout", ""}:       STREAM_ALL,
+		{"stdout", "stdout"}: STREAM_STDOUT,
+		{"stdout", "stderr"}: STREAM_STDERR,
+		{"stderr", "stderr"}: STREAM_STDERR,
+	} {
+		l := map[string]string{"aliyun.logs.app": labels[0]}
+		if labels[1] != "" {
+			l["aliyun.logs.app.stream"] = labels[1]
+		}
+		configs, err := pilot.getLogConfigs("/path/to/json.log", nil, "", l)
+		c.Assert(err, check.IsNil)
+		c.Assert(configs[0].Stream, check.Equals, stream, check.Commentf("%v", labels))
+	}
+
+	for labels, expected := range map[[2]string]string{
+		{"stderr", "stdout"}:      "in log app: stream stdout conflicts with stderr",
+		{"stdout", "both"}:        "in log app: stream must be all, stdout or stderr, not both",
+		{"/var/log/a.log", "all"}: "in log app: stream is only supported for stdout",
+	} {
+		_, err := pilot.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+			"aliyun.logs.app":        labels[0],
+			"aliyun.logs.app.stream": labels[1],
+		})
+		c.Assert(err, check.ErrorMatches, expected)
+	}
+}
+
+func (p *PilotSuite) TestUnsafeValues(c *check.C) {
+	pilot, _ := newTestPilot(c, newFakeDocker())
+	configs, err := pilot.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app":      "stdout",
+		"aliyun.logs.app.tags": "note=a: b # ${HOME} </record>",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Tags["note"], check.Equals, "a: b # ${HOME} </record>")
+
+	for labels, expected := range map[[2]string]string{
+		{"aliyun.logs.app.tags", "note=a\nb"}:       `parse tags for app error: tag note: control character U\+000A is not allowed`,
+		{"aliyun.logs.app.tags", "no\tte=a"}:        `parse tags for app error: tag "no\\tte": control character U\+0009 is not allowed`,
+		{"aliyun.logs.app.target", "a b"}:           `in log app: invalid target "a b": .*`,
+		{"aliyun.logs.app.target", "${HOME}"}:       `in log app: invalid target "\$\{HOME\}": .*`,
+		{"aliyun.logs.a b", "stdout"}:               `invalid log name "a b": only letters, digits, _, \. and - are allowed`,
+		{"aliyun.logs.app.format.time_key", "a\rb"}: `in log app: format time_key: control character U\+000D is not allowed`,
+	} {
+		l := map[string]string{"aliyun.logs.app": "/var/log/app.log", labels[0]: labels[1]}
+		if labels[0] == "aliyun.logs.app.format.time_key" {
+			l["aliyun.logs.app.format"] = "json"
+		}
+		_, err := pilot.getLogConfigs("/path/to/json.log", nil, "/rootfs", l)
+		c.Assert(err, check.ErrorMatches, expected, check.Commentf("%v", labels))
+	}
+}
+
+func (p *PilotSuite) TestUnknownKeys(c *check.C) {
+	pilot, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, outputsConfig)))
+	configs, err := pilot.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app":                    "stdout",
+		"aliyun.logs.app.formt":              "regexp",
+		"aliyun.logs.app.formt.pattern":      "(?<a>.*)",
+		"aliyun.logs.app.tail.statr":         "beginning",
+		"aliyun.logs.app.target.x":           "y",
+		"aliyun.logs.bad":                    "stdout",
+		"aliyun.logs.bad.outputs":            "es",
+		"aliyun.logs.bad.outputs.es.targt":   "other",
+		"aliyun.logs.good":                   "stdout",
+		"aliyun.logs.good.start":             "beginning",
+		"aliyun.logs.good.outputs":           "es",
+		"aliyun.logs.good.outputs.es.target": "other",
+	})
+	// other logs are still collected
+	c.Assert(configs, check.HasLen, 1)
+	c.Assert(configs[0].Name, check.Equals, "good")
+	c.Assert(err, check.FitsTypeOf, logErrors{})
+	errs := err.(logErrors)
+	c.Assert(errs, check.HasLen, 2)
+	c.Assert(errs[0].err, check.ErrorMatches, `in log app: unknown keys formt, formt.pattern, tail.statr, target.x`)
+	c.Assert(errs[1].err, check.ErrorMatches, `in log bad: unknown keys outputs.es.targt`)
+}
+
+func (p *PilotSuite) TestMultiline(c *check.C) {
+	labels := map[string]string{
+		"aliyun.logs.app":           "stdout",
+		"aliyun.logs.app.multiline": `^\d{4}-\d{2}-\d{2}`,
+		"aliyun.logs.plain":         "stdout",
+	}
+	pilot, _ := newTestPilot(c, newFakeDocker(), WithPiloter(fakeFilebeat{&fakePiloter{home: c.MkDir()}}))
+	configs, err := pilot.getLogConfigs("/path/to/json.log", nil, "", labels)
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Multiline, check.Equals, `^\d{4}-\d{2}-\d{2}`)
+	c.Assert(configs[1].Multiline, check.Equals, "")
+
+	labels["aliyun.logs.app.multiline"] = "(["
+	_, err = pilot.getLogConfigs("/path/to/json.log", nil, "", labels)
+	c.Assert(err, check.ErrorMatches, "in log app: multiline: error parsing regexp: .*")
+
+	pilot, _ = newTestPilot(c, newFakeDocker())
+	_, err = pilot.getLogConfigs("/path/to/json.log", nil, "", labels)
+	c.Assert(err, check.ErrorMatches, "in log app: multiline is only supported by filebeat")
+}
+
+func (p *PilotSuite) TestPartial(c *check.C) {
+	pilot, _ := newTestPilot(c, newFakeDocker())
+	configs, err := pilot.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app": "stdout",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Partial, check.Equals, true)
+	c.Assert(configs[0].MaxBytes, check.Equals, int64(DEFAULT_MAX_BYTES))
+
+	configs, err = pilot.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app":           "stdout",
+		"aliyun.logs.app.partial":   "false",
+		"aliyun.logs.app.max_bytes": "65536",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Partial, check.Equals, false)
+	c.Assert(configs[0].MaxBytes, check.Equals, int64(65536))
+
+	for labels, expected := range map[[2]string]string{
+		{"stdout", "-1"}:         "in log app: max_bytes must be a positive number of bytes, not -1",
+		{"/var/log/a.log", "10"}: "in log app: partial and max_bytes are only supported for stdout",
+	} {
+		_, err := pilot.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+			"aliyun.logs.app":           labels[0],
+			"aliyun.logs.app.max_bytes": labels[1],
+		})
+		c.Assert(err, check.ErrorMatches, expected)
+	}
+}
+
+func (p *PilotSuite) TestFilebeatVersion(c *check.C) {
+	old, err := New(WithDockerClient(newFakeDocker()), WithBaseDir(c.MkDir()), WithFilebeatVersion("5.6.9"))
+	c.Assert(err, check.IsNil)
+	configs, err := old.getLogConfigs("/path/to/json.log", nil, "", map[string]string{
+		"aliyun.logs.app": "stdout",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Partial, check.Equals, false)
+	c.Assert(configs[0].Stream, check.Equals, STREAM_ALL)
+
+	for labels, expected := range map[[2]string]string{
+		{"aliyun.logs.app.stream", "stderr"}:  "in log app: filebeat 5.6.9 doesn't support stream stderr, 6.0 or later is required",
+		{"aliyun.logs.app.partial", "true"}:   "in log app: filebeat 5.6.9 doesn't support partial and max_bytes, 6.0 or later is required",
+		{"aliyun.logs.app.max_bytes", "1024"}: "in log app: filebeat 5.6.9 doesn't support partial and max_bytes, 6.0 or later is required",
+		{"aliyun.logs.app", "/logs/**/a.log"}: `in log app: filebeat 5.6.9 doesn't support \*\* in /logs/\*\*/a.log, 6.0 or later is required`,
+	} {
+		l := map[string]string{"aliyun.logs.app": "stdout", labels[0]: labels[1]}
+		_, err := old.getLogConfigs("/path/to/json.log", nil, "/rootfs", l)
+		c.Assert(err, check.ErrorMatches, expected, check.Commentf("%v", labels))
+	}
+
+	recent, err := New(WithDockerClient(newFakeDocker()), WithBaseDir(c.MkDir()), WithFilebeatVersion("6.8.0"))
+	c.Assert(err, check.IsNil)
+	configs, err = recent.getLogConfigs("/path/to/json.log", nil, "/rootfs", map[string]string{
+		"aliyun.logs.app":        "stdout",
+		"aliyun.logs.app.stream": "stderr",
+		"aliyun.logs.logs":       "/logs/**/a.log",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs, check.HasLen, 2)
+}
+
+func (p *PilotSuite) TestWritableLayer(c *check.C) {
+	pilot, _ := newTestPilot(c, newFakeDocker())
+	labels := map[string]string{"aliyun.logs.app": "/app/logs/app.log"}
+	_, err := pilot.getLogConfigs("/path/to/json.log", nil, "", labels)
+	c.Assert(err, check.ErrorMatches, "in log app: /app/logs/app.log is not mount on host.*")
+
+	upper := "/var/lib/docker/overlay2/abc/diff"
+	configs, err := pilot.getLogConfigs("/path/to/json.log", []types.MountPoint{
+		{Source: "/data/tmp", Destination: "/tmp"},
+	}, upper, labels)
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].HostDir, check.Equals, "/host/var/lib/docker/overlay2/abc/diff/app/logs")
+	c.Assert(configs[0].File, check.Equals, "app.log")
+
+	// mounts still win
+	configs, err = pilot.getLogConfigs("/path/to/json.log", []types.MountPoint{
+		{Source: "/data/app", Destination: "/app"},
+	}, upper, labels)
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].HostDir, check.Equals, "/host/data/app/logs")
+
+	c.Assert(rootfsOf(types.GraphDriverData{Name: "overlay2", Data: map[string]string{
+		"LowerDir":  "/var/lib/docker/overlay2/abc-init/diff",
+		"MergedDir": "/var/lib/docker/overlay2/abc/merged",
+		"UpperDir":  upper,
+	}}), check.Equals, upper)
+	c.Assert(rootfsOf(types.GraphDriverData{Name: "overlay", Data: map[string]string{
+		"MergedDir": "/var/lib/docker/overlay/abc/merged",
+	}}), check.Equals, "/var/lib/docker/overlay/abc/merged")
+	c.Assert(rootfsOf(types.GraphDriverData{Name: "devicemapper", Data: map[string]string{
+		"DeviceName": "docker-253:0-1-abc",
+	}}), check.Equals, "")
+}
+
+func (p *PilotSuite) TestExplainPath(c *check.C) {
+	pilot, _ := newTestPilot(c, newFakeDocker(), WithBaseDir("/host"))
+	mounts := map[string]types.MountPoint{
+		"/var/log": {Source: "/data/log", Destination: "/var/log"},
+	}
+	c.Assert(pilot.explainPath("/var/log/app/hello.log", "", mounts, "", nil), check.Equals,
+		"/var/log/app/hello.log -> mount /var/log (source /data/log) -> /host/data/log/app/hello.log")
+	c.Assert(pilot.explainPath("/opt/hello.log", "", mounts, "", nil), check.Equals, "/opt/hello.log is not under any mount")
+	c.Assert(pilot.explainPath("stdout", "/var/lib/docker/containers/1/1-json.log", mounts, "", nil), check.Equals,
+		"stdout -> /host/var/lib/docker/containers/1/1-json.log")
+	c.Assert(pilot.explainPath("/opt/hello.log", "", mounts, "/var/lib/docker/overlay2/abc/diff", nil), check.Equals,
+		"/opt/hello.log -> writable layer /var/lib/docker/overlay2/abc/diff -> /host/var/lib/docker/overlay2/abc/diff/opt/hello.log")
+}
+
 func (p *PilotSuite) TestRender(c *check.C) {
 	template := `
 	{{range .configList}}
@@ -91,7 +337,7 @@ func (p *PilotSuite) TestRender(c *check.C) {
 			HostDir: "/path/to/world",
 		},
 	}
-	pilot, err := New(template, "/")
+	pilot, err := New(WithTemplate(template), WithBaseDir("/"))
 	c.Assert(err, check.IsNil)
 	_, err = pilot.render("id-1111", nil, configs)
 	c.Assert(err, check.IsNil)
@@ -103,6 +349,9 @@ func TestDockerEvent(t *testing.T) {
 		t.Fatal(err)
 	}
 	ctx := context.Background()
+	if _, err := client.Ping(ctx); err != nil {
+		t.Skipf("docker is not available: %v", err)
+	}
 
 	filter := filters.NewArgs()
 	filter.Add("type", "container")
@@ -116,7 +365,7 @@ func TestDockerEvent(t *testing.T) {
 	for {
 		select {
 		case msg := <-msgs:
-			log.Printf("process event: %v,  %v", msg)
+			log.Printf("process event: %v", msg)
 		case err := <-errs:
 			log.Warnf("error: %v", err)
 		}
@@ -0,0 +1,131 @@
+package pilot
+
+import (
+	"fmt"
+	"strings"
+)
+
+const SCHEMA_LEGACY = "legacy"
+const SCHEMA_ECS = "ecs"
+const SCHEMA_OTEL = "otel"
+
+const ENV_PILOT_FIELD_SCHEMA = "PILOT_FIELD_SCHEMA"
+
+// fieldSchema names the metadata fields of a container and the tags of a log
+// in the rendered configs. Fields it doesn't know, such as the ones added by
+// enrichers, keep their name.
+type fieldSchema struct {
+	// legacy field name to its names in the schema
+	fields map[string][]string
+	// legacy field name prefix to its prefix in the schema
+	prefixes map[string]string
+	// prefix of tag names, except routing tags
+	tagPrefix string
+}
+
+// tags used by outputs to route logs keep their name in every schema
+var routingTags = map[string]bool{
+	"topic": true,
+	"index": true,
+}
+
+var fieldSchemas = map[string]*fieldSchema{
+	SCHEMA_LEGACY: {},
+	SCHEMA_ECS: {
+		fields: map[string][]string{
+			"docker_container_id":      {"container.id"},
+			"docker_container_name":    {"container.name"},
+			"docker_container_image":   {"container.image.name"},
+			"docker_container_created": {"docker.container.created"},
+			"docker_app":               {"docker.compose.project"},
+			"docker_service":           {"service.name"},
+			"k8s_pod":                  {"kubernetes.pod.name"},
+			"k8s_pod_namespace":        {"kubernetes.namespace"},
+			"k8s_container_name":       {"kubernetes.container.name"},
+			"k8s_node_name":            {"kubernetes.node.name", "host.name"},
+			"k8s_workload_kind":        {"kubernetes.workload.kind"},
+			"k8s_workload_name":        {"kubernetes.workload.name"},
+			"rancher_stack":            {"rancher.stack.name"},
+			"rancher_stack_service":    {"rancher.stack.service.name"},
+		},
+		prefixes: map[string]string{
+			"k8s_pod_label_":      "kubernetes.labels.",
+			"k8s_pod_annotation_": "kubernetes.annotations.",
+			"k8s_node_label_":     "kubernetes.node.labels.",
+		},
+		tagPrefix: "labels.",
+	},
+	SCHEMA_OTEL: {
+		fields: map[string][]string{
+			"docker_container_id":      {"container.id"},
+			"docker_container_name":    {"container.name"},
+			"docker_container_image":   {"container.image.name"},
+			"docker_container_created": {"docker.container.created"},
+			"docker_app":               {"service.namespace"},
+			"docker_service":           {"service.name"},
+			"k8s_pod":                  {"k8s.pod.name"},
+			"k8s_pod_namespace":        {"k8s.namespace.name"},
+			"k8s_container_name":       {"k8s.container.name"},
+			"k8s_node_name":            {"k8s.node.name", "host.name"},
+			"k8s_workload_kind":        {"k8s.workload.kind"},
+			"k8s_workload_name":        {"k8s.workload.name"},
+			"rancher_stack":            {"rancher.stack.name"},
+			"rancher_stack_service":    {"rancher.stack.service.name"},
+		},
+		prefixes: map[string]string{
+			"k8s_pod_label_":      "k8s.pod.label.",
+			"k8s_pod_annotation_": "k8s.pod.annotation.",
+			"k8s_node_label_":     "k8s.node.label.",
+		},
+	},
+}
+
+// WithSchema selects the field naming schema: legacy, ecs or otel.
+func WithSchema(name string) Option {
+	return func(p *Pilot) error {
+		schema, ok := fieldSchemas[name]
+		if !ok {
+			return fmt.Errorf("unsupported field schema: %s", name)
+		}
+		p.schema = schema
+		return nil
+	}
+}
+
+func (s *fieldSchema) metadata(container map[string]string) map[string]string {
+	ret := make(map[string]string, len(container))
+	for key, value := range container {
+		names, ok := s.fields[key]
+		if !ok {
+			names = []string{s.prefixed(key)}
+		}
+		for _, name := range names {
+			ret[name] = value
+		}
+	}
+	return ret
+}
+
+func (s *fieldSchema) prefixed(key string) string {
+	for prefix, name := range s.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return name + strings.TrimPrefix(key, prefix)
+		}
+	}
+	return key
+}
+
+func (s *fieldSchema) tags(tags map[string]string) map[string]string {
+	if s.tagPrefix == "" {
+		return tags
+	}
+	ret := make(map[string]string, len(tags))
+	for key, value := range tags {
+		if routingTags[key] {
+			ret[key] = value
+		} else {
+			ret[s.tagPrefix+key] = value
+		}
+	}
+	return ret
+}
@@ -0,0 +1,206 @@
+package pilot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/elastic/go-ucfg"
+	"github.com/elastic/go-ucfg/yaml"
+	"gopkg.in/check.v1"
+)
+
+type SchemaSuite struct{}
+
+var _ = check.Suite(&SchemaSuite{})
+
+func readAsset(c *check.C, name string) string {
+	b, err := ioutil.ReadFile("../assets/" + name)
+	c.Assert(err, check.IsNil)
+	return string(b)
+}
+
+func (s *SchemaSuite) TestMetadata(c *check.C) {
+	container := map[string]string{
+		"docker_container_id": "c1",
+		"k8s_node_name":       "node-1",
+		"team":                "infra",
+	}
+	c.Assert(fieldSchemas[SCHEMA_LEGACY].metadata(container), check.DeepEquals, container)
+	c.Assert(fieldSchemas[SCHEMA_ECS].metadata(container), check.DeepEquals, map[string]string{
+		"container.id":         "c1",
+		"kubernetes.node.name": "node-1",
+		"host.name":            "node-1",
+		"team":                 "infra",
+	})
+	c.Assert(fieldSchemas[SCHEMA_OTEL].metadata(container)["k8s.node.name"], check.Equals, "node-1")
+
+	tags := map[string]string{"topic": "app", "stage": "test"}
+	c.Assert(fieldSchemas[SCHEMA_ECS].tags(tags), check.DeepEquals, map[string]string{
+		"topic":        "app",
+		"labels.stage": "test",
+	})
+	c.Assert(fieldSchemas[SCHEMA_OTEL].tags(tags), check.DeepEquals, tags)
+}
+
+func (s *SchemaSuite) TestNest(c *check.C) {
+	c.Assert(nest(map[string]string{
+		"container.id":         "c1",
+		"container.image.name": "busybox",
+		"host.name":            "node-1",
+		"a":                    "flat",
+		"a.b":                  "stays flat",
+	}), check.DeepEquals, map[string]interface{}{
+		"container": map[string]interface{}{
+			"id":    "c1",
+			"image": map[string]interface{}{"name": "busybox"},
+		},
+		"host": map[string]interface{}{"name": "node-1"},
+		"a":    "flat",
+		"a.b":  "stays flat",
+	})
+}
+
+func (s *SchemaSuite) TestFilebeatTemplate(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "filebeat/filebeat.tpl")), WithSchema(SCHEMA_ECS))
+	configs := []*LogConfig{{
+		Name:    "app",
+		HostDir: "/host/data",
+		File:    "app.log",
+		Paths:   []string{"/host/data/app.log"},
+		Format:  "json",
+		Tags:    map[string]string{"topic": "app", "stage": "test"},
+	}}
+	out, err := p.render("c1", map[string]string{"docker_container_id": "c1", "k8s_pod": "pod-1"}, configs)
+	c.Assert(err, check.IsNil)
+
+	cfg, err := yaml.NewConfig([]byte(out))
+	c.Assert(err, check.IsNil)
+	var prospectors []struct {
+		Paths  []string               `config:"paths"`
+		Fields map[string]interface{} `config:"fields"`
+	}
+	c.Assert(cfg.Unpack(&prospectors), check.IsNil)
+	c.Assert(prospectors, check.HasLen, 1)
+	c.Assert(prospectors[0].Paths, check.DeepEquals, []string{"/host/data/app.log"})
+	c.Assert(prospectors[0].Fields["topic"], check.Equals, "app")
+	c.Assert(prospectors[0].Fields["labels"], check.DeepEquals, map[string]interface{}{"stage": "test"})
+	c.Assert(prospectors[0].Fields["container"], check.DeepEquals, map[string]interface{}{"id": "c1"})
+	c.Assert(prospectors[0].Fields["kubernetes"], check.DeepEquals,
+		map[string]interface{}{"pod": map[string]interface{}{"name": "pod-1"}})
+
+	// tags of the plan are left untouched
+	c.Assert(configs[0].Tags["stage"], check.Equals, "test")
+}
+
+func (s *SchemaSuite) TestFluentdTemplate(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "fluentd/fluentd.tpl")), WithSchema(SCHEMA_OTEL))
+	out, err := p.render("c1", map[string]string{"k8s_pod": "pod-1"}, []*LogConfig{{
+		Name:    "app",
+		HostDir: "/host/data",
+		File:    "app.log",
+		Paths:   []string{"/host/data/app.log"},
+		Format:  "json",
+	}})
+	c.Assert(err, check.IsNil)
+	c.Assert(out, check.Matches, `(?s).*fields '\{"k8s.pod.name":"pod-1"\}'\n.*`)
+	c.Assert(out, check.Matches, "(?s).*path '/host/data/app.log'\n.*")
+}
+
+func (s *SchemaSuite) TestEscaping(c *check.C) {
+	c.Assert(fluentdString(`it's C:\`), check.Equals, `'it\'s C:\\'`)
+	yamlValue, err := yamlString("a: b # ${HOME}")
+	c.Assert(err, check.IsNil)
+	c.Assert(yamlValue, check.Equals, `"a: b # $${HOME}"`)
+
+	tags := map[string]string{
+		"colon":  "a: b",
+		"hash":   "a #b",
+		"var":    "${HOME}",
+		"ruby":   "#{exit}",
+		"record": "</record><match **>",
+		"quote":  `it's "quoted" \`,
+	}
+	configs := []*LogConfig{{
+		Name:    "app",
+		HostDir: "/host/data",
+		File:    "app #1.log",
+		Paths:   []string{"/host/data/app #1.log"},
+		Format:  "none",
+		Tags:    tags,
+	}}
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "filebeat/filebeat.tpl")))
+	out, err := p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	// as filebeat does, with variables expanded
+	cfg, err := yaml.NewConfig([]byte(out), ucfg.PathSep("."), ucfg.ResolveEnv, ucfg.VarExp)
+	c.Assert(err, check.IsNil)
+	var prospectors []struct {
+		Paths  []string          `config:"paths"`
+		Fields map[string]string `config:"fields"`
+	}
+	c.Assert(cfg.Unpack(&prospectors), check.IsNil)
+	c.Assert(prospectors[0].Paths, check.DeepEquals, configs[0].Paths)
+	// escapes are kept by the go-ucfg of pilot, dropped by later ones
+	c.Assert(prospectors[0].Fields["var"], check.Matches, `\$+\{HOME\}`)
+	expected := merge(tags)
+	delete(prospectors[0].Fields, "var")
+	delete(expected, "var")
+	c.Assert(prospectors[0].Fields, check.DeepEquals, expected)
+
+	p, _ = newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "fluentd/fluentd.tpl")))
+	out, err = p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	fields := regexp.MustCompile(`(?m)^  fields '(.*)'$`).FindStringSubmatch(out)
+	c.Assert(fields, check.HasLen, 2)
+	var decoded map[string]string
+	unquoted := strings.NewReplacer(`\\`, `\`, `\'`, "'").Replace(fields[1])
+	c.Assert(json.Unmarshal([]byte(unquoted), &decoded), check.IsNil)
+	c.Assert(decoded, check.DeepEquals, tags)
+	c.Assert(strings.Count(out, "</record>"), check.Equals, 1)
+	c.Assert(out, check.Matches, `(?s).*path '/host/data/app #1.log'\n.*`)
+}
+
+func (s *SchemaSuite) TestStdoutTemplates(c *check.C) {
+	configs := []*LogConfig{{
+		Name:     "errors",
+		HostDir:  "/host/var/lib/docker/containers/c1",
+		File:     "c1-json.log",
+		Paths:    []string{"/host/var/lib/docker/containers/c1/c1-json.log*"},
+		Format:   "json",
+		Stdout:   true,
+		Stream:   STREAM_STDERR,
+		Partial:  true,
+		MaxBytes: 65536,
+	}}
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "filebeat/filebeat.tpl")))
+	out, err := p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	cfg, err := yaml.NewConfig([]byte(out))
+	c.Assert(err, check.IsNil)
+	var prospectors []struct {
+		DockerJSON struct {
+			Stream  string `config:"stream"`
+			Partial bool   `config:"partial"`
+		} `config:"docker-json"`
+		MaxBytes int64 `config:"max_bytes"`
+	}
+	c.Assert(cfg.Unpack(&prospectors), check.IsNil)
+	c.Assert(prospectors[0].DockerJSON.Stream, check.Equals, STREAM_STDERR)
+	c.Assert(prospectors[0].DockerJSON.Partial, check.Equals, true)
+	c.Assert(prospectors[0].MaxBytes, check.Equals, int64(65536))
+
+	p, _ = newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "fluentd/fluentd.tpl")))
+	out, err = p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	c.Assert(out, check.Matches, `(?s).*@type docker_partial\s+max_bytes 65536\s+</filter>.*`)
+	c.Assert(out, check.Matches, `(?s).*@type grep\s+<regexp>\s+key stream\s+pattern /\^stderr\$/.*`)
+
+	configs[0].Stream = STREAM_ALL
+	out, err = p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(out, "@type grep"), check.Equals, false)
+}
@@ -0,0 +1,273 @@
+package pilot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// SelectorConfig restricts the containers pilot manages.
+// A container is managed when it matches include and doesn't match exclude.
+type SelectorConfig struct {
+	Include *MatchConfig `config:"include"`
+	Exclude *MatchConfig `config:"exclude"`
+}
+
+// MatchConfig matches containers. In include every non-empty criterion must
+// match, in exclude any one matching is enough. Within a criterion, one of
+// the values must match, except labels which must all match.
+type MatchConfig struct {
+	// Kubernetes namespaces, containers out of kubernetes have no namespace.
+	Namespaces []string `config:"namespaces"`
+	// Label selector requirements on container labels:
+	// key, !key, key=value, key!=value, key in (a,b), key notin (a,b).
+	Labels []string `config:"labels"`
+	// Image globs, * matches any characters including /.
+	Images []string `config:"images"`
+	// Container name regexes, the kubernetes container name is tried as well.
+	Names []string `config:"names"`
+}
+
+type labelRequirement struct {
+	key      string
+	operator string
+	values   map[string]bool
+}
+
+type containerMatcher struct {
+	namespaces map[string]bool
+	labels     []labelRequirement
+	images     []*regexp.Regexp
+	names      []*regexp.Regexp
+}
+
+type containerSelector struct {
+	include *containerMatcher
+	exclude *containerMatcher
+}
+
+// WithSelector restricts the containers pilot manages.
+func WithSelector(config *SelectorConfig) Option {
+	return func(p *Pilot) error {
+		selector, err := newContainerSelector(config)
+		if err != nil {
+			return err
+		}
+		p.selector = selector
+		return nil
+	}
+}
+
+func newContainerSelector(config *SelectorConfig) (*containerSelector, error) {
+	include, err := newContainerMatcher(config.Include)
+	if err != nil {
+		return nil, fmt.Errorf("selector include: %v", err)
+	}
+	exclude, err := newContainerMatcher(config.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("selector exclude: %v", err)
+	}
+	return &containerSelector{include: include, exclude: exclude}, nil
+}
+
+func newContainerMatcher(config *MatchConfig) (*containerMatcher, error) {
+	if config == nil {
+		return nil, nil
+	}
+	m := &containerMatcher{}
+	if len(config.Namespaces) > 0 {
+		m.namespaces = make(map[string]bool)
+		for _, namespace := range config.Namespaces {
+			m.namespaces[namespace] = true
+		}
+	}
+	for _, selector := range config.Labels {
+		requirements, err := parseLabelSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		m.labels = append(m.labels, requirements...)
+	}
+	for _, image := range config.Images {
+		m.images = append(m.images, globRegexp(image))
+	}
+	for _, name := range config.Names {
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return nil, err
+		}
+		m.names = append(m.names, re)
+	}
+	return m, nil
+}
+
+// globRegexp compiles a glob where * matches any characters.
+func globRegexp(glob string) *regexp.Regexp {
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// splitSelector splits requirements on commas outside parentheses.
+func splitSelector(selector string) []string {
+	var ret []string
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				ret = append(ret, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(ret, selector[start:])
+}
+
+var setRequirement = regexp.MustCompile(`^([^\s!=(),]+)\s+(in|notin)\s*\(([^()]*)\)$`)
+
+func parseLabelSelector(selector string) ([]labelRequirement, error) {
+	var ret []labelRequirement
+	for _, s := range splitSelector(selector) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, fmt.Errorf("empty requirement in label selector %q", selector)
+		}
+		r := labelRequirement{values: make(map[string]bool)}
+		if m := setRequirement.FindStringSubmatch(s); m != nil {
+			r.key, r.operator = m[1], m[2]
+			for _, value := range strings.Split(m[3], ",") {
+				r.values[strings.TrimSpace(value)] = true
+			}
+		} else if i := strings.Index(s, "!="); i > 0 {
+			r.key, r.operator = strings.TrimSpace(s[:i]), "notin"
+			r.values[strings.TrimSpace(s[i+2:])] = true
+		} else if i := strings.Index(s, "="); i > 0 {
+			r.key, r.operator = strings.TrimSpace(s[:i]), "in"
+			r.values[strings.TrimSpace(strings.TrimPrefix(s[i+1:], "="))] = true
+		} else if strings.HasPrefix(s, "!") {
+			r.key, r.operator = strings.TrimSpace(s[1:]), "!"
+		} else {
+			r.key, r.operator = s, "exists"
+		}
+		if r.key == "" || strings.ContainsAny(r.key, " !=(),") {
+			return nil, fmt.Errorf("invalid requirement %q in label selector %q", s, selector)
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+func (r labelRequirement) match(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	switch r.operator {
+	case "exists":
+		return ok
+	case "!":
+		return !ok
+	case "in":
+		return ok && r.values[value]
+	default:
+		return !ok || !r.values[value]
+	}
+}
+
+func (r labelRequirement) String() string {
+	var values []string
+	for value := range r.values {
+		values = append(values, value)
+	}
+	switch r.operator {
+	case "exists":
+		return r.key
+	case "!":
+		return "!" + r.key
+	default:
+		return fmt.Sprintf("%s %s (%s)", r.key, r.operator, strings.Join(values, ","))
+	}
+}
+
+// match tells whether the container matches, with the reason.
+// With all, every non-empty criterion must match, otherwise any one.
+func (m *containerMatcher) match(containerJSON *types.ContainerJSON, all bool) (bool, string) {
+	labels := containerJSON.Config.Labels
+	var results []bool
+	var reasons []string
+	check := func(ok bool, reason string) {
+		results = append(results, ok)
+		reasons = append(reasons, reason)
+	}
+
+	if m.namespaces != nil {
+		namespace := labels[LABEL_K8S_POD_NAMESPACE]
+		check(m.namespaces[namespace], fmt.Sprintf("namespace %q", namespace))
+	}
+	if len(m.labels) > 0 {
+		ok, reason := true, ""
+		for _, r := range m.labels {
+			if !r.match(labels) {
+				ok, reason = false, fmt.Sprintf("label requirement %s", r)
+				break
+			}
+		}
+		if ok {
+			reason = "labels"
+		}
+		check(ok, reason)
+	}
+	if len(m.images) > 0 {
+		ok := false
+		for _, re := range m.images {
+			ok = ok || re.MatchString(containerJSON.Config.Image)
+		}
+		check(ok, fmt.Sprintf("image %q", containerJSON.Config.Image))
+	}
+	if len(m.names) > 0 {
+		name := strings.TrimPrefix(containerJSON.Name, "/")
+		ok := false
+		for _, re := range m.names {
+			ok = ok || re.MatchString(name) || re.MatchString(labels[LABEL_K8S_CONTAINER_NAME])
+		}
+		check(ok, fmt.Sprintf("name %q", name))
+	}
+
+	for i, ok := range results {
+		if all && !ok {
+			return false, reasons[i]
+		}
+		if !all && ok {
+			return true, reasons[i]
+		}
+	}
+	// an empty include matches everything, an empty exclude nothing
+	return all, ""
+}
+
+// skip returns why the container is not managed, or "" when it is.
+func (s *containerSelector) skip(containerJSON *types.ContainerJSON) string {
+	if s == nil {
+		return ""
+	}
+	if s.include != nil {
+		if ok, reason := s.include.match(containerJSON, true); !ok {
+			if reason == "" {
+				return "not included"
+			}
+			return fmt.Sprintf("not included: %s", reason)
+		}
+	}
+	if s.exclude != nil {
+		if ok, reason := s.exclude.match(containerJSON, false); ok {
+			return fmt.Sprintf("excluded: %s", reason)
+		}
+	}
+	return ""
+}
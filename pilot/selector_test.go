@@ -0,0 +1,115 @@
+package pilot
+
+import (
+	"bytes"
+
+	"github.com/docker/docker/api/types"
+	"gopkg.in/check.v1"
+)
+
+type SelectorSuite struct{}
+
+var _ = check.Suite(&SelectorSuite{})
+
+func (s *SelectorSuite) TestLabelSelector(c *check.C) {
+	labels := map[string]string{"app": "web", "tier": "frontend", "collect": "true"}
+	for selector, expected := range map[string]bool{
+		"app":                             true,
+		"!app":                            false,
+		"!debug":                          true,
+		"app=web":                         true,
+		"app==web":                        true,
+		"app!=web":                        false,
+		"missing!=web":                    true,
+		"app in (web, api)":               true,
+		"app notin (web,api)":             false,
+		"tier in (backend)":               false,
+		"app=web,tier in (frontend),!dbg": true,
+		"app=web,collect=false":           false,
+	} {
+		requirements, err := parseLabelSelector(selector)
+		c.Assert(err, check.IsNil, check.Commentf(selector))
+		ok := true
+		for _, r := range requirements {
+			ok = ok && r.match(labels)
+		}
+		c.Assert(ok, check.Equals, expected, check.Commentf(selector))
+	}
+
+	for _, selector := range []string{"", "app,", "a b", "app in web", "=web"} {
+		_, err := parseLabelSelector(selector)
+		c.Assert(err, check.NotNil, check.Commentf(selector))
+	}
+}
+
+func (s *SelectorSuite) TestSkip(c *check.C) {
+	selector, err := newContainerSelector(&SelectorConfig{
+		Include: &MatchConfig{
+			Namespaces: []string{"shop", "pay"},
+			Images:     []string{"registry.example.com/*"},
+		},
+		Exclude: &MatchConfig{
+			Labels: []string{"log-pilot.io/collect=false"},
+			Names:  []string{"^debug-"},
+		},
+	})
+	c.Assert(err, check.IsNil)
+
+	containerJSON := func(name, image string, labels map[string]string) *types.ContainerJSON {
+		containerJSON := fakeContainer(name, labels)
+		containerJSON.Config.Image = image
+		return &containerJSON
+	}
+	shop := map[string]string{LABEL_K8S_POD_NAMESPACE: "shop"}
+
+	c.Assert(selector.skip(containerJSON("web", "registry.example.com/shop/web:1", shop)), check.Equals, "")
+	c.Assert(selector.skip(containerJSON("web", "docker.io/library/nginx", shop)), check.Equals,
+		`not included: image "docker.io/library/nginx"`)
+	c.Assert(selector.skip(containerJSON("web", "registry.example.com/web", map[string]string{})), check.Equals,
+		`not included: namespace ""`)
+	c.Assert(selector.skip(containerJSON("debug-web", "registry.example.com/web", shop)), check.Equals,
+		`excluded: name "debug-web"`)
+	c.Assert(selector.skip(containerJSON("web", "registry.example.com/web", map[string]string{
+		LABEL_K8S_POD_NAMESPACE: "pay",
+		"log-pilot.io/collect":  "false",
+	})), check.Equals, "excluded: labels")
+
+	var none *containerSelector
+	c.Assert(none.skip(containerJSON("web", "busybox", nil)), check.Equals, "")
+
+	_, err = newContainerSelector(&SelectorConfig{Exclude: &MatchConfig{Names: []string{"("}}})
+	c.Assert(err, check.ErrorMatches, "selector exclude: .*")
+}
+
+func (s *SelectorSuite) TestEmpty(c *check.C) {
+	selector, err := newContainerSelector(&SelectorConfig{Include: &MatchConfig{}, Exclude: &MatchConfig{}})
+	c.Assert(err, check.IsNil)
+	containerJSON := fakeContainer("c1", map[string]string{"app": "web"})
+	c.Assert(selector.skip(&containerJSON), check.Equals, "")
+}
+
+func (s *SelectorSuite) TestConfig(c *check.C) {
+	config := newTestConfig(c, `
+selector:
+  include:
+    labels: ["collect"]
+  exclude:
+    namespaces: [kube-system]
+`)
+	docker := newFakeDocker(
+		fakeContainer("c1", map[string]string{"aliyun.logs.app": "stdout", "collect": "true"}),
+		fakeContainer("c2", map[string]string{"aliyun.logs.app": "stdout"}),
+		fakeContainer("c3", map[string]string{
+			"aliyun.logs.app":       "stdout",
+			"collect":               "true",
+			LABEL_K8S_POD_NAMESPACE: "kube-system",
+		}),
+	)
+	p, _ := newTestPilot(c, docker, WithConfig(config))
+
+	var out bytes.Buffer
+	c.Assert(p.DryRun(&out, ""), check.IsNil)
+	c.Assert(out.String(), check.Matches, `(?s).*\[ok\] c1 \(c1\).*`)
+	c.Assert(out.String(), check.Matches, `(?s).*\[skip\] c2 \(c2\): not included: label requirement collect\n.*`)
+	c.Assert(out.String(), check.Matches, `(?s).*\[skip\] c3 \(c3\): excluded: namespace "kube-system"\n.*`)
+}
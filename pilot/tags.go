@@ -0,0 +1,324 @@
+package pilot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tagSyntaxError tells where a tags label stops being valid.
+type tagSyntaxError struct {
+	// pos is the character the error is at, from 1
+	pos int
+	msg string
+}
+
+func (e *tagSyntaxError) Error() string {
+	return fmt.Sprintf("%s at character %d", e.msg, e.pos)
+}
+
+// tag is a parsed k=v pair, pos is the character its key starts at, 0 in
+// json objects.
+type tag struct {
+	key   string
+	value string
+	pos   int
+}
+
+// tagScanner reads the k=v,k=v form of tags:
+//
+//	key=plain value,key="quoted, value",key=a\,b,key={{ template, args }}
+//
+// Unquoted values end at the next comma, may hold = and are trimmed.
+// Quoted values keep their spaces. Both escape \ " and , with a backslash,
+// which only quoted values reject before other characters.
+// Templates are read as is up to their closing braces.
+type tagScanner struct {
+	runes []rune
+	i     int
+}
+
+func (s *tagScanner) errorf(format string, args ...interface{}) error {
+	return &tagSyntaxError{pos: s.i + 1, msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *tagScanner) eof() bool {
+	return s.i >= len(s.runes)
+}
+
+func (s *tagScanner) peek() rune {
+	return s.runes[s.i]
+}
+
+func (s *tagScanner) skipSpaces() {
+	for !s.eof() && s.peek() == ' ' {
+		s.i++
+	}
+}
+
+func (s *tagScanner) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(string(s.runes[s.i:]), prefix)
+}
+
+func (s *tagScanner) tags() ([]tag, error) {
+	var tags []tag
+	for {
+		s.skipSpaces()
+		t, err := s.tag()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+		if s.eof() {
+			return tags, nil
+		}
+		// tag() stops at a comma only
+		s.i++
+	}
+}
+
+func (s *tagScanner) tag() (tag, error) {
+	t := tag{pos: s.i + 1}
+	start := s.i
+	for !s.eof() && s.peek() != '=' {
+		switch s.peek() {
+		case ',':
+			if s.i == start {
+				return t, s.errorf("empty tag")
+			}
+			return t, s.errorf("expected = after key %q", strings.TrimSpace(string(s.runes[start:s.i])))
+		case '"', '\\':
+			return t, s.errorf("unexpected %q in key", s.peek())
+		}
+		s.i++
+	}
+	if s.eof() {
+		if s.i == start {
+			return t, s.errorf("empty tag")
+		}
+		return t, s.errorf("expected = after key %q", strings.TrimSpace(string(s.runes[start:s.i])))
+	}
+	t.key = strings.TrimSpace(string(s.runes[start:s.i]))
+	if t.key == "" {
+		return t, s.errorf("empty key")
+	}
+	s.i++
+
+	s.skipSpaces()
+	valuePos := s.i + 1
+	var err error
+	if !s.eof() && s.peek() == '"' {
+		t.value, err = s.quoted()
+	} else {
+		t.value, err = s.unquoted()
+	}
+	if err != nil {
+		return t, err
+	}
+	if t.value == "" {
+		return t, &tagSyntaxError{pos: valuePos, msg: fmt.Sprintf("empty value for tag %s", t.key)}
+	}
+	return t, nil
+}
+
+// escape reads the character after a backslash. Unless strict, a backslash
+// escaping nothing is kept, as in paths like C:\logs.
+func (s *tagScanner) escape(buf *bytes.Buffer, strict bool) error {
+	s.i++
+	if !s.eof() {
+		switch r := s.peek(); r {
+		case '\\', '"', ',':
+			buf.WriteRune(r)
+			s.i++
+			return nil
+		}
+	}
+	s.i--
+	if !strict {
+		buf.WriteRune('\\')
+		s.i++
+		return nil
+	}
+	if s.i+1 == len(s.runes) {
+		return s.errorf("unterminated escape")
+	}
+	return s.errorf("invalid escape \\%c, only \\\\, \\\" and \\, are allowed", s.runes[s.i+1])
+}
+
+func (s *tagScanner) quoted() (string, error) {
+	open := s.i
+	s.i++
+	var buf bytes.Buffer
+	for {
+		if s.eof() {
+			s.i = open
+			return "", s.errorf("unterminated quoted value")
+		}
+		switch r := s.peek(); r {
+		case '"':
+			s.i++
+			s.skipSpaces()
+			if !s.eof() && s.peek() != ',' {
+				return "", s.errorf("unexpected %q after quoted value", s.peek())
+			}
+			return buf.String(), nil
+		case '\\':
+			if err := s.escape(&buf, true); err != nil {
+				return "", err
+			}
+		default:
+			buf.WriteRune(r)
+			s.i++
+		}
+	}
+}
+
+func (s *tagScanner) unquoted() (string, error) {
+	var buf bytes.Buffer
+	for !s.eof() && s.peek() != ',' {
+		switch {
+		case s.hasPrefix("{{"):
+			open := s.i
+			end := strings.Index(string(s.runes[s.i:]), "}}")
+			if end < 0 {
+				return "", s.errorf("unterminated template")
+			}
+			end = s.i + utf8.RuneCountInString(string(s.runes[s.i:])[:end]) + 2
+			buf.WriteString(string(s.runes[open:end]))
+			s.i = end
+		case s.peek() == '\\':
+			if err := s.escape(&buf, false); err != nil {
+				return "", err
+			}
+		default:
+			buf.WriteRune(s.peek())
+			s.i++
+		}
+	}
+	return strings.TrimRightFunc(buf.String(), func(r rune) bool { return r == ' ' }), nil
+}
+
+// splitTags parses tags in the k=v,k=v form or as a json object of strings,
+// like {"k": "v"}. Keys set twice are errors.
+func splitTags(tags string) ([]tag, error) {
+	var ret []tag
+	var err error
+	if trimmed := strings.TrimLeftFunc(tags, unicode.IsSpace); strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "{{") {
+		ret, err = jsonTags(tags)
+	} else {
+		s := &tagScanner{runes: []rune(tags)}
+		ret, err = s.tags()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, t := range ret {
+		if seen[t.key] {
+			return nil, &tagSyntaxError{pos: t.pos, msg: fmt.Sprintf("tag %s is set twice", t.key)}
+		}
+		seen[t.key] = true
+	}
+	return ret, nil
+}
+
+// jsonTags parses tags given as a json object, whose values are strings,
+// numbers or booleans. The object is walked token by token, as decoding it
+// into a map would keep the last value of a key set twice.
+func jsonTags(tags string) ([]tag, error) {
+	var object json.RawMessage
+	decoder := json.NewDecoder(strings.NewReader(tags))
+	err := decoder.Decode(&object)
+	if err == nil && decoder.More() {
+		var extra interface{}
+		err = decoder.Decode(&extra)
+		if err == nil {
+			return nil, fmt.Errorf("invalid json: data after the object")
+		}
+	}
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		// the offset counts the bytes read, the offending one included
+		pos := utf8.RuneCountInString(tags[:syntaxErr.Offset])
+		return nil, &tagSyntaxError{pos: pos, msg: "invalid json: " + syntaxErr.Error()}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+
+	// the object is valid json, only its values are checked
+	decoder = json.NewDecoder(bytes.NewReader(object))
+	decoder.UseNumber()
+	decoder.Token()
+	var ret []tag
+	seen := make(map[string]bool)
+	for i := 0; decoder.More(); i++ {
+		token, _ := decoder.Token()
+		key := token.(string)
+		if seen[key] {
+			return nil, &tagSyntaxError{pos: jsonKeyPos(tags, i), msg: fmt.Sprintf("tag %s is set twice", key)}
+		}
+		seen[key] = true
+
+		var raw interface{}
+		decoder.Decode(&raw)
+		var value string
+		switch v := raw.(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("tag %q must be a string, a number or a boolean", key)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("empty key")
+		}
+		if value == "" {
+			return nil, fmt.Errorf("empty value for tag %s", key)
+		}
+		ret = append(ret, tag{key: key, value: value})
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("empty json object")
+	}
+	return ret, nil
+}
+
+// jsonKeyPos returns the character the key n, from 0, of the valid json
+// object tags starts at, from 1: the decoder doesn't tell where tokens are.
+func jsonKeyPos(tags string, n int) int {
+	depth := 0
+	key := false
+	for i := 0; i < len(tags); i++ {
+		switch tags[i] {
+		case '{', '[':
+			depth++
+			key = depth == 1
+		case '}', ']':
+			depth--
+		case ',':
+			key = depth == 1
+		case '"':
+			if key {
+				if n == 0 {
+					return utf8.RuneCountInString(tags[:i]) + 1
+				}
+				n--
+				key = false
+			}
+			// skip the string, escaped quotes included
+			for i++; i < len(tags) && tags[i] != '"'; i++ {
+				if tags[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+package pilot
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type TagsSuite struct{}
+
+var _ = check.Suite(&TagsSuite{})
+
+func (s *TagsSuite) TestParse(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker())
+	for tags, expected := range map[string]map[string]string{
+		"":                                  {},
+		"  ":                                {},
+		"a=b":                               {"a": "b"},
+		" a = b , c=d ":                     {"a": "b", "c": "d"},
+		"query=a=b":                         {"query": "a=b"},
+		"url=http://host:80/p?q=1&r=2#x":    {"url": "http://host:80/p?q=1&r=2#x"},
+		`desc="hello, world",a=b`:           {"desc": "hello, world", "a": "b"},
+		`desc=" padded "`:                   {"desc": " padded "},
+		`quote="say \"hi\"",path="C:\\log"`: {"quote": `say "hi"`, "path": `C:\log`},
+		`list=a\,b\,c`:                      {"list": "a,b,c"},
+		`path=C:\logs\app`:                  {"path": `C:\logs\app`},
+		`mid=a"b`:                           {"mid": `a"b`},
+		`unicode=日本, b=ü`:                   {"unicode": "日本", "b": "ü"},
+		`t={{ replace "," "-" .k8s_pod }}`:  {"t": `{{ replace "," "-" .k8s_pod }}`},
+		`{"a": "b", "c": "d,e=f"}`:          {"a": "b", "c": "d,e=f"},
+		` {"n": 1.50, "big": 12345678901234567890, "ok": true}`: {"n": "1.50", "big": "12345678901234567890", "ok": "true"},
+	} {
+		parsed, err := p.parseTags(tags)
+		c.Assert(err, check.IsNil, check.Commentf(tags))
+		c.Assert(parsed, check.DeepEquals, expected, check.Commentf(tags))
+	}
+}
+
+func (s *TagsSuite) TestErrors(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker())
+	for tags, expected := range map[string]string{
+		"a":                `expected = after key "a" at character 2`,
+		"a=b,c":            `expected = after key "c" at character 6`,
+		"a=b,,c=d":         "empty tag at character 5",
+		"a=b,":             "empty tag at character 5",
+		"=b":               "empty key at character 1",
+		"a=":               "empty value for tag a at character 3",
+		"a=b, c=  ,d=e":    "empty value for tag c at character 10",
+		`a=""`:             "empty value for tag a at character 3",
+		`a="b`:             "unterminated quoted value at character 3",
+		`a="b"c`:           `unexpected 'c' after quoted value at character 6`,
+		`a="b\n"`:          `invalid escape \\n, only \\\\, \\" and \\, are allowed at character 5`,
+		`a="b\`:            "unterminated escape at character 5",
+		`a"b=c`:            `unexpected '"' in key at character 2`,
+		"a={{ .k8s_pod":    "unterminated template at character 3",
+		"a=b,a=c":          "tag a is set twice at character 5",
+		"日本=b,x":           `expected = after key "x" at character 7`,
+		`{"a": "b",}`:      `invalid json: invalid character '}' looking for beginning of object key string at character 11`,
+		`{"a": "b"} x`:     `invalid json: invalid character 'x' looking for beginning of value at character 12`,
+		`{"a": "b"} {}`:    "invalid json: data after the object",
+		`{"a": ["b"]}`:     `tag "a" must be a string, a number or a boolean`,
+		`{"a": null}`:      `tag "a" must be a string, a number or a boolean`,
+		`{"a": ""}`:        "empty value for tag a",
+		`{}`:               "empty json object",
+		`{"a": "b\nc"}`:    `tag a: control character U\+000A is not allowed`,
+		`a={{ printenv }}`: `tag a: template: value:1: function "printenv" not defined`,
+	} {
+		_, err := p.parseTags(tags)
+		c.Assert(err, check.ErrorMatches, expected, check.Commentf(tags))
+	}
+}
+
+func (s *TagsSuite) TestJSONSetTwice(c *check.C) {
+	// decoding a map would keep the last value
+	for tags, expected := range map[string]string{
+		`{"a": "1", "a": "2"}`:               "tag a is set twice at character 12",
+		`{"日": "1", "b": "x\"y,", "日": "2"}`: "tag 日 is set twice at character 26",
+	} {
+		_, err := splitTags(tags)
+		c.Assert(err, check.ErrorMatches, expected, check.Commentf(tags))
+	}
+}
@@ -0,0 +1,226 @@
+package pilot
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const TAIL_START_BEGINNING = "beginning"
+const TAIL_START_END = "end"
+
+// encodings maps the encodings of filebeat to the ones of ruby, for fluentd.
+var encodings = map[string]string{
+	"utf-8":       "UTF-8",
+	"utf-16be":    "UTF-16BE",
+	"utf-16le":    "UTF-16LE",
+	"gbk":         "GBK",
+	"gb18030":     "GB18030",
+	"big5":        "Big5",
+	"euc-jp":      "EUC-JP",
+	"euc-kr":      "EUC-KR",
+	"iso-2022-jp": "ISO-2022-JP",
+	"shift-jis":   "Shift_JIS",
+	"iso8859-1":   "ISO-8859-1",
+	"iso8859-15":  "ISO-8859-15",
+	"windows1252": "Windows-1252",
+}
+
+// TailOptions control how the files of a log are read.
+type TailOptions struct {
+	// Start is beginning or end, where new files are read from
+	Start string
+	// Encoding is the filebeat name of the encoding, RubyEncoding the fluentd one
+	Encoding     string
+	RubyEncoding string
+	// IgnoreOlder skips files not modified for this long, 0 reads them all
+	IgnoreOlder   time.Duration
+	CloseInactive time.Duration
+	ScanFrequency time.Duration
+	// RotateWait keeps reading a rotated file for this long, 0 stops at once
+	RotateWait time.Duration
+	// Exclude are globs of file names never read
+	Exclude []string
+}
+
+func defaultTailOptions() TailOptions {
+	return TailOptions{
+		Start:         TAIL_START_BEGINNING,
+		Encoding:      "utf-8",
+		RubyEncoding:  encodings["utf-8"],
+		CloseInactive: 2 * time.Hour,
+		ScanFrequency: 10 * time.Second,
+		RotateWait:    5 * time.Second,
+		Exclude:       []string{"*.gz", "*.zip"},
+	}
+}
+
+// FromHead tells whether new files are read from their beginning.
+func (t TailOptions) FromHead() bool {
+	return t.Start == TAIL_START_BEGINNING
+}
+
+// ExcludeRegexps returns the exclude globs as regexps on file paths, for filebeat.
+func (t TailOptions) ExcludeRegexps() []string {
+	var ret []string
+	for _, glob := range t.Exclude {
+		// globs are checked when the log is parsed
+		if expr, err := nameGlobRegexp(glob); err == nil {
+			ret = append(ret, "(^|/)"+expr+"$")
+		}
+	}
+	return ret
+}
+
+// ExcludePaths returns the exclude globs in the dirs of paths, for fluentd.
+func (t TailOptions) ExcludePaths(paths []string) []string {
+	var ret []string
+	for _, path := range paths {
+		for _, glob := range t.Exclude {
+			ret = append(ret, filepath.Join(filepath.Dir(path), glob))
+		}
+	}
+	return ret
+}
+
+// nameGlobRegexp turns a file name glob of filepath.Match into a regexp.
+func nameGlobRegexp(glob string) (string, error) {
+	var b bytes.Buffer
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			if i++; i == len(glob) {
+				return "", filepath.ErrBadPattern
+			}
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			class, n, err := globClassRegexp(glob[i:])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(class)
+			i += n - 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
+
+// globClassRegexp turns the class glob starts with into a regexp class,
+// along with the length of the class in glob.
+func globClassRegexp(glob string) (string, int, error) {
+	var b bytes.Buffer
+	b.WriteString("[")
+	i := 1
+	if i < len(glob) && glob[i] == '^' {
+		// a negated class doesn't match / either
+		b.WriteString("^/")
+		i++
+	}
+	// char reads a possibly escaped character, - and ] must be escaped
+	char := func() (string, error) {
+		if i < len(glob) && (glob[i] == '-' || glob[i] == ']') {
+			return "", filepath.ErrBadPattern
+		}
+		if i < len(glob) && glob[i] == '\\' {
+			i++
+		}
+		r, n := utf8.DecodeRuneInString(glob[i:])
+		if n == 0 {
+			return "", filepath.ErrBadPattern
+		}
+		i += n
+		if strings.ContainsRune(`\[]^-`, r) {
+			return `\` + string(r), nil
+		}
+		return string(r), nil
+	}
+	for ranges := 0; ; ranges++ {
+		if i < len(glob) && glob[i] == ']' && ranges > 0 {
+			b.WriteString("]")
+			return b.String(), i + 1, nil
+		}
+		lo, err := char()
+		if err != nil {
+			return "", 0, err
+		}
+		b.WriteString(lo)
+		if i < len(glob) && glob[i] == '-' {
+			i++
+			hi, err := char()
+			if err != nil {
+				return "", 0, err
+			}
+			b.WriteString("-" + hi)
+		}
+	}
+}
+
+// parseTail reads the tail options of a log.
+func (p *Pilot) parseTail(name string, stdout bool, info *LogInfoNode) (TailOptions, error) {
+	tail := defaultTailOptions()
+
+	if start := info.get("start"); start != "" {
+		if start != TAIL_START_BEGINNING && start != TAIL_START_END {
+			return tail, fmt.Errorf("in log %s: start must be %s or %s, not %s", name, TAIL_START_BEGINNING, TAIL_START_END, start)
+		}
+		tail.Start = start
+	}
+
+	if encoding := strings.ToLower(info.get("encoding")); encoding != "" {
+		ruby, ok := encodings[encoding]
+		if !ok {
+			return tail, fmt.Errorf("in log %s: unsupported encoding %s", name, encoding)
+		}
+		if stdout && encoding != "utf-8" {
+			return tail, fmt.Errorf("in log %s: stdout is always utf-8", name)
+		}
+		tail.Encoding, tail.RubyEncoding = encoding, ruby
+	}
+
+	for key, d := range map[string]*time.Duration{
+		"ignore_older":   &tail.IgnoreOlder,
+		"close_inactive": &tail.CloseInactive,
+		"scan_frequency": &tail.ScanFrequency,
+		"rotate_wait":    &tail.RotateWait,
+	} {
+		value := info.get(key)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 || duration%time.Second != 0 {
+			return tail, fmt.Errorf("in log %s: %s must be a whole number of seconds like 30s or 2h, not %s", name, key, value)
+		}
+		*d = duration
+	}
+	if tail.CloseInactive == 0 || tail.ScanFrequency == 0 {
+		return tail, fmt.Errorf("in log %s: close_inactive and scan_frequency can't be 0", name)
+	}
+	if tail.IgnoreOlder != 0 && tail.IgnoreOlder <= tail.CloseInactive {
+		return tail, fmt.Errorf("in log %s: ignore_older must be greater than close_inactive", name)
+	}
+
+	if exclude, ok := info.children["exclude"]; ok {
+		tail.Exclude = nil
+		for _, glob := range strings.Split(exclude.value, ",") {
+			if glob = strings.TrimSpace(glob); glob == "" {
+				continue
+			}
+			if _, err := nameGlobRegexp(glob); err != nil || strings.Contains(glob, "/") {
+				return tail, fmt.Errorf("in log %s: exclude must be file name globs, not %s", name, glob)
+			}
+			tail.Exclude = append(tail.Exclude, glob)
+		}
+	}
+	return tail, nil
+}
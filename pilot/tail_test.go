@@ -0,0 +1,161 @@
+package pilot
+
+import (
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/elastic/go-ucfg/yaml"
+	"gopkg.in/check.v1"
+)
+
+type TailSuite struct{}
+
+var _ = check.Suite(&TailSuite{})
+
+func (s *TailSuite) TestParse(c *check.C) {
+	pilot, _ := newTestPilot(c, newFakeDocker())
+	mounts := []types.MountPoint{{Source: "/data/logs", Destination: "/logs"}}
+
+	configs, err := pilot.getLogConfigs("/path/to/json.log", mounts, "", map[string]string{
+		"aliyun.logs.app": "/logs/app.log",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Tail, check.DeepEquals, defaultTailOptions())
+
+	configs, err = pilot.getLogConfigs("/path/to/json.log", mounts, "", map[string]string{
+		"aliyun.logs.app":                "/logs/app.log",
+		"aliyun.logs.app.start":          "end",
+		"aliyun.logs.app.encoding":       "GBK",
+		"aliyun.logs.app.ignore_older":   "48h",
+		"aliyun.logs.app.close_inactive": "5m",
+		"aliyun.logs.app.scan_frequency": "1s",
+		"aliyun.logs.app.rotate_wait":    "0s",
+		"aliyun.logs.app.exclude":        "*.tmp, debug-[0-9].log",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Tail, check.DeepEquals, TailOptions{
+		Start:         TAIL_START_END,
+		Encoding:      "gbk",
+		RubyEncoding:  "GBK",
+		IgnoreOlder:   48 * time.Hour,
+		CloseInactive: 5 * time.Minute,
+		ScanFrequency: time.Second,
+		RotateWait:    0,
+		Exclude:       []string{"*.tmp", "debug-[0-9].log"},
+	})
+
+	// an empty exclude reads every file
+	configs, err = pilot.getLogConfigs("/path/to/json.log", mounts, "", map[string]string{
+		"aliyun.logs.app":         "/logs/app.log",
+		"aliyun.logs.app.exclude": "",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(configs[0].Tail.Exclude, check.HasLen, 0)
+
+	for labels, expected := range map[[3]string]string{
+		{"/logs/app.log", "start", "middle"}:        "in log app: start must be beginning or end, not middle",
+		{"/logs/app.log", "encoding", "ebcdic"}:     "in log app: unsupported encoding ebcdic",
+		{"stdout", "encoding", "gbk"}:               "in log app: stdout is always utf-8",
+		{"/logs/app.log", "scan_frequency", "10"}:   "in log app: scan_frequency must be a whole number of seconds .*",
+		{"/logs/app.log", "close_inactive", "1.5s"}: "in log app: close_inactive must be a whole number of seconds .*",
+		{"/logs/app.log", "scan_frequency", "0s"}:   "in log app: close_inactive and scan_frequency can't be 0",
+		{"/logs/app.log", "ignore_older", "1h"}:     "in log app: ignore_older must be greater than close_inactive",
+		{"/logs/app.log", "exclude", "old/*.log"}:   "in log app: exclude must be file name globs, not old/\\*.log",
+		{"/logs/app.log", "exclude", "a[b"}:         "in log app: exclude must be file name globs, not a\\[b",
+	} {
+		_, err := pilot.getLogConfigs("/path/to/json.log", mounts, "", map[string]string{
+			"aliyun.logs.app":              labels[0],
+			"aliyun.logs.app." + labels[1]: labels[2],
+		})
+		c.Assert(err, check.ErrorMatches, expected, check.Commentf("%v", labels))
+	}
+}
+
+func (s *TailSuite) TestExcludeRegexps(c *check.C) {
+	tail := TailOptions{Exclude: []string{"*.gz", "debug-[0-9]?.log", "a+b.log", `a\[b*`, `[^a-c\]]x.log`}}
+	exclude := tail.ExcludeRegexps()
+	for path, excluded := range map[string]bool{
+		"/logs/app.log.gz":     true,
+		"/logs/gz/app.log":     false,
+		"/logs/debug-12.log":   true,
+		"/logs/debug-a1.log":   false,
+		"/logs/a+b.log":        true,
+		"/logs/aab.log":        false,
+		"/logs/x/debug-1x.log": true,
+		"/logs/a[b.log":        true,
+		"/logs/ab.log":         false,
+		"/logs/dx.log":         true,
+		"/logs/]x.log":         false,
+		"/logs/bx.log":         false,
+	} {
+		matched := false
+		for _, expr := range exclude {
+			matched = matched || regexp.MustCompile(expr).MatchString(path)
+		}
+		c.Assert(matched, check.Equals, excluded, check.Commentf(path))
+	}
+	c.Assert(tail.ExcludePaths([]string{"/host/logs/*/app.log"}), check.DeepEquals, []string{
+		"/host/logs/*/*.gz", "/host/logs/*/debug-[0-9]?.log", "/host/logs/*/a+b.log",
+		`/host/logs/*/a\[b*`, `/host/logs/*/[^a-c\]]x.log`,
+	})
+
+	for _, glob := range []string{`a[b`, `a\`, `[]`, `[-a]`, `[a-]`, `[^`, `[a\`} {
+		_, err := nameGlobRegexp(glob)
+		c.Assert(err, check.Equals, filepath.ErrBadPattern, check.Commentf(glob))
+	}
+}
+
+func (s *TailSuite) TestTemplates(c *check.C) {
+	tail := defaultTailOptions()
+	tail.Start = TAIL_START_END
+	tail.Encoding, tail.RubyEncoding = "gbk", "GBK"
+	tail.IgnoreOlder = 48 * time.Hour
+	tail.RotateWait = 0
+	configs := []*LogConfig{{
+		Name:    "app",
+		HostDir: "/host/data",
+		File:    "app.log",
+		Paths:   []string{"/host/data/app.log"},
+		Format:  "none",
+		Tail:    tail,
+	}}
+
+	p, _ := newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "filebeat/filebeat.tpl")))
+	out, err := p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	cfg, err := yaml.NewConfig([]byte(out))
+	c.Assert(err, check.IsNil)
+	var prospectors []struct {
+		TailFiles     bool     `config:"tail_files"`
+		IgnoreOlder   string   `config:"ignore_older"`
+		CloseInactive string   `config:"close_inactive"`
+		ScanFrequency string   `config:"scan_frequency"`
+		CloseRenamed  bool     `config:"close_renamed"`
+		Encoding      string   `config:"encoding"`
+		ExcludeFiles  []string `config:"exclude_files"`
+	}
+	c.Assert(cfg.Unpack(&prospectors), check.IsNil)
+	c.Assert(prospectors[0].TailFiles, check.Equals, true)
+	c.Assert(prospectors[0].IgnoreOlder, check.Equals, "172800s")
+	c.Assert(prospectors[0].CloseInactive, check.Equals, "7200s")
+	c.Assert(prospectors[0].ScanFrequency, check.Equals, "10s")
+	c.Assert(prospectors[0].CloseRenamed, check.Equals, true)
+	c.Assert(prospectors[0].Encoding, check.Equals, "gbk")
+	c.Assert(prospectors[0].ExcludeFiles, check.DeepEquals, []string{`(^|/)[^/]*\.gz$`, `(^|/)[^/]*\.zip$`})
+
+	p, _ = newTestPilot(c, newFakeDocker(), WithTemplate(readAsset(c, "fluentd/fluentd.tpl")))
+	out, err = p.render("c1", map[string]string{}, configs)
+	c.Assert(err, check.IsNil)
+	for _, expected := range []string{
+		`read_from_head false\n`,
+		`refresh_interval 10\n`,
+		`rotate_wait 0\n`,
+		`limit_recently_modified 172800\n`,
+		`from_encoding GBK\s+encoding UTF-8\n`,
+		`exclude_path '\["/host/data/\*\.gz","/host/data/\*\.zip"\]'\n`,
+	} {
+		c.Assert(out, check.Matches, "(?s).*"+expected+".*")
+	}
+}
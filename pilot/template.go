@@ -0,0 +1,141 @@
+package pilot
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// templateFuncs are available to the templates rendering container configs.
+var templateFuncs = template.FuncMap{
+	"escapeVars":    escapeVars,
+	"fluentdString": fluentdString,
+	"join":          join,
+	"merge":         merge,
+	"nest":          nest,
+	"seconds":       seconds,
+	"toJson":        toJson,
+	"toYaml":        toYaml,
+	"yamlString":    yamlString,
+}
+
+// join joins items with sep, to be piped a list.
+func join(sep string, items []string) string {
+	return strings.Join(items, sep)
+}
+
+// merge returns the union of maps, later maps win.
+func merge(maps ...map[string]string) map[string]string {
+	ret := make(map[string]string)
+	for _, m := range maps {
+		for k, v := range m {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// nest turns dotted keys into nested maps: container.id becomes
+// {container: {id: ...}}. A key which is both a value and a parent stays flat.
+func nest(m map[string]string) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for key, value := range m {
+		if _, ok := ret[key]; !ok {
+			ret[key] = value
+		}
+	}
+
+	for key, value := range m {
+		parts := strings.Split(key, ".")
+		if len(parts) == 1 {
+			continue
+		}
+		node := ret
+		nested := true
+		for i, part := range parts[:len(parts)-1] {
+			child, ok := node[part]
+			if !ok {
+				if _, flat := m[strings.Join(parts[:i+1], ".")]; flat {
+					nested = false
+					break
+				}
+				child = make(map[string]interface{})
+				node[part] = child
+			}
+			childMap, ok := child.(map[string]interface{})
+			if !ok {
+				nested = false
+				break
+			}
+			node = childMap
+		}
+		if nested {
+			delete(ret, key)
+			node[parts[len(parts)-1]] = value
+		}
+	}
+	return ret
+}
+
+// seconds returns a duration in whole seconds.
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+// toJson marshals v in json, which is valid yaml as well.
+func toJson(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// toYaml marshals v and indents every line by indent spaces.
+func toYaml(indent int, v interface{}) (string, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	prefix := strings.Repeat(" ", indent)
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// yamlString quotes s as a yaml scalar of a beats config, where $ starts a
+// variable unless doubled.
+func yamlString(s string) (string, error) {
+	b, err := json.Marshal(strings.Replace(s, "$", "$$", -1))
+	return string(b), err
+}
+
+// escapeVars doubles the $ of the strings of v, so beats don't expand
+// variables in them. Keys are not expanded and stay as is.
+func escapeVars(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return strings.Replace(v, "$", "$$", -1)
+	case map[string]string:
+		ret := make(map[string]string, len(v))
+		for key, value := range v {
+			ret[key] = strings.Replace(value, "$", "$$", -1)
+		}
+		return ret
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			ret[key] = escapeVars(value)
+		}
+		return ret
+	}
+	return v
+}
+
+// fluentdString quotes s as a single quoted value of a fluentd config,
+// which neither embeds ruby nor ends at a newline or #.
+func fluentdString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
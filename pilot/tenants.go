@@ -0,0 +1,299 @@
+package pilot
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+const POLICY_REJECT = "reject"
+const POLICY_REWRITE = "rewrite"
+
+// TenantsConfig maps tenants to the targets their logs may be sent to.
+type TenantsConfig struct {
+	// Label is the container label naming the tenant of containers out of
+	// kubernetes, the namespace is the tenant of the others.
+	Label string `config:"label"`
+	// Policies of tenants, the first one matching a tenant applies.
+	Policies []*TenantPolicyConfig `config:"policies"`
+	// Default is the policy of tenants no policy matches, whose tenants
+	// are ignored. Their logs are left as declared without it.
+	Default *TenantPolicyConfig `config:"default"`
+}
+
+// TenantPolicyConfig restricts the targets of some tenants.
+type TenantPolicyConfig struct {
+	// Tenants are globs of tenant names, "" is the tenant of containers out of kubernetes.
+	Tenants []string `config:"tenants"`
+	// Targets are globs of the targets, topics and indexes allowed, any when empty.
+	Targets []string `config:"targets"`
+	// Outputs are globs of the named outputs allowed, any when empty. The
+	// output of the agent is always allowed.
+	Outputs []string `config:"outputs"`
+	// Tags are added to every log, replacing the declared ones.
+	Tags map[string]string `config:"tags"`
+	// Action on a target not allowed: reject the log (default) or rewrite it to Target.
+	Action string `config:"action"`
+	// Target replaces the targets not allowed, with the rewrite action.
+	Target string `config:"target"`
+}
+
+type tenantPolicy struct {
+	tenants []*regexp.Regexp
+	targets []*regexp.Regexp
+	outputs []*regexp.Regexp
+	tags    map[string]string
+	action  string
+	target  string
+}
+
+type tenantPolicies struct {
+	label         string
+	policies      []*tenantPolicy
+	defaultPolicy *tenantPolicy
+}
+
+// policyDecision is what a tenant policy did to a log.
+type policyDecision struct {
+	tenant string
+	log    string
+	// action is allowed, rewritten or rejected
+	action string
+	reason string
+}
+
+func (d policyDecision) String() string {
+	if d.reason == "" {
+		return fmt.Sprintf("log %s of tenant %q %s", d.log, d.tenant, d.action)
+	}
+	return fmt.Sprintf("log %s of tenant %q %s: %s", d.log, d.tenant, d.action, d.reason)
+}
+
+// WithTenants restricts the targets of the logs of tenants.
+func WithTenants(config *TenantsConfig) Option {
+	return func(p *Pilot) error {
+		tenants := &tenantPolicies{label: config.Label}
+		for i, c := range config.Policies {
+			if len(c.Tenants) == 0 {
+				return fmt.Errorf("tenant policy %d: tenants can't be empty", i)
+			}
+			policy, err := newTenantPolicy(c)
+			if err != nil {
+				return fmt.Errorf("tenant policy %d: %v", i, err)
+			}
+			tenants.policies = append(tenants.policies, policy)
+		}
+		if config.Default != nil {
+			policy, err := newTenantPolicy(config.Default)
+			if err != nil {
+				return fmt.Errorf("default tenant policy: %v", err)
+			}
+			tenants.defaultPolicy = policy
+		}
+		p.tenants = tenants
+		return nil
+	}
+}
+
+func newTenantPolicy(config *TenantPolicyConfig) (*tenantPolicy, error) {
+	policy := &tenantPolicy{tags: config.Tags, action: config.Action, target: config.Target}
+	for _, glob := range config.Tenants {
+		policy.tenants = append(policy.tenants, globRegexp(glob))
+	}
+	for _, glob := range config.Targets {
+		policy.targets = append(policy.targets, globRegexp(glob))
+	}
+	for _, glob := range config.Outputs {
+		policy.outputs = append(policy.outputs, globRegexp(glob))
+	}
+	for key, value := range config.Tags {
+		if err := checkValue(key + value); err != nil {
+			return nil, fmt.Errorf("tag %s: %v", key, err)
+		}
+	}
+
+	switch policy.action {
+	case "":
+		policy.action = POLICY_REJECT
+	case POLICY_REJECT:
+	case POLICY_REWRITE:
+		if !validName.MatchString(policy.target) {
+			return nil, fmt.Errorf("rewrite needs a valid target, not %q", policy.target)
+		}
+		if !policy.allows(policy.target) {
+			return nil, fmt.Errorf("target %s is not allowed by the policy itself", policy.target)
+		}
+	default:
+		return nil, fmt.Errorf("action must be %s or %s, not %s", POLICY_REJECT, POLICY_REWRITE, policy.action)
+	}
+	return policy, nil
+}
+
+func (t *tenantPolicy) allows(target string) bool {
+	return matchesAny(t.targets, target)
+}
+
+// matchesAny tells whether s matches one of globs, any s when there is none.
+func matchesAny(globs []*regexp.Regexp, s string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, re := range globs {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedOutputs returns the named outputs of a log the policy doesn't allow.
+func (t *tenantPolicy) deniedOutputs(config *LogConfig) []string {
+	outputs := []string{config.Output}
+	for _, destination := range config.Destinations {
+		outputs = append(outputs, destination.Output)
+	}
+	var denied []string
+	for _, output := range outputs {
+		if output != "" && !matchesAny(t.outputs, output) {
+			denied = append(denied, "output "+output)
+		}
+	}
+	return denied
+}
+
+// denied returns what the policy doesn't allow of a target and the routing
+// tags in tags.
+func (t *tenantPolicy) denied(target string, tags map[string]string) []string {
+	var denied []string
+	if !t.allows(target) {
+		denied = append(denied, "target "+target)
+	}
+	for key := range routingTags {
+		if value, ok := tags[key]; ok && value != target && !t.allows(value) {
+			denied = append(denied, key+" "+value)
+		}
+	}
+	return denied
+}
+
+// rewriteTags replaces the routing tags set in tags by target.
+func rewriteTags(tags map[string]string, target string) {
+	for key := range routingTags {
+		if _, ok := tags[key]; ok {
+			tags[key] = target
+		}
+	}
+}
+
+// uniq sorts strings and removes duplicates.
+func uniq(items []string) []string {
+	sort.Strings(items)
+	var ret []string
+	for i, item := range items {
+		if i == 0 || item != items[i-1] {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// tenantOf returns the tenant of a container. The label is only read out of
+// kubernetes, where pods could label themselves into another tenant.
+func (t *tenantPolicies) tenantOf(containerJSON *types.ContainerJSON) string {
+	labels := containerJSON.Config.Labels
+	if namespace := labels[LABEL_K8S_POD_NAMESPACE]; namespace != "" || t.label == "" {
+		return namespace
+	}
+	return labels[t.label]
+}
+
+func (t *tenantPolicies) policyOf(tenant string) *tenantPolicy {
+	for _, policy := range t.policies {
+		for _, re := range policy.tenants {
+			if re.MatchString(tenant) {
+				return policy
+			}
+		}
+	}
+	return t.defaultPolicy
+}
+
+// apply enforces the policy of the tenant of a container on its logs, after
+// their labels are parsed. Logs are rewritten in place, the error is a
+// logErrors of the rejected ones. It's nil-safe.
+func (t *tenantPolicies) apply(containerJSON *types.ContainerJSON, configs []*LogConfig) ([]policyDecision, error) {
+	if t == nil {
+		return nil, nil
+	}
+	tenant := t.tenantOf(containerJSON)
+	policy := t.policyOf(tenant)
+	if policy == nil {
+		return nil, nil
+	}
+
+	var decisions []policyDecision
+	var rejected logErrors
+	for _, config := range configs {
+		decision := policyDecision{tenant: tenant, log: config.Name, action: "allowed"}
+
+		target := config.Target
+		if target == "" {
+			target = config.Name
+		}
+		denied := policy.denied(target, config.Tags)
+		for _, destination := range config.Destinations {
+			destinationTarget := target
+			if destination.Target != "" {
+				destinationTarget = destination.Target
+			}
+			denied = append(denied, policy.denied(destinationTarget, destination.Tags)...)
+		}
+
+		// outputs can't be rewritten, logs sent to others are rejected
+		deniedOutputs := policy.deniedOutputs(config)
+
+		if len(denied) > 0 || len(deniedOutputs) > 0 {
+			denied = uniq(append(denied, deniedOutputs...))
+			reason := strings.Join(denied, " and ") + " not allowed"
+			if policy.action == POLICY_REWRITE && len(deniedOutputs) == 0 {
+				decision.action = "rewritten"
+				decision.reason = fmt.Sprintf("%s, sent to %s", reason, policy.target)
+				config.Target = policy.target
+				rewriteTags(config.Tags, policy.target)
+				for i := range config.Destinations {
+					destination := &config.Destinations[i]
+					if destination.Target != "" {
+						destination.Target = policy.target
+					}
+					rewriteTags(destination.Tags, policy.target)
+				}
+			} else {
+				decision.action = "rejected"
+				decision.reason = reason
+				rejected = rejected.add(config.Name, fmt.Errorf("in log %s: %s for tenant %q", config.Name, reason, tenant))
+			}
+		}
+
+		if config.Tags == nil && len(policy.tags) > 0 {
+			config.Tags = make(map[string]string)
+		}
+		for key, value := range policy.tags {
+			config.Tags[key] = value
+		}
+		// tags of destinations replace the ones of the log, forced ones win
+		for i := range config.Destinations {
+			destination := &config.Destinations[i]
+			if destination.Tags == nil {
+				continue
+			}
+			for key, value := range policy.tags {
+				destination.Tags[key] = value
+			}
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions, rejected.asError()
+}
@@ -0,0 +1,271 @@
+package pilot
+
+import (
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+type TenantsSuite struct{}
+
+var _ = check.Suite(&TenantsSuite{})
+
+const tenantsConfig = `
+tenants:
+  label: tenant
+  policies:
+    - tenants: [team-a, team-a-*]
+      targets: [team-a-*]
+      tags: {tenant: team-a}
+    - tenants: [team-b]
+      targets: [team-b-*]
+      action: rewrite
+      target: team-b-default
+`
+
+func (s *TenantsSuite) TestApply(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, tenantsConfig)))
+
+	for _, t := range []struct {
+		labels    map[string]string
+		decisions []string
+		err       string
+		target    string
+		topic     string
+	}{
+		// namespaces are tenants
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-a-dev", "aliyun.logs.team-a-app": "stdout"},
+			[]string{`log team-a-app of tenant "team-a-dev" allowed`}, "", "", "team-a-app"},
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-a", "aliyun.logs.app": "stdout", "aliyun.logs.app.target": "team-a-app"},
+			[]string{`log app of tenant "team-a" allowed`}, "", "team-a-app", "team-a-app"},
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-a", "aliyun.logs.app": "stdout", "aliyun.logs.app.target": "team-b-app"},
+			[]string{`log app of tenant "team-a" rejected: target team-b-app not allowed`},
+			`in log app: target team-b-app not allowed for tenant "team-a"`, "", ""},
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-a", "aliyun.logs.team-a-app": "stdout", "aliyun.logs.team-a-app.tags": "topic=payments"},
+			[]string{`log team-a-app of tenant "team-a" rejected: topic payments not allowed`},
+			`in log team-a-app: topic payments not allowed for tenant "team-a"`, "", ""},
+		// the index routes logs as the topic does
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-a", "aliyun.logs.team-a-app": "stdout", "aliyun.logs.team-a-app.tags": "index=team-b-secrets"},
+			[]string{`log team-a-app of tenant "team-a" rejected: index team-b-secrets not allowed`},
+			`in log team-a-app: index team-b-secrets not allowed for tenant "team-a"`, "", ""},
+		// the label names tenants out of kubernetes
+		{map[string]string{"tenant": "team-b", "aliyun.logs.app": "stdout", "aliyun.logs.app.target": "team-a-app"},
+			[]string{`log app of tenant "team-b" rewritten: target team-a-app not allowed, sent to team-b-default`},
+			"", "team-b-default", "team-b-default"},
+		// but pods can't label themselves into another tenant
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-b", "tenant": "team-a", "aliyun.logs.app": "stdout", "aliyun.logs.app.target": "team-a-app"},
+			[]string{`log app of tenant "team-b" rewritten: target team-a-app not allowed, sent to team-b-default`},
+			"", "team-b-default", "team-b-default"},
+		// no policy for other tenants
+		{map[string]string{"aliyun.logs.app": "stdout", "aliyun.logs.app.target": "team-a-app"},
+			nil, "", "team-a-app", "team-a-app"},
+	} {
+		containerJSON := fakeContainer("c1", t.labels)
+		plan, err := p.planContainer(&containerJSON)
+		var decisions []string
+		for _, decision := range plan.decisions {
+			decisions = append(decisions, decision.String())
+		}
+		c.Assert(decisions, check.DeepEquals, t.decisions, check.Commentf("%v", t.labels))
+		c.Assert(err, check.IsNil)
+		if t.err != "" {
+			// rejected logs are left out
+			c.Assert(plan.logErrors.asError(), check.ErrorMatches, t.err)
+			c.Assert(plan.logConfigs, check.HasLen, 0)
+			continue
+		}
+		c.Assert(plan.logConfigs[0].Target, check.Equals, t.target)
+		c.Assert(plan.logConfigs[0].Tags["topic"], check.Equals, t.topic)
+		if t.labels[LABEL_K8S_POD_NAMESPACE] == "team-a" && t.labels["tenant"] == "" {
+			c.Assert(plan.logConfigs[0].Tags["tenant"], check.Equals, "team-a")
+		}
+	}
+}
+
+func (s *TenantsSuite) TestRewriteIndex(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, tenantsConfig)))
+	containerJSON := fakeContainer("c1", map[string]string{
+		LABEL_K8S_POD_NAMESPACE:       "team-b",
+		"aliyun.logs.team-b-app":      "stdout",
+		"aliyun.logs.team-b-app.tags": "index=team-a-secrets",
+	})
+	plan, err := p.planContainer(&containerJSON)
+	c.Assert(err, check.IsNil)
+	c.Assert(plan.decisions[0].String(), check.Equals,
+		`log team-b-app of tenant "team-b" rewritten: index team-a-secrets not allowed, sent to team-b-default`)
+	c.Assert(plan.logConfigs[0].Tags["index"], check.Equals, "team-b-default")
+}
+
+func (s *TenantsSuite) TestForcedTags(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, tenantsConfig)))
+	containerJSON := fakeContainer("c1", map[string]string{
+		LABEL_K8S_POD_NAMESPACE:       "team-a",
+		"aliyun.logs.team-a-app":      "stdout",
+		"aliyun.logs.team-a-app.tags": "tenant=team-b,stage=dev",
+	})
+	plan, err := p.planContainer(&containerJSON)
+	c.Assert(err, check.IsNil)
+	c.Assert(plan.logConfigs[0].Tags, check.DeepEquals, map[string]string{
+		"tenant": "team-a",
+		"stage":  "dev",
+		"topic":  "team-a-app",
+	})
+
+	// tags of destinations replace the ones of the log, but not forced ones
+	p, _ = newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, tenantsConfig+outputsConfig)))
+	containerJSON = fakeContainer("c1", map[string]string{
+		LABEL_K8S_POD_NAMESPACE:                     "team-a",
+		"aliyun.logs.team-a-app":                    "stdout",
+		"aliyun.logs.team-a-app.outputs":            "audit,es",
+		"aliyun.logs.team-a-app.outputs.audit.tags": "tenant=team-b,stage=audit",
+	})
+	plan, err = p.planContainer(&containerJSON)
+	c.Assert(err, check.IsNil)
+	c.Assert(plan.logConfigs[0].Destinations[0].Tags, check.DeepEquals, map[string]string{
+		"tenant": "team-a",
+		"stage":  "audit",
+	})
+	c.Assert(plan.logConfigs[0].Destinations[1].Tags, check.IsNil)
+}
+
+func (s *TenantsSuite) TestDefault(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, tenantsConfig+`
+  default:
+    targets: [shared-*]
+    action: rewrite
+    target: shared-default
+`)))
+	for labels, target := range map[[2]string]string{
+		{"team-c", "team-a-app"}: "shared-default",
+		{"team-c", "shared-app"}: "shared-app",
+		{"team-a", "team-a-app"}: "team-a-app",
+	} {
+		containerJSON := fakeContainer("c1", map[string]string{
+			LABEL_K8S_POD_NAMESPACE:  labels[0],
+			"aliyun.logs.app":        "stdout",
+			"aliyun.logs.app.target": labels[1],
+		})
+		plan, err := p.planContainer(&containerJSON)
+		c.Assert(err, check.IsNil)
+		c.Assert(plan.logConfigs[0].Target, check.Equals, target, check.Commentf("%v", labels))
+	}
+
+	_, err := New(WithTenants(newTestConfig(c, "tenants:\n  default: {action: drop}").Tenants))
+	c.Assert(err, check.ErrorMatches, "default tenant policy: action must be reject or rewrite, not drop")
+}
+
+func (s *TenantsSuite) TestMetricsEscaping(c *check.C) {
+	// %q would escape the tab as well
+	counter := (&metrics{}).counter("test_total", "Test.", "tenant")
+	c.Assert(counter.key([]string{"team\t\"a\"\\\n"}), check.Equals, "tenant=\"team\t\\\"a\\\"\\\\\\n\"")
+}
+
+func (s *TenantsSuite) TestMetrics(c *check.C) {
+	docker := newFakeDocker()
+	p, _ := newTestPilot(c, docker, WithConfig(newTestConfig(c, tenantsConfig)))
+	for id, labels := range map[string]map[string]string{
+		"c1": {LABEL_K8S_POD_NAMESPACE: "team-a", "aliyun.logs.team-a-app": "stdout", "aliyun.logs.other": "stdout"},
+		"c2": {LABEL_K8S_POD_NAMESPACE: "team-b", "aliyun.logs.app": "stdout"},
+	} {
+		containerJSON := fakeContainer(id, labels)
+		p.newContainer(&containerJSON)
+	}
+	c.Assert(p.policyDecisions.get("team-a", "allowed"), check.Equals, uint64(1))
+	c.Assert(p.policyDecisions.get("team-a", "rejected"), check.Equals, uint64(1))
+	c.Assert(p.policyDecisions.get("team-b", "rewritten"), check.Equals, uint64(1))
+
+	w := httptest.NewRecorder()
+	p.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	c.Assert(w.Body.String(), check.Equals, `# HELP log_pilot_policy_decisions_total Logs allowed, rewritten or rejected by tenant policies.
+# TYPE log_pilot_policy_decisions_total counter
+log_pilot_policy_decisions_total{tenant="team-a",action="allowed"} 1
+log_pilot_policy_decisions_total{tenant="team-a",action="rejected"} 1
+log_pilot_policy_decisions_total{tenant="team-b",action="rewritten"} 1
+# HELP log_pilot_invalid_logs_total Log declarations left out of containers for their errors.
+# TYPE log_pilot_invalid_logs_total counter
+log_pilot_invalid_logs_total 1
+`)
+}
+
+func (s *TenantsSuite) TestOutputs(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, outputsConfig+`
+tenants:
+  policies:
+    - tenants: [team-a]
+      outputs: [es]
+    - tenants: [team-b]
+      outputs: [es]
+      action: rewrite
+      target: team-b-default
+`)))
+
+	for _, t := range []struct {
+		labels map[string]string
+		err    string
+	}{
+		// the default output is checked as well
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-a", "aliyun.logs.app": "stdout"}, ""},
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-a", "aliyun.logs.app": "stdout", "aliyun.logs.app.output": "audit"},
+			`in log app: output audit not allowed for tenant "team-a"`},
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-a", "aliyun.logs.app": "stdout", "aliyun.logs.app.outputs": "es,audit"},
+			`in log app: output audit not allowed for tenant "team-a"`},
+		// outputs are never rewritten
+		{map[string]string{LABEL_K8S_POD_NAMESPACE: "team-b", "aliyun.logs.app": "stdout", "aliyun.logs.app.output": "audit"},
+			`in log app: output audit not allowed for tenant "team-b"`},
+	} {
+		containerJSON := fakeContainer("c1", t.labels)
+		plan, err := p.planContainer(&containerJSON)
+		c.Assert(err, check.IsNil)
+		if t.err == "" {
+			c.Assert(plan.logErrors.asError(), check.IsNil)
+			c.Assert(plan.logConfigs, check.HasLen, 1)
+			continue
+		}
+		c.Assert(plan.logErrors.asError(), check.ErrorMatches, t.err, check.Commentf("%v", t.labels))
+		c.Assert(plan.logConfigs, check.HasLen, 0)
+		c.Assert(plan.decisions[0].action, check.Equals, "rejected")
+	}
+}
+
+func (s *TenantsSuite) TestInvalid(c *check.C) {
+	for config, expected := range map[string]string{
+		"policies: [{targets: [a-*]}]":                                           "tenant policy 0: tenants can't be empty",
+		"policies: [{tenants: [a], action: drop}]":                               "tenant policy 0: action must be reject or rewrite, not drop",
+		"policies: [{tenants: [a], action: rewrite}]":                            `tenant policy 0: rewrite needs a valid target, not ""`,
+		"policies: [{tenants: [a], targets: [a-*], action: rewrite, target: b}]": "tenant policy 0: target b is not allowed by the policy itself",
+	} {
+		_, err := New(WithTenants(newTestConfig(c, "tenants:\n  "+config).Tenants))
+		c.Assert(err, check.ErrorMatches, expected)
+	}
+}
+
+func (s *TenantsSuite) TestFanOut(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, tenantsConfig+outputsConfig)))
+
+	containerJSON := fakeContainer("c1", map[string]string{
+		LABEL_K8S_POD_NAMESPACE:                       "team-a",
+		"aliyun.logs.team-a-app":                      "stdout",
+		"aliyun.logs.team-a-app.outputs":              "audit,es",
+		"aliyun.logs.team-a-app.outputs.audit.target": "payments",
+	})
+	plan, err := p.planContainer(&containerJSON)
+	c.Assert(err, check.IsNil)
+	c.Assert(plan.logErrors.asError(), check.ErrorMatches, `in log team-a-app: target payments not allowed for tenant "team-a"`)
+	c.Assert(plan.decisions[0].action, check.Equals, "rejected")
+
+	containerJSON = fakeContainer("c2", map[string]string{
+		LABEL_K8S_POD_NAMESPACE:                "team-b",
+		"aliyun.logs.app":                      "stdout",
+		"aliyun.logs.app.target":               "team-b-app",
+		"aliyun.logs.app.outputs":              "audit,es",
+		"aliyun.logs.app.outputs.audit.target": "payments",
+		"aliyun.logs.app.outputs.es.tags":      "topic=team-b-app",
+	})
+	plan, err = p.planContainer(&containerJSON)
+	c.Assert(err, check.IsNil)
+	c.Assert(plan.decisions[0].String(), check.Equals,
+		`log app of tenant "team-b" rewritten: target payments not allowed, sent to team-b-default`)
+	c.Assert(plan.logConfigs[0].Destinations[0].Target, check.Equals, "team-b-default")
+	c.Assert(plan.logConfigs[0].Destinations[1].Target, check.Equals, "")
+	c.Assert(plan.logConfigs[0].Destinations[1].Tags["topic"], check.Equals, "team-b-default")
+}
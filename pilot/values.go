@@ -0,0 +1,245 @@
+package pilot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/docker/docker/api/types"
+)
+
+// metadataKeys are the keys container() sets when a container has them.
+// Templated values read them as "" otherwise, other keys are unknown.
+var metadataKeys = []string{
+	"docker_app",
+	"docker_service",
+	"k8s_pod",
+	"k8s_pod_namespace",
+	"k8s_container_name",
+	"k8s_node_name",
+	"docker_container_name",
+	"docker_container_created",
+	"docker_container_image",
+	"docker_container_id",
+	"rancher_stack",
+	"rancher_stack_service",
+}
+
+// MAX_VALUE_LENGTH caps templated values once expanded.
+const MAX_VALUE_LENGTH = 1024
+
+var errValueTooLong = fmt.Errorf("expanded value is longer than %d bytes", MAX_VALUE_LENGTH)
+
+// isTemplate tells whether a tag value or target is a template, like
+// app-{{.k8s_pod_namespace}}.
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// valueFuncs are the only functions of templated values, builtins of
+// text/template are rejected: they read the container and transform strings.
+func valueFuncs(labels map[string]string, env map[string]string) template.FuncMap {
+	return template.FuncMap{
+		"label": func(name string) string { return labels[name] },
+		"env":   func(name string) string { return env[name] },
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"replace": func(old, new, s string) (string, error) {
+			// chained replaces would grow values exponentially
+			if len(s)+strings.Count(s, old)*(len(new)-len(old)) > MAX_VALUE_LENGTH {
+				return "", errValueTooLong
+			}
+			return strings.Replace(s, old, new, -1), nil
+		},
+		"default": func(value, s string) string {
+			if s == "" {
+				return value
+			}
+			return s
+		},
+	}
+}
+
+func parseValueTemplate(value string, funcs template.FuncMap) (*template.Template, error) {
+	tpl, err := template.New("value").Option("missingkey=error").Funcs(funcs).Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(tpl.Templates()) > 1 {
+		return nil, fmt.Errorf("define is not allowed")
+	}
+	if err := checkValueNode(tpl.Tree.Root, funcs); err != nil {
+		return nil, err
+	}
+	return tpl, nil
+}
+
+// checkValueNode allows text, field access, strings and pipelines of
+// valueFuncs only: no control structure, variable or builtin.
+func checkValueNode(node parse.Node, funcs template.FuncMap) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			if err := checkValueNode(child, funcs); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkValueNode(n.Pipe, funcs)
+	case *parse.PipeNode:
+		if len(n.Decl) > 0 {
+			return fmt.Errorf("variables are not allowed")
+		}
+		for _, cmd := range n.Cmds {
+			if err := checkValueNode(cmd, funcs); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := checkValueNode(arg, funcs); err != nil {
+				return err
+			}
+		}
+	case *parse.IdentifierNode:
+		if _, ok := funcs[n.Ident]; !ok {
+			return fmt.Errorf("function %s is not allowed", n.Ident)
+		}
+	case *parse.TextNode, *parse.FieldNode, *parse.StringNode, *parse.DotNode:
+	case *parse.IfNode:
+		return fmt.Errorf("if is not allowed")
+	case *parse.RangeNode:
+		return fmt.Errorf("range is not allowed")
+	case *parse.WithNode:
+		return fmt.Errorf("with is not allowed")
+	case *parse.TemplateNode:
+		return fmt.Errorf("template is not allowed")
+	case *parse.VariableNode:
+		return fmt.Errorf("variables are not allowed")
+	default:
+		return fmt.Errorf("%s is not allowed", node)
+	}
+	return nil
+}
+
+// limitedBuffer fails writes beyond MAX_VALUE_LENGTH.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > MAX_VALUE_LENGTH {
+		return 0, errValueTooLong
+	}
+	return b.Buffer.Write(p)
+}
+
+// checkTemplate checks the syntax of a templated value, when labels are parsed.
+func checkTemplate(value string) error {
+	_, err := parseValueTemplate(value, valueFuncs(nil, nil))
+	return err
+}
+
+// valueContext evaluates the templated values of the logs of a container.
+type valueContext struct {
+	metadata map[string]string
+	funcs    template.FuncMap
+}
+
+func newValueContext(containerJSON *types.ContainerJSON, metadata map[string]string) *valueContext {
+	v := &valueContext{metadata: make(map[string]string)}
+	for _, key := range metadataKeys {
+		v.metadata[key] = ""
+	}
+	for key, value := range metadata {
+		v.metadata[key] = value
+	}
+	env := make(map[string]string)
+	for _, e := range containerJSON.Config.Env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 {
+			env[kv[0]] = kv[1]
+		}
+	}
+	v.funcs = valueFuncs(containerJSON.Config.Labels, env)
+	return v
+}
+
+// expand evaluates value if it's a template.
+func (v *valueContext) expand(value string) (string, error) {
+	if !isTemplate(value) {
+		return value, nil
+	}
+	tpl, err := parseValueTemplate(value, v.funcs)
+	if err != nil {
+		return "", err
+	}
+	var buf limitedBuffer
+	if err := tpl.Execute(&buf, v.metadata); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (v *valueContext) expandTarget(target string) (string, error) {
+	expanded, err := v.expand(target)
+	if err != nil {
+		return "", err
+	}
+	if expanded != target && !validName.MatchString(expanded) {
+		return "", fmt.Errorf("%q is not a valid target: only letters, digits, _, . and - are allowed", expanded)
+	}
+	return expanded, nil
+}
+
+func (v *valueContext) expandTags(tags map[string]string) error {
+	for key, value := range tags {
+		expanded, err := v.expand(value)
+		if err != nil {
+			return fmt.Errorf("tag %s: %v", key, err)
+		}
+		if expanded == "" {
+			return fmt.Errorf("tag %s: %s is empty", key, value)
+		}
+		if err := checkValue(expanded); err != nil {
+			return fmt.Errorf("tag %s: %v", key, err)
+		}
+		tags[key] = expanded
+	}
+	return nil
+}
+
+// expandValues evaluates the templated targets and tags of the logs of a
+// container, in place. The error is a logErrors.
+func (p *Pilot) expandValues(containerJSON *types.ContainerJSON, metadata map[string]string, configs []*LogConfig) error {
+	v := newValueContext(containerJSON, metadata)
+	var errs logErrors
+	for _, config := range configs {
+		if err := v.expandLog(config); err != nil {
+			errs = errs.add(config.Name, err)
+		}
+	}
+	return errs.asError()
+}
+
+func (v *valueContext) expandLog(config *LogConfig) error {
+	var err error
+	if config.Target, err = v.expandTarget(config.Target); err != nil {
+		return fmt.Errorf("in log %s: target: %v", config.Name, err)
+	}
+	if err := v.expandTags(config.Tags); err != nil {
+		return fmt.Errorf("in log %s: %v", config.Name, err)
+	}
+	for i := range config.Destinations {
+		destination := &config.Destinations[i]
+		if destination.Target, err = v.expandTarget(destination.Target); err != nil {
+			return fmt.Errorf("in log %s: target for output %s: %v", config.Name, destination.Output, err)
+		}
+		if err := v.expandTags(destination.Tags); err != nil {
+			return fmt.Errorf("in log %s: output %s: %v", config.Name, destination.Output, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package pilot
+
+import (
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+type ValuesSuite struct{}
+
+var _ = check.Suite(&ValuesSuite{})
+
+func (s *ValuesSuite) TestExpand(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker(), WithConfig(newTestConfig(c, outputsConfig)))
+	containerJSON := fakeContainer("c1", map[string]string{
+		LABEL_K8S_POD_NAMESPACE:             "Team-A",
+		"env":                               "prod",
+		"aliyun.logs.app":                   "stdout",
+		"aliyun.logs.app.target":            "app-{{ lower .k8s_pod_namespace }}",
+		"aliyun.logs.app.tags":              `env={{label "env"}},region={{env "REGION" | default "none"}},image={{replace "/" "-" .docker_container_image}}`,
+		"aliyun.logs.app.outputs":           "es",
+		"aliyun.logs.app.outputs.es.target": "{{upper .docker_container_name}}",
+		"aliyun.logs.app.outputs.es.tags":   "stage={{.rancher_stack | default \"dev\"}}",
+		"aliyun.logs.plain":                 "stdout",
+		"aliyun.logs.plain.tags":            "env=prod",
+	})
+	containerJSON.Config.Image = "library/busybox"
+	containerJSON.Config.Env = []string{"REGION=eu"}
+
+	plan, err := p.planContainer(&containerJSON)
+	c.Assert(err, check.IsNil)
+	app := plan.logConfigs[0]
+	c.Assert(app.Target, check.Equals, "app-team-a")
+	c.Assert(app.Tags, check.DeepEquals, map[string]string{
+		"env":    "prod",
+		"region": "eu",
+		"image":  "library-busybox",
+		"topic":  "app-team-a",
+	})
+	c.Assert(app.Destinations[0].Target, check.Equals, "C1")
+	c.Assert(app.Destinations[0].Tags, check.DeepEquals, map[string]string{"stage": "dev"})
+	c.Assert(plan.logConfigs[1].Tags, check.DeepEquals, map[string]string{"env": "prod", "topic": "plain"})
+}
+
+func (s *ValuesSuite) TestInvalid(c *check.C) {
+	p, _ := newTestPilot(c, newFakeDocker())
+	for _, t := range []struct {
+		labels   map[string]string
+		expected string
+	}{
+		// syntax errors are found when labels are parsed
+		{map[string]string{"aliyun.logs.app.target": "app-{{ .k8s_pod"},
+			"in log app: target: template: value:1: .*"},
+		{map[string]string{"aliyun.logs.app.tags": "env={{ printenv }}"},
+			`parse tags for app error: tag env: template: value:1: function "printenv" not defined`},
+		// keys containers don't have are empty, others are unknown
+		{map[string]string{"aliyun.logs.app.target": "app-{{ .k8s_namespace }}"},
+			`in log app: target: template: value:1:.* map has no entry for key "k8s_namespace"`},
+		{map[string]string{"aliyun.logs.app.target": "{{ .k8s_pod_namespace }}"},
+			`in log app: target: "" is not a valid target: only letters, digits, _, . and - are allowed`},
+		{map[string]string{"aliyun.logs.app.tags": `env={{ label "env" }}`},
+			`in log app: tag env: {{ label "env" }} is empty`},
+		{map[string]string{"aliyun.logs.app.target": `{{ label "target" }}`, "target": "a/b"},
+			`in log app: target: "a/b" is not a valid target: .*`},
+		// only the value functions, no builtin nor control structure
+		{map[string]string{"aliyun.logs.app.tags": `env={{ printf "%0999999999d" 1 }}`},
+			`parse tags for app error: tag env: function printf is not allowed`},
+		{map[string]string{"aliyun.logs.app.tags": `env={{ index .x 0 }}`},
+			`parse tags for app error: tag env: function index is not allowed`},
+		{map[string]string{"aliyun.logs.app.target": `{{ range .k8s_pod }}x{{ end }}`},
+			`in log app: target: range is not allowed`},
+		{map[string]string{"aliyun.logs.app.target": `{{ with $x := .k8s_pod }}{{ $x }}{{ end }}`},
+			`in log app: target: with is not allowed`},
+		{map[string]string{"aliyun.logs.app.target": `{{ $x := .k8s_pod }}{{ $x }}`},
+			`in log app: target: variables are not allowed`},
+		{map[string]string{"aliyun.logs.app.target": `{{ define "x" }}a{{ end }}b`},
+			`in log app: target: define is not allowed`},
+		// values can't grow out of bounds
+		{map[string]string{"aliyun.logs.app.target": `{{ label "x" | replace "a" "aaaaaaaaaaaaaaaa" | replace "a" "aaaaaaaaaaaaaaaa" | replace "a" "aaaaaaaaaaaaaaaa" }}`, "x": "aaaaaaaa"},
+			`in log app: target: .*expanded value is longer than 1024 bytes`},
+		{map[string]string{"aliyun.logs.app.target": `{{ label "x" }}{{ label "x" }}`, "x": strings.Repeat("a", 600)},
+			`in log app: target: .*expanded value is longer than 1024 bytes`},
+	} {
+		t.labels["aliyun.logs.app"] = "stdout"
+		containerJSON := fakeContainer("c1", t.labels)
+		plan, err := p.planContainer(&containerJSON)
+		c.Assert(err, check.IsNil)
+		c.Assert(plan.logErrors.asError(), check.ErrorMatches, t.expected, check.Commentf("%v", t.labels))
+	}
+}